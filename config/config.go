@@ -1,36 +1,168 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/gofigure"
 )
 
 // Config holds the session handler configuration
 type Config struct {
-	gofigure          interface{} `order:"env,flag"`
-	DefaultExpiration string      `env:"DEFAULT_SESSION_EXPIRATION" flag:"default-expiration" flagDesc:"Default Expiration"`
-	CookieName        string      `env:"COOKIE_NAME"                flag:"cookie-name"        flagDesc:"Cookie Name"`
-	CookieSecret      string      `env:"COOKIE_SECRET"              flag:"cookie-secret"      flagDesc:"Cookie Secret"`
-	CacheServer       string      `env:"CACHE_SERVER"               flag:"cache-server"       flagDesc:"Cache Server"`
-	CacheDB           int         `env:"CACHE_DB"                   flag:"cache-db"           flagDesc:"Cache DB"`
-	CachePassword     string      `env:"CACHE_PASSWORD"             flag:"cache-password"     flagDesc:"Cache Password"`
+	gofigure                interface{} `order:"env,flag"`
+	DefaultExpiration       string      `env:"DEFAULT_SESSION_EXPIRATION" flag:"default-expiration" flagDesc:"Default Expiration"`
+	CookieName              string      `env:"COOKIE_NAME"                flag:"cookie-name"        flagDesc:"Cookie Name"`
+	CookieSecret            string      `env:"COOKIE_SECRET"              flag:"cookie-secret"      flagDesc:"Cookie Secret"`
+	CookieSecretFallbacks   []string    `env:"COOKIE_SECRET_FALLBACKS"    flag:"cookie-secret-fallbacks" flagDesc:"Cookie Secret Fallbacks"`
+	CacheServer             string      `env:"CACHE_SERVER"               flag:"cache-server"       flagDesc:"Cache Server"`
+	CacheDB                 int         `env:"CACHE_DB"                   flag:"cache-db"           flagDesc:"Cache DB"`
+	CacheUsername           string      `env:"CACHE_USERNAME"             flag:"cache-username"     flagDesc:"Cache Username"`
+	CachePassword           string      `env:"CACHE_PASSWORD"             flag:"cache-password"     flagDesc:"Cache Password"`
+	CacheWarmConnections    int         `env:"CACHE_WARM_CONNECTIONS"     flag:"cache-warm-connections" flagDesc:"Cache Warm Connections"`
+	CacheReadTimeout        int         `env:"CACHE_READ_TIMEOUT"         flag:"cache-read-timeout" flagDesc:"Cache Read Timeout"`
+	CacheWriteTimeout       int         `env:"CACHE_WRITE_TIMEOUT"        flag:"cache-write-timeout" flagDesc:"Cache Write Timeout"`
+	CacheKeepAlive          int         `env:"CACHE_KEEP_ALIVE"           flag:"cache-keep-alive"   flagDesc:"Cache Keep Alive"`
+	SessionIDOctets         int         `env:"SESSION_ID_OCTETS"          flag:"session-id-octets"  flagDesc:"Session ID Octets"`
+	SessionIDURLSafe        bool        `env:"SESSION_ID_URL_SAFE"        flag:"session-id-url-safe" flagDesc:"Session ID URL Safe"`
+	IdleTimeout             int         `env:"SESSION_IDLE_TIMEOUT"       flag:"idle-timeout"       flagDesc:"Idle Timeout"`
+	SlidingExpiration       bool        `env:"SESSION_SLIDING_EXPIRATION" flag:"sliding-expiration" flagDesc:"Sliding Expiration"`
+	MaxSessionBytes         int         `env:"SESSION_MAX_BYTES"          flag:"max-session-bytes"  flagDesc:"Max Session Bytes"`
+	MaxRenewals             int         `env:"SESSION_MAX_RENEWALS"       flag:"max-renewals"       flagDesc:"Max Renewals"`
+	ExpirationJitter        int         `env:"SESSION_EXPIRATION_JITTER"  flag:"expiration-jitter"  flagDesc:"Expiration Jitter"`
+	DataIntegrity           bool        `env:"SESSION_DATA_INTEGRITY"     flag:"data-integrity"     flagDesc:"Data Integrity"`
+	CookieSecure            bool        `env:"COOKIE_SECURE"              flag:"cookie-secure"      flagDesc:"Cookie Secure"`
+	CookieSameSite          string      `env:"COOKIE_SAME_SITE"           flag:"cookie-same-site"   flagDesc:"Cookie SameSite"`
+	CookiePartitioned       bool        `env:"COOKIE_PARTITIONED"         flag:"cookie-partitioned" flagDesc:"Cookie Partitioned"`
+	CreateAnonymousSessions bool        `env:"SESSION_CREATE_ANONYMOUS"   flag:"create-anonymous"   flagDesc:"Create Anonymous Sessions"`
+	RegenerateOnSignIn      bool        `env:"SESSION_REGENERATE_ON_SIGN_IN" flag:"regenerate-on-sign-in" flagDesc:"Regenerate On Sign In"`
+	CookieFallback          bool        `env:"SESSION_COOKIE_FALLBACK"    flag:"cookie-fallback"    flagDesc:"Cookie Fallback"`
 }
 
-var cfg *Config
+// MinCookieSecretLength is the minimum number of characters a cookie secret must
+// have to be considered secure
+const MinCookieSecretLength = 32
+
+var (
+	mu  sync.RWMutex
+	cfg *Config
+)
 
-// Get returns a populated Config struct
+// Get returns a populated Config struct, loading it on first use. It is safe
+// for concurrent use
 func Get() *Config {
 
+	mu.RLock()
+	loaded := cfg
+	mu.RUnlock()
+
+	if loaded != nil {
+		return loaded
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Another caller may have loaded it while the lock was released
 	if cfg != nil {
 		return cfg
 	}
 
-	cfg = &Config{}
-
-	if err := gofigure.Gofigure(cfg); err != nil {
+	loaded, err := load()
+	if err != nil {
 		log.Error(err)
 		return nil
 	}
 
+	cfg = loaded
 	return cfg
 }
+
+// Reload re-reads the config from the environment and flags, e.g. to pick up a
+// rotated secret, and atomically replaces the config returned by Get. If it
+// can't be read, the error is returned and the current config is kept
+func Reload() (*Config, error) {
+
+	loaded, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	cfg = loaded
+	mu.Unlock()
+
+	return loaded, nil
+}
+
+// load reads a new Config from the environment and flags
+func load() (*Config, error) {
+	loaded := &Config{}
+	if err := gofigure.Gofigure(loaded); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// MustGet returns a populated Config struct, as Get does, but panics if the
+// config can't be loaded or fails validation, so that a service fails fast on
+// startup rather than on its first request
+func MustGet() *Config {
+	if err := Validate(); err != nil {
+		panic(err)
+	}
+	return Get()
+}
+
+// Validate checks that the config has been loaded and that every required field
+// is set, with a cookie secret long enough to be secure
+func Validate() error {
+	c := Get()
+	if c == nil {
+		return errors.New("Config could not be loaded from the environment")
+	}
+	return c.validate()
+}
+
+// validate checks that every required field of the config is set
+func (c *Config) validate() error {
+	var missing []string
+	if c.CookieSecret == "" {
+		missing = append(missing, "COOKIE_SECRET")
+	}
+	if c.CookieName == "" {
+		missing = append(missing, "COOKIE_NAME")
+	}
+	if c.CacheServer == "" {
+		missing = append(missing, "CACHE_SERVER")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("Environment variables missing: %s", strings.Join(missing, ", "))
+	}
+
+	if len(c.CookieSecret) < MinCookieSecretLength {
+		return fmt.Errorf("Environment variable COOKIE_SECRET must be at least %d characters", MinCookieSecretLength)
+	}
+
+	if c.DefaultExpiration != "" {
+		if _, err := c.DefaultExpirationSeconds(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DefaultExpirationSeconds parses the default session expiration, returning a
+// clear error naming the environment variable if it isn't a number of seconds
+func (c *Config) DefaultExpirationSeconds() (uint64, error) {
+	seconds, err := strconv.ParseUint(c.DefaultExpiration, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Environment variable DEFAULT_SESSION_EXPIRATION must be a number of seconds, not %q", c.DefaultExpiration)
+	}
+	return seconds, nil
+}
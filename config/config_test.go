@@ -0,0 +1,120 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var requiredEnv = map[string]string{
+	"COOKIE_SECRET": strings.Repeat("s", MinCookieSecretLength),
+	"COOKIE_NAME":   "TEST",
+	"CACHE_SERVER":  "localhost:6379",
+}
+
+func setEnv(env map[string]string) {
+	for key, value := range env {
+		os.Setenv(key, value)
+	}
+	cfg = nil
+}
+
+func cleanupEnv() {
+	for key := range requiredEnv {
+		os.Unsetenv(key)
+	}
+	cfg = nil
+}
+
+// ------------------- Routes Through Validate() -------------------
+
+// TestUnitValidate - Verify that missing required config and short cookie
+// secrets fail validation
+func TestUnitValidate(t *testing.T) {
+
+	Convey("Given every required environment variable is set", t, func() {
+
+		setEnv(requiredEnv)
+		defer cleanupEnv()
+
+		Convey("Then the config should be valid", func() {
+
+			So(Validate(), ShouldBeNil)
+			So(MustGet().CacheServer, ShouldEqual, "localhost:6379")
+		})
+	})
+
+	Convey("Given no required environment variables are set", t, func() {
+
+		cleanupEnv()
+
+		Convey("Then every missing variable should be listed in the error", func() {
+
+			err := Validate()
+
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Environment variables missing: COOKIE_SECRET, COOKIE_NAME, CACHE_SERVER")
+			So(func() { MustGet() }, ShouldPanic)
+		})
+	})
+
+	Convey("Given the cookie secret is too short", t, func() {
+
+		setEnv(requiredEnv)
+		setEnv(map[string]string{"COOKIE_SECRET": "hello"})
+		defer cleanupEnv()
+
+		Convey("Then the config should be invalid", func() {
+
+			err := Validate()
+
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "COOKIE_SECRET must be at least")
+		})
+	})
+
+	Convey("Given the default expiration isn't a number of seconds", t, func() {
+
+		setEnv(requiredEnv)
+		setEnv(map[string]string{"DEFAULT_SESSION_EXPIRATION": "ten minutes"})
+		defer os.Unsetenv("DEFAULT_SESSION_EXPIRATION")
+		defer cleanupEnv()
+
+		Convey("Then the config should be invalid", func() {
+
+			err := Validate()
+
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `Environment variable DEFAULT_SESSION_EXPIRATION must be a number of seconds, not "ten minutes"`)
+		})
+	})
+}
+
+// ------------------- Routes Through Reload() -------------------
+
+// TestUnitReload - Verify that reloading the config picks up changes to the
+// environment made since it was first loaded
+func TestUnitReload(t *testing.T) {
+
+	Convey("Given the config has been loaded", t, func() {
+
+		setEnv(requiredEnv)
+		defer cleanupEnv()
+		So(Get().CookieName, ShouldEqual, "TEST")
+
+		Convey("When an environment variable changes and the config is reloaded", func() {
+
+			os.Setenv("COOKIE_NAME", "ROTATED")
+			reloaded, err := Reload()
+
+			Convey("Then the new value should be returned by Get", func() {
+
+				So(err, ShouldBeNil)
+				So(reloaded.CookieName, ShouldEqual, "ROTATED")
+				So(Get().CookieName, ShouldEqual, "ROTATED")
+			})
+		})
+	})
+}
@@ -4,10 +4,16 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/base64"
+	"errors"
 
 	"github.com/vmihailenco/msgpack"
+	"github.com/vmihailenco/msgpack/codes"
 )
 
+//ErrInvalidSessionFormat is returned when msgpack'd data doesn't hold a map at
+//its top level, e.g. because it is corrupt or was written in another format.
+var ErrInvalidSessionFormat = errors.New("Session data is not a msgpack encoded map")
+
 //DecodeBase64 takes a base64-encoded string and decodes it to a []byte.
 func DecodeBase64(base64Encoded string) ([]byte, error) {
 	base64Decoded, err := base64.StdEncoding.DecodeString(base64Encoded)
@@ -20,23 +26,57 @@ func EncodeBase64(bytes []byte) string {
 	return base64.StdEncoding.EncodeToString(bytes)
 }
 
-//DecodeMsgPack takes a msgpack'd []byte and decodes it to json.
+//DecodeBase64URL takes a string base64-encoded with the URL-safe alphabet and
+//decodes it to a []byte.
+func DecodeBase64URL(base64Encoded string) ([]byte, error) {
+	return base64.URLEncoding.DecodeString(base64Encoded)
+}
+
+// EncodeBase64URL takes a byte array and base 64 encodes it with the URL-safe
+// alphabet, which uses '-' and '_' in place of '+' and '/'
+func EncodeBase64URL(bytes []byte) string {
+	return base64.URLEncoding.EncodeToString(bytes)
+}
+
+//DecodeMsgPack takes a msgpack'd []byte and decodes it to json. If the top
+//level isn't a map (or nil), ErrInvalidSessionFormat is returned.
 func DecodeMsgPack(msgpackEncoded []byte) (map[string]interface{}, error) {
 	var decoded map[string]interface{}
 
+	if err := DecodeMsgPackInto(msgpackEncoded, &decoded); err != nil {
+		return nil, err
+	}
+
+	return decoded, nil
+}
+
+//DecodeMsgPackInto takes a msgpack'd []byte and decodes it into the value v
+//points to, e.g. a struct whose fields are matched to the map's keys by their
+//`msgpack:"..."` tags, for callers who know the session's schema and would
+//rather not assert the type of each value. As with DecodeMsgPack, if the top
+//level isn't a map (or nil), ErrInvalidSessionFormat is returned.
+func DecodeMsgPackInto(msgpackEncoded []byte, v interface{}) error {
 	dec := msgpack.NewDecoder(bytes.NewBuffer(msgpackEncoded))
-	err := dec.Decode(&decoded)
 
-	return decoded, err
+	code, err := dec.PeekCode()
+	if err != nil {
+		return err
+	}
+	if code != codes.Nil && code != codes.Map16 && code != codes.Map32 && !codes.IsFixedMap(code) {
+		return ErrInvalidSessionFormat
+	}
+
+	return dec.Decode(v)
 }
 
 // EncodeMsgPack performs message pack encryption
 // Currently this takes a map[string]interface{} parameter because we only
-// want to message pack encode JSON objects
+// want to message pack encode JSON objects. Map keys are sorted, so the same
+// data always encodes to the same bytes
 func EncodeMsgPack(data map[string]interface{}) ([]byte, error) {
 	var encoded []byte
 	encBuf := bytes.NewBuffer(encoded)
-	enc := msgpack.NewEncoder(encBuf)
+	enc := msgpack.NewEncoder(encBuf).SortMapKeys(true)
 
 	if err := enc.Encode(data); err != nil {
 		return nil, err
@@ -57,6 +57,32 @@ func TestEncodeBase64(t *testing.T) {
 	})
 }
 
+// ------------------- Routes Through EncodeBase64URL() -------------------
+
+// TestEncodeBase64URL - Verify that bytes survive a URL-safe base64 round trip,
+// encoded without '+' or '/'
+func TestEncodeBase64URL(t *testing.T) {
+
+	Convey("Given I call EncodeBase64URL on bytes which standard base64 encodes with '+' and '/'", t, func() {
+
+		test := []byte{0xfb, 0xef, 0xff}
+		encodedString := EncodeBase64URL(test)
+
+		Convey("When I call DecodeBase64URL on the result", func() {
+
+			decoded, err := DecodeBase64URL(encodedString)
+
+			Convey("Then the bytes should be returned, having been encoded URL-safe", func() {
+
+				So(err, ShouldBeNil)
+				So(decoded, ShouldResemble, test)
+				So(EncodeBase64(test), ShouldEqual, "++//")
+				So(encodedString, ShouldEqual, "--__")
+			})
+		})
+	})
+}
+
 // ------------------- Routes Through DecodeMsgPack() -------------------
 
 // TestDecodeMsgPack - Verify no errors are thrown when DecodeMsgPack is called
@@ -86,6 +112,99 @@ func TestDecodeMsgPack(t *testing.T) {
 	})
 }
 
+// TestDecodeMsgPackNonMap - Verify that ErrInvalidSessionFormat is returned when
+// DecodeMsgPack is called on message pack encoded scalars and arrays
+func TestDecodeMsgPackNonMap(t *testing.T) {
+
+	for _, value := range []interface{}{"foo", 42, true, []interface{}{"foo", "bar"}} {
+
+		Convey("Given I message pack encode a value which isn't a map", t, func() {
+
+			encoded, err := msgpack.Marshal(value)
+			So(err, ShouldBeNil)
+
+			Convey("When I call DecodeMsgPack on the result", func() {
+
+				decoded, err := DecodeMsgPack(encoded)
+
+				Convey("Then I expect ErrInvalidSessionFormat to be returned", func() {
+
+					So(err, ShouldEqual, ErrInvalidSessionFormat)
+					So(decoded, ShouldBeNil)
+				})
+			})
+		})
+	}
+}
+
+// ------------------- Routes Through DecodeMsgPackInto() -------------------
+
+// storedSession is a typed view of the session data, as tooling which knows its
+// schema might declare it
+type storedSession struct {
+	Expires    int64 `msgpack:"expires"`
+	SigninInfo struct {
+		SignedIn    int8 `msgpack:"signed_in"`
+		AccessToken struct {
+			AccessToken string `msgpack:"access_token"`
+			ExpiresIn   uint16 `msgpack:"expires_in"`
+		} `msgpack:"access_token"`
+	} `msgpack:"signin_info"`
+}
+
+// TestDecodeMsgPackInto - Verify that an encoded session decodes into a struct
+// with msgpack tags, and that a non-map is rejected
+func TestDecodeMsgPackInto(t *testing.T) {
+
+	Convey("Given I have encoded a session as a Store would", t, func() {
+
+		encoded, err := EncodeMsgPack(map[string]interface{}{
+			"expires": int64(1700000000),
+			"page":    "home",
+			"signin_info": map[string]interface{}{
+				"signed_in": int8(1),
+				"access_token": map[string]interface{}{
+					"access_token": "token",
+					"expires_in":   uint16(3600),
+				},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("When I call DecodeMsgPackInto with a tagged struct", func() {
+
+			var decoded storedSession
+			err := DecodeMsgPackInto(encoded, &decoded)
+
+			Convey("Then the tagged fields should be populated, ignoring the rest", func() {
+
+				So(err, ShouldBeNil)
+				So(decoded.Expires, ShouldEqual, 1700000000)
+				So(decoded.SigninInfo.SignedIn, ShouldEqual, 1)
+				So(decoded.SigninInfo.AccessToken.AccessToken, ShouldEqual, "token")
+				So(decoded.SigninInfo.AccessToken.ExpiresIn, ShouldEqual, 3600)
+			})
+		})
+	})
+
+	Convey("Given I message pack encode a value which isn't a map", t, func() {
+
+		encoded, err := msgpack.Marshal([]interface{}{"foo", "bar"})
+		So(err, ShouldBeNil)
+
+		Convey("When I call DecodeMsgPackInto on the result", func() {
+
+			var decoded storedSession
+			err := DecodeMsgPackInto(encoded, &decoded)
+
+			Convey("Then I expect ErrInvalidSessionFormat to be returned", func() {
+
+				So(err, ShouldEqual, ErrInvalidSessionFormat)
+			})
+		})
+	})
+}
+
 // ------------------- Routes Through EncodeMsgPack() -------------------
 
 // TestEncodeMsgPack - Verify no errors are thrown when EncodeMsgPack is called
@@ -2,7 +2,11 @@ package httpsession
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/go-session-handler/config"
@@ -17,62 +21,502 @@ type ContextKey string
 // ContextKeySession is the key used to fetch the session from the context
 var ContextKeySession = ContextKey("session")
 
+// ContextKeyStore is the key used to fetch the session's Store from the context
+var ContextKeyStore = ContextKey("store")
+
+// ContextKeySessionData is the key used to fetch the session data, as built by
+// the SessionData option, from the context
+var ContextKeySessionData = ContextKey("session_data")
+
+// ContextKeySkipStore is the key used to fetch, from the context, whether the
+// handler has opted out of storing the session
+var ContextKeySkipStore = ContextKey("skip_store")
+
+// ContextKeyNewSession is the key used to fetch, from the context, whether the
+// session was created by this request
+var ContextKeyNewSession = ContextKey("new_session")
+
+// Options holds the configurable behaviour of the session middleware
+type Options struct {
+	// Skipper, if set, is called for every request. If it returns true the
+	// session is neither loaded nor stored, and the next handler is called
+	// directly.
+	Skipper func(req *http.Request) bool
+
+	// CookieName is the name of the cookie holding the session ID. Defaults to
+	// the COOKIE_NAME config value.
+	CookieName string
+
+	// CookiePath, if set, restricts the session cookie to requests under the
+	// given path, so that chains mounted at different paths can keep separate
+	// sessions.
+	CookiePath string
+
+	// CookieSecure, CookieSameSite ("Lax", "Strict" or "None") and
+	// CookiePartitioned set the matching attributes on the session cookie.
+	// Each defaults to its COOKIE_* config value. A SameSite=None cookie is
+	// always Secure, as browsers reject it otherwise, and a Partitioned cookie
+	// must also be SameSite=None, otherwise partitioning is disabled.
+	CookieSecure      bool
+	CookieSameSite    string
+	CookiePartitioned bool
+
+	// CreateAnonymousSessions, if true, creates and stores a session, with a
+	// fresh ID and cookie, for every visitor without one, e.g. to track a
+	// shopping cart before sign in. Otherwise an empty session is only stored
+	// once the handler has added data to it. Defaults to the
+	// SESSION_CREATE_ANONYMOUS config value.
+	CreateAnonymousSessions bool
+
+	// RegenerateOnSignIn, if true, issues the session a new ID, keeping its
+	// data, when a request signs the user in, i.e. the session wasn't signed in
+	// when loaded but is once the handler returns, so that a session ID fixed
+	// before sign in can't be used after it. Requests made while already signed
+	// in keep their ID. Defaults to the SESSION_REGENERATE_ON_SIGN_IN config
+	// value.
+	RegenerateOnSignIn bool
+
+	// CookieFallback, if set, keeps sessions in an encrypted cookie while the
+	// Cache is unreachable, as a degraded mode. See
+	// state.Store.WithCookieFallback. Defaults to one keyed by the cookie
+	// secret if the SESSION_COOKIE_FALLBACK config value is set.
+	CookieFallback *state.CookieFallbackCache
+
+	// Cache is the cache the session is loaded from and stored in. Defaults to
+	// a single cache built from the CACHE_* config values when registered.
+	Cache *state.Cache
+
+	// Serializer is used to encode and decode the session data. Defaults to
+	// the state.MsgPackSerializer.
+	Serializer state.Serializer
+
+	// Transforms, such as state.GzipTransform, are applied in order to the
+	// serialized session data when it is stored. See state.Store.WithTransforms.
+	Transforms []state.Transform
+
+	// ErrorHandler is called if the session can't be loaded, and is
+	// responsible for writing the response. Defaults to responding with a 500.
+	ErrorHandler func(w http.ResponseWriter, req *http.Request, err error)
+
+	// Observer, if set, is notified of every Redis operation performed while
+	// loading and storing the session.
+	Observer state.Observer
+
+	// Metrics, if set, receives session load/store counters and Redis latencies
+	Metrics state.Metrics
+
+	// Tracer, if set, traces the session's Redis operations as children of the
+	// request's context
+	Tracer state.Tracer
+
+	// ReplayTTL and OnReplay, if set, enable the detection of replayed
+	// sessions. See state.Store.WithReplayDetection.
+	ReplayTTL time.Duration
+	OnReplay  func(sessionID string)
+
+	// SessionHooks, if set, are called as each request's session is loaded,
+	// created and destroyed. See state.SessionHooks.
+	SessionHooks state.SessionHooks
+
+	// Logger, if set, receives all log output from the session middleware and
+	// its Store. Defaults to logging with chs.go.
+	Logger state.Logger
+
+	// SessionData, if set, builds the application's own session data type
+	// around each request's session, to be fetched with
+	// GetSessionDataFromRequest. It is still the underlying session map which
+	// is stored, so changes must be made through it.
+	SessionData func(data *session.Session) session.SessionData
+}
+
+// Option is a function used to configure the session middleware Options
+type Option func(*Options)
+
+// WithCookieName sets the name of the cookie holding the session ID
+func WithCookieName(cookieName string) Option {
+	return func(o *Options) {
+		o.CookieName = cookieName
+	}
+}
+
+// WithCookiePath restricts the session cookie to requests under the given path
+func WithCookiePath(cookiePath string) Option {
+	return func(o *Options) {
+		o.CookiePath = cookiePath
+	}
+}
+
+// WithSessionData sets the function building the application's own session data
+// type around each request's session
+func WithSessionData(build func(data *session.Session) session.SessionData) Option {
+	return func(o *Options) {
+		o.SessionData = build
+	}
+}
+
+// WithCache sets the cache the session is loaded from and stored in
+func WithCache(cache *state.Cache) Option {
+	return func(o *Options) {
+		o.Cache = cache
+	}
+}
+
+// WithSerializer sets the Serializer used to encode and decode the session data
+func WithSerializer(serializer state.Serializer) Option {
+	return func(o *Options) {
+		o.Serializer = serializer
+	}
+}
+
+// WithTransforms sets the Transforms applied to the serialized session data
+func WithTransforms(transforms ...state.Transform) Option {
+	return func(o *Options) {
+		o.Transforms = transforms
+	}
+}
+
+// WithErrorHandler sets the function called if the session can't be loaded
+func WithErrorHandler(errorHandler func(w http.ResponseWriter, req *http.Request, err error)) Option {
+	return func(o *Options) {
+		o.ErrorHandler = errorHandler
+	}
+}
+
+// WithObserver sets the Observer notified of every Redis operation performed
+// by the session middleware
+func WithObserver(observer state.Observer) Option {
+	return func(o *Options) {
+		o.Observer = observer
+	}
+}
+
+// WithReplayDetection enables the detection of replayed sessions, calling
+// onReplay if a recently cleared session is loaded
+func WithReplayDetection(ttl time.Duration, onReplay func(sessionID string)) Option {
+	return func(o *Options) {
+		o.ReplayTTL = ttl
+		o.OnReplay = onReplay
+	}
+}
+
+// WithSessionHooks sets the hooks called as each request's session is loaded,
+// created and destroyed. They run synchronously, within the request.
+func WithSessionHooks(hooks state.SessionHooks) Option {
+	return func(o *Options) {
+		o.SessionHooks = hooks
+	}
+}
+
+// WithLogger sets the Logger used by the session middleware and its Store
+func WithLogger(logger state.Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+// WithMetrics sets the Metrics reported to by the session Store
+func WithMetrics(metrics state.Metrics) Option {
+	return func(o *Options) {
+		o.Metrics = metrics
+	}
+}
+
+// WithTracer sets the Tracer used to trace the session Store's Redis operations
+func WithTracer(tracer state.Tracer) Option {
+	return func(o *Options) {
+		o.Tracer = tracer
+	}
+}
+
+// WithSkipper sets the function used to decide whether session handling should
+// be skipped for a request
+func WithSkipper(skipper func(req *http.Request) bool) Option {
+	return func(o *Options) {
+		o.Skipper = skipper
+	}
+}
+
+// WithSkipPaths skips session handling for any request whose URL path begins
+// with one of the given prefixes, e.g. "/healthz" or "/static/"
+func WithSkipPaths(prefixes ...string) Option {
+	return WithSkipper(func(req *http.Request) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(req.URL.Path, prefix) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
 // Register will append an HTTP handler to an Alice chain, whereby the stored
 // session will be loaded and stored on the request context
 func Register(c alice.Chain) alice.Chain {
-	return c.Append(func(h http.Handler) http.Handler { return handler(h) })
+	return RegisterWithOptions(c)
+}
+
+// newCache creates the cache shared by all requests through a chain
+var newCache = state.NewCache
+
+// getConfig returns the config the middleware falls back on, or nil if it
+// couldn't be loaded
+var getConfig = config.Get
+
+// ErrConfigUnavailable is passed to the ErrorHandler for every request when the
+// config couldn't be loaded, e.g. because an environment variable is malformed
+var ErrConfigUnavailable = errors.New("Session handler config could not be loaded from the environment")
+
+// ErrInvalidCookieValue is returned when the session cookie's value contains an
+// octet RFC 6265 doesn't allow in a cookie value, which http.SetCookie would
+// silently drop, e.g. after a change to how session IDs are encoded
+var ErrInvalidCookieValue = errors.New("Session cookie value contains an octet which is invalid in a cookie")
+
+var (
+	cachesMu sync.Mutex
+	caches   []*state.Cache
+)
+
+// Close closes every cache created by RegisterWithOptions from the CACHE_*
+// config values, releasing their connections to Redis. It should be called
+// from a graceful shutdown handler, once no more requests will be served.
+// Caches passed in with WithCache belong to the caller, who should close them
+func Close() error {
+	cachesMu.Lock()
+	closing := caches
+	caches = nil
+	cachesMu.Unlock()
+
+	var firstErr error
+	for _, cache := range closing {
+		if err := cache.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// RegisterWithOptions will append an HTTP handler to an Alice chain in the same
+// way as Register, applying the given options to the session middleware. Any
+// options not supplied fall back to their defaults from config.
+//
+// The cache is created once, here, and shared by every request through the
+// chain. Its underlying Redis client pools connections and will redial if a
+// connection dies, so a lost connection only fails the requests in flight at
+// the time, via the ErrorHandler. If CACHE_KEEP_ALIVE is set, the client is
+// also pinged in the background and rebuilt after repeated failures.
+func RegisterWithOptions(c alice.Chain, opts ...Option) alice.Chain {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if options.ErrorHandler == nil {
+		options.ErrorHandler = options.defaultErrorHandler
+	}
+
+	cfg := getConfig()
+	if cfg == nil {
+		if options.Logger != nil {
+			options.Logger.Error(ErrConfigUnavailable, nil)
+		} else {
+			log.Error(ErrConfigUnavailable)
+		}
+	}
+
+	if cfg != nil && (options.CookieName == "" || options.Cache == nil) {
+
+		if options.CookieName == "" {
+			options.CookieName = cfg.CookieName
+		}
+
+		if options.Cache == nil {
+			options.Cache = newCache(cfg.CacheServer, cfg.CacheDB, cfg.CachePassword).
+				WithWarmUp(cfg.CacheWarmConnections).
+				WithKeepAlive(time.Duration(cfg.CacheKeepAlive) * time.Second)
+
+			cachesMu.Lock()
+			caches = append(caches, options.Cache)
+			cachesMu.Unlock()
+		}
+	}
+
+	if cfg != nil {
+		options.CookieSecure = options.CookieSecure || cfg.CookieSecure
+		options.CookiePartitioned = options.CookiePartitioned || cfg.CookiePartitioned
+		options.CreateAnonymousSessions = options.CreateAnonymousSessions || cfg.CreateAnonymousSessions
+		options.RegenerateOnSignIn = options.RegenerateOnSignIn || cfg.RegenerateOnSignIn
+		if options.CookieSameSite == "" {
+			options.CookieSameSite = cfg.CookieSameSite
+		}
+	}
+
+	if cfg != nil && cfg.CookieFallback && options.CookieFallback == nil {
+		fallback, err := state.NewCookieFallbackCache(cfg.CookieSecret, cfg.CookieSecretFallbacks...)
+		if err != nil {
+			if options.Logger != nil {
+				options.Logger.Error(err, nil)
+			} else {
+				log.Error(err)
+			}
+		}
+		options.CookieFallback = fallback
+	}
+
+	// Browsers reject SameSite=None cookies which aren't also Secure
+	if options.CookieSameSite == "None" {
+		options.CookieSecure = true
+	}
+
+	if err := options.validateCookieAttributes(); err != nil {
+		if options.Logger != nil {
+			options.Logger.Error(err, nil)
+		} else {
+			log.Error(err)
+		}
+		options.CookiePartitioned = false
+	}
+
+	return c.Append(func(h http.Handler) http.Handler { return handler(h, options) })
 }
 
-// handler initialises a Store using config and cache structs, loads the
-// session, and stores it on the request context to access later
-func handler(h http.Handler) http.Handler {
+// handler initialises a Store using the configured options, loads the session,
+// and stores it on the request context to access later
+func handler(h http.Handler, options *Options) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 
-		// Init all config
-		cfg := config.Get()
+		// Bypass session handling entirely for skipped requests
+		if options.Skipper != nil && options.Skipper(req) {
+			h.ServeHTTP(w, req)
+			return
+		}
 
-		cache := state.NewCache(cfg.CacheServer, cfg.CacheDB, cfg.CachePassword)
+		s := state.NewStore(options.Cache).
+			WithSerializer(options.Serializer).
+			WithTransforms(options.Transforms...).
+			WithObserver(options.Observer).
+			WithMetrics(options.Metrics).
+			WithTracer(options.Tracer).
+			WithContext(req.Context()).
+			WithReplayDetection(options.ReplayTTL, options.OnReplay).
+			WithSessionHooks(options.SessionHooks).
+			WithCookieFallback(options.CookieFallback).
+			WithLogger(options.Logger)
 
-		s := state.NewStore(cache)
+		// Sessions can't be signed or expired without config, so fail the request
+		// clearly rather than panicking
+		if getConfig() == nil || options.Cache == nil {
+			options.ErrorHandler(w, req, ErrConfigUnavailable)
+			return
+		}
 
 		// Pull session ID from the cookie on the request
-		sessionID := getSessionIDFromRequest(cfg.CookieName, req)
+		sessionID := getSessionIDFromRequest(options.CookieName, req)
 		var sess session.Session
+		outcome := state.LoadNotFound
 
 		// If session is stored, retrieve it from Redis
 		if sessionID != "" {
 
-			if err := s.Load(sessionID); err == nil {
-				sess = s.Data
-			} else {
-				log.ErrorR(req, err)
-				w.WriteHeader(http.StatusInternalServerError)
+			loaded, err := s.LoadResult(sessionID)
+			if err != nil {
+				options.ErrorHandler(w, req, err)
 				return
 			}
+			sess, outcome = s.Data, loaded
 		}
 
-		ctx := context.WithValue(context.Background(), ContextKeySession, &sess)
+		// A session is new if no valid session was found for the cookie, if any
+		isNew := outcome != state.LoadLoaded
+		wasSignedIn := sess.IsSignedIn()
+
+		// Start an anonymous session so that it's stored even if left empty
+		if sess == nil && options.CreateAnonymousSessions {
+			sess = session.Session{}
+		}
+
+		ctx := context.WithValue(req.Context(), ContextKeySession, &sess)
+		ctx = context.WithValue(ctx, ContextKeyNewSession, isNew)
+		ctx = context.WithValue(ctx, ContextKeyStore, s)
+		skipStore := new(bool)
+		ctx = context.WithValue(ctx, ContextKeySkipStore, skipStore)
+		if options.SessionData != nil {
+			ctx = context.WithValue(ctx, ContextKeySessionData, options.SessionData(&sess))
+		}
 		req = req.WithContext(ctx)
 		h.ServeHTTP(w, req)
 
+		// Leave the session and cookie alone if the handler has asked to
+		if *skipStore {
+			return
+		}
+
+		// Don't resurrect a session the handler has cleared, e.g. on logout
+		if s.Cleared() {
+			expireSessionCookie(w, options)
+			return
+		}
+
 		s.Data = sess
 
-		err := s.Store()
+		var err error
+		if options.RegenerateOnSignIn && !wasSignedIn && sess.IsSignedIn() {
+			// Signed in by this request, so stop using the ID it was made with
+			err = s.RegenerateKeepData()
+		} else {
+			err = s.Store()
+		}
 		if err != nil {
-			log.ErrorR(req, err)
+			options.logError(req, err)
+		} else {
+			options.logChangedKeys(req, s)
 		}
 
-		setSessionIDOnResponse(w, s)
+		if err := setSessionIDOnResponse(w, options, s); err != nil {
+			options.logError(req, err)
+		}
 	})
 }
 
+// defaultErrorHandler logs the error and responds with a 500
+func (o *Options) defaultErrorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	o.logError(req, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// logError logs an error for the request using the configured Logger, or with
+// chs.go if there isn't one
+func (o *Options) logError(req *http.Request, err error) {
+	if o.Logger == nil {
+		log.ErrorR(req, err)
+		return
+	}
+	o.Logger.Error(err, map[string]interface{}{"method": req.Method, "path": req.URL.Path})
+}
+
+// logChangedKeys logs, at info level, the keys of the session data the request
+// changed, but not their values, so that changes can be audited
+func (o *Options) logChangedKeys(req *http.Request, s *state.Store) {
+	changed := s.ChangedKeys()
+	if len(changed) == 0 {
+		return
+	}
+
+	data := map[string]interface{}{"session_id": s.ID, "changed_keys": changed}
+	if o.Logger == nil {
+		log.InfoR(req, "Session data changed", log.Data(data))
+		return
+	}
+	data["method"], data["path"] = req.Method, req.URL.Path
+	o.Logger.Info("Session data changed", data)
+}
+
 // getSessionIDFromRequest will attempt to pull the session ID from the cookie on
-// the request. If err is not nil, an empty string will be returned instead.
+// the request. If the cookie isn't present, e.g. on a first visit, an empty
+// string will be returned instead.
 func getSessionIDFromRequest(cookieName string, req *http.Request) string {
 
 	cookie, err := req.Cookie(cookieName)
 	if err != nil {
-		log.ErrorR(req, err)
 		return ""
 	}
 
@@ -80,13 +524,82 @@ func getSessionIDFromRequest(cookieName string, req *http.Request) string {
 }
 
 // setSessionIDOnResponse will refresh the session cookie in case the ID has been
-// changed since load
-func setSessionIDOnResponse(w http.ResponseWriter, s *state.Store) {
-	cookie := &http.Cookie{
-		Value: s.ID + s.GenerateSignature(),
-		Name:  config.Get().CookieName,
+// changed since load. The cookie is left alone, returning ErrInvalidCookieValue,
+// if its value wouldn't survive being set intact
+func setSessionIDOnResponse(w http.ResponseWriter, options *Options, s *state.Store) error {
+	value := s.CookieValue()
+	if err := validateCookieValue(value); err != nil {
+		return err
+	}
+
+	setCookie(w, options, &http.Cookie{Value: value})
+	return nil
+}
+
+// validateCookieValue checks that every octet of the value is a cookie-octet,
+// as defined by RFC 6265: printable US-ASCII other than space, '"', ',', ';'
+// and '\'. Both the standard and URL-safe base64 alphabets are valid, but the
+// URL-safe one (see SESSION_ID_URL_SAFE) avoids characters which other layers
+// may escape
+func validateCookieValue(value string) error {
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c <= ' ', c >= 0x7f, c == '"', c == ',', c == ';', c == '\\':
+			return ErrInvalidCookieValue
+		}
 	}
-	http.SetCookie(w, cookie)
+	return nil
+}
+
+// expireSessionCookie tells the browser to delete the session cookie
+func expireSessionCookie(w http.ResponseWriter, options *Options) {
+	setCookie(w, options, &http.Cookie{MaxAge: -1, Expires: time.Unix(0, 0)})
+}
+
+// setCookie sets the session cookie with the given value and expiry on the
+// response, along with the configured cookie attributes
+func setCookie(w http.ResponseWriter, options *Options, cookie *http.Cookie) {
+	cookie.Name = options.CookieName
+	cookie.Path = options.CookiePath
+	cookie.Secure = options.CookieSecure
+
+	switch options.CookieSameSite {
+	case "Lax":
+		cookie.SameSite = http.SameSiteLaxMode
+	case "Strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	}
+
+	value := cookie.String()
+	if value == "" {
+		return
+	}
+
+	// http.Cookie can't express SameSite=None or Partitioned in the Go version
+	// we support, so they're appended to the header by hand
+	if options.CookieSameSite == "None" {
+		value += "; SameSite=None"
+	}
+	if options.CookiePartitioned {
+		value += "; Partitioned"
+	}
+	w.Header().Add("Set-Cookie", value)
+}
+
+// validateCookieAttributes checks the session cookie's attributes are ones
+// browsers will accept, since a Partitioned cookie is rejected unless it is
+// also SameSite=None and Secure
+func (o *Options) validateCookieAttributes() error {
+	switch o.CookieSameSite {
+	case "", "Lax", "Strict", "None":
+	default:
+		return errors.New("Cookie SameSite must be Lax, Strict or None, not " + o.CookieSameSite)
+	}
+
+	if o.CookiePartitioned && (o.CookieSameSite != "None" || !o.CookieSecure) {
+		return errors.New("Partitioned cookies must be SameSite=None and Secure, so partitioning has been disabled")
+	}
+	return nil
 }
 
 // GetSessionFromRequest retrieves session data from a given request,
@@ -98,3 +611,45 @@ func GetSessionFromRequest(req *http.Request) *session.Session {
 	}
 	return nil
 }
+
+// GetSessionDataFromRequest retrieves the session data from a given request as
+// the type built by the SessionData option, or as the session itself if the
+// option isn't set
+func GetSessionDataFromRequest(req *http.Request) session.SessionData {
+	if data, ok := req.Context().Value(ContextKeySessionData).(session.SessionData); ok {
+		return data
+	}
+	if s := GetSessionFromRequest(req); s != nil {
+		return s
+	}
+	return nil
+}
+
+// ClearSession destroys the session on a given request, e.g. on logout. Once
+// the handler returns, the session middleware won't store the session again,
+// and instead tells the browser to delete the session cookie
+func ClearSession(req *http.Request) error {
+	s, ok := req.Context().Value(ContextKeyStore).(*state.Store)
+	if !ok {
+		return errors.New("No session store found on the request")
+	}
+	return s.Clear()
+}
+
+// SkipStore stops the session middleware storing the session and refreshing
+// the session cookie once the handler for a given request returns, e.g. for a
+// file download or event stream which doesn't touch the session. It has no
+// effect on a request which hasn't been through the session middleware
+func SkipStore(req *http.Request) {
+	if skip, ok := req.Context().Value(ContextKeySkipStore).(*bool); ok {
+		*skip = true
+	}
+}
+
+// IsNewSession reports whether the session on a given request was freshly
+// started by the session middleware, because no valid session cookie was
+// presented, rather than being loaded from the cache
+func IsNewSession(req *http.Request) bool {
+	isNew, _ := req.Context().Value(ContextKeyNewSession).(bool)
+	return isNew
+}
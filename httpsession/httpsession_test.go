@@ -1,10 +1,26 @@
 package httpsession
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/companieshouse/go-session-handler/config"
+	session "github.com/companieshouse/go-session-handler/session"
+	"github.com/companieshouse/go-session-handler/state"
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	"github.com/justinas/alice"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	redis "gopkg.in/redis.v5"
 )
 
 // ---------------- Routes Through getSessionIDFromRequest() ----------------
@@ -58,3 +74,1102 @@ func TestUnitGetSessionIDFromRequestHappyPath(t *testing.T) {
 		})
 	})
 }
+
+// ---------------- Routes Through handler() ----------------
+
+// TestUnitHandlerSkippedPath - Verify that if a request matches a skipped path,
+// the next handler is called without the cache being touched
+func TestUnitHandlerSkippedPath(t *testing.T) {
+
+	Convey("Given the session middleware is configured to skip '/healthz'", t, func() {
+
+		connection := &mockState.Connection{}
+
+		options := &Options{Cache: state.NewCacheWithConnection(connection)}
+		WithSkipPaths("/healthz", "/static/")(options)
+
+		called := false
+		next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			called = true
+		})
+
+		Convey("When a request is made to '/healthz'", func() {
+
+			req := httptest.NewRequest("GET", "/healthz", nil)
+			req.AddCookie(&http.Cookie{Name: "TEST", Value: "Foo"})
+			w := httptest.NewRecorder()
+
+			handler(next, options).ServeHTTP(w, req)
+
+			Convey("Then the next handler should be called without a session", func() {
+
+				So(called, ShouldBeTrue)
+				So(GetSessionFromRequest(req), ShouldBeNil)
+
+				Convey("And the cache should never be called, nor a cookie set", func() {
+
+					connection.AssertNotCalled(t, "Get", mock.Anything)
+					connection.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything)
+					So(len(connection.Calls), ShouldEqual, 0)
+					So(w.Header().Get("Set-Cookie"), ShouldBeBlank)
+				})
+			})
+		})
+	})
+}
+
+// TestUnitWithSkipPaths - Verify that only requests whose path begins with a
+// configured prefix are skipped
+func TestUnitWithSkipPaths(t *testing.T) {
+
+	Convey("Given I configure the skipped paths '/healthz' and '/static/'", t, func() {
+
+		options := &Options{}
+		WithSkipPaths("/healthz", "/static/")(options)
+
+		Convey("Then matching requests should be skipped", func() {
+
+			So(options.Skipper(httptest.NewRequest("GET", "/healthz", nil)), ShouldBeTrue)
+			So(options.Skipper(httptest.NewRequest("GET", "/static/app.css", nil)), ShouldBeTrue)
+
+			Convey("And any other request should not", func() {
+
+				So(options.Skipper(httptest.NewRequest("GET", "/company/123", nil)), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+// ---------------- Routes Through RegisterWithOptions() ----------------
+
+// TestUnitRegisterWithOptionsCookieNames - Verify that two chains registered with
+// different cookie names each set their own session cookie
+func TestUnitRegisterWithOptionsCookieNames(t *testing.T) {
+
+	Convey("Given I register an admin and a public chain with different cookie names", t, func() {
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+		admin := RegisterWithOptions(alice.New(),
+			WithCookieName("ADMIN"),
+			WithCache(state.NewCacheWithConnection(&mockState.Connection{})),
+		).Then(next)
+
+		public := RegisterWithOptions(alice.New(),
+			WithCookieName("PUBLIC"),
+			WithCache(state.NewCacheWithConnection(&mockState.Connection{})),
+		).Then(next)
+
+		Convey("When a request is made through each chain", func() {
+
+			adminResponse := httptest.NewRecorder()
+			admin.ServeHTTP(adminResponse, httptest.NewRequest("GET", "/admin", nil))
+
+			publicResponse := httptest.NewRecorder()
+			public.ServeHTTP(publicResponse, httptest.NewRequest("GET", "/public", nil))
+
+			Convey("Then each response should carry the cookie for its own chain", func() {
+
+				So(adminResponse.Header().Get("Set-Cookie"), ShouldStartWith, "ADMIN=")
+				So(publicResponse.Header().Get("Set-Cookie"), ShouldStartWith, "PUBLIC=")
+			})
+		})
+	})
+}
+
+// TestUnitRegisterWithOptionsCookieNameRoundTrip - Verify that the cookie written
+// to the response has the same name as the cookie the session was read from
+func TestUnitRegisterWithOptionsCookieNameRoundTrip(t *testing.T) {
+
+	Convey("Given a session is stored and sent in a tenant's cookie", t, func() {
+
+		cfg := config.Get()
+		expiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "60"
+		defer func() { cfg.DefaultExpiration = expiration }()
+
+		cache := state.NewMemoryCache()
+		stored := state.NewStore(cache)
+		stored.Data = map[string]interface{}{
+			"expires": uint32(time.Now().Unix() + 3600),
+		}
+		So(stored.Store(), ShouldBeNil)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "TENANT", Value: stored.CookieValue()})
+
+		Convey("When a request is made through the tenant's chain", func() {
+
+			var loaded *session.Session
+			chain := RegisterWithOptions(alice.New(),
+				WithCookieName("TENANT"),
+				WithCache(cache),
+			).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				loaded = GetSessionFromRequest(req)
+			}))
+
+			w := httptest.NewRecorder()
+			chain.ServeHTTP(w, req)
+
+			Convey("Then the session should be read from, and written back to, the same cookie", func() {
+
+				So(loaded, ShouldNotBeNil)
+				So((*loaded)["expires"], ShouldNotBeNil)
+				So(w.Header().Get("Set-Cookie"), ShouldStartWith, "TENANT="+stored.CookieValue())
+			})
+		})
+	})
+}
+
+// TestUnitRegisterWithOptionsErrorHandler - Verify that the configured error
+// handler is called if the session can't be loaded
+func TestUnitRegisterWithOptionsErrorHandler(t *testing.T) {
+
+	Convey("Given I register a chain with a custom error handler", t, func() {
+
+		id := strings.Repeat("a", 28)
+		store := state.NewStore(nil)
+		store.ID = id
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult("", errors.New("Redis is down")))
+
+		var handledErr error
+		chain := RegisterWithOptions(alice.New(),
+			WithCookieName("TEST"),
+			WithCache(state.NewCacheWithConnection(connection)),
+			WithErrorHandler(func(w http.ResponseWriter, req *http.Request, err error) {
+				handledErr = err
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}),
+		).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+		Convey("When the session fails to load", func() {
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "TEST", Value: id + store.GenerateSignature()})
+			w := httptest.NewRecorder()
+
+			chain.ServeHTTP(w, req)
+
+			Convey("Then the error handler should be responsible for the response", func() {
+
+				So(handledErr, ShouldNotBeNil)
+				So(handledErr.Error(), ShouldEqual, "Redis is down")
+				So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			})
+		})
+	})
+}
+
+// TestUnitRegisterWithOptionsSharedCache - Verify that the cache is created once
+// on registration and reused, and recovers, across requests
+func TestUnitRegisterWithOptionsSharedCache(t *testing.T) {
+
+	Convey("Given I register a chain without supplying a cache", t, func() {
+
+		id := strings.Repeat("a", 28)
+		store := state.NewStore(nil)
+		store.ID = id
+
+		connection := &mockState.Connection{}
+		created := 0
+
+		defer func(original func(string, int, string) *state.Cache) { newCache = original }(newCache)
+		newCache = func(addr string, db int, password string) *state.Cache {
+			created++
+			return state.NewCacheWithConnection(connection)
+		}
+
+		chain := RegisterWithOptions(alice.New(), WithCookieName("TEST")).
+			Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+		newRequest := func() *http.Request {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "TEST", Value: id + store.GenerateSignature()})
+			return req
+		}
+
+		Convey("When the cache's connection dies and then recovers between requests", func() {
+
+			connection.On("Get", id).Return(redis.NewStringResult("", errors.New("connection reset"))).Once()
+			connection.On("Get", id).Return(redis.NewStringResult("", redis.Nil))
+			connection.On("Set", mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.Anything).
+				Return(redis.NewStatusResult("", nil))
+
+			failed := httptest.NewRecorder()
+			chain.ServeHTTP(failed, newRequest())
+
+			recovered := httptest.NewRecorder()
+			chain.ServeHTTP(recovered, newRequest())
+
+			Convey("Then only the request in flight should fail, and a single cache should be used", func() {
+
+				So(created, ShouldEqual, 1)
+				So(failed.Code, ShouldEqual, http.StatusInternalServerError)
+				So(recovered.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
+
+// ------------------- Routes Through Close() -------------------
+
+// TestUnitCloseCreatedCaches - Verify that Close closes the cache created on
+// registration, but not one supplied by the caller
+func TestUnitCloseCreatedCaches(t *testing.T) {
+
+	Convey("Given I register one chain without a cache and another with one", t, func() {
+
+		caches = nil
+
+		created := &mockState.Connection{}
+		supplied := &mockState.Connection{}
+
+		defer func(original func(string, int, string) *state.Cache) { newCache = original }(newCache)
+		newCache = func(addr string, db int, password string) *state.Cache {
+			return state.NewCacheWithConnection(created)
+		}
+
+		RegisterWithOptions(alice.New(), WithCookieName("TEST"))
+		RegisterWithOptions(alice.New(), WithCookieName("TEST"), WithCache(state.NewCacheWithConnection(supplied)))
+
+		Convey("When I close the middleware's caches", func() {
+
+			created.On("Close").Return(nil)
+
+			err := Close()
+
+			Convey("Then only the created cache should be closed, once", func() {
+
+				So(err, ShouldBeNil)
+				created.AssertNumberOfCalls(t, "Close", 1)
+				supplied.AssertNotCalled(t, "Close")
+
+				So(Close(), ShouldBeNil)
+				created.AssertNumberOfCalls(t, "Close", 1)
+			})
+		})
+	})
+}
+
+// BenchmarkHandler - Measure requests through a registered chain, which should
+// create a single cache regardless of the number of requests
+func BenchmarkHandler(b *testing.B) {
+
+	created := 0
+
+	defer func(original func(string, int, string) *state.Cache) { newCache = original }(newCache)
+	newCache = func(addr string, db int, password string) *state.Cache {
+		created++
+		return state.NewCacheWithConnection(&mockState.Connection{})
+	}
+
+	chain := RegisterWithOptions(alice.New(), WithCookieName("TEST")).
+		Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		chain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	if created != 1 {
+		b.Fatalf("expected 1 cache to be created for %d requests, but %d were", b.N, created)
+	}
+}
+
+// TestUnitRegisterWithOptionsCookiePaths - Verify that session cookies scoped to
+// different paths aren't sent to each other's routes
+func TestUnitRegisterWithOptionsCookiePaths(t *testing.T) {
+
+	Convey("Given I register an admin and a public chain scoped to their own paths", t, func() {
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+		admin := RegisterWithOptions(alice.New(),
+			WithCookieName("ADMIN"),
+			WithCookiePath("/admin"),
+			WithCache(state.NewCacheWithConnection(&mockState.Connection{})),
+		).Then(next)
+
+		public := RegisterWithOptions(alice.New(),
+			WithCookieName("PUBLIC"),
+			WithCookiePath("/public"),
+			WithCache(state.NewCacheWithConnection(&mockState.Connection{})),
+		).Then(next)
+
+		Convey("When a browser receives the session cookie from each chain", func() {
+
+			jar, _ := cookiejar.New(nil)
+			adminURL, _ := url.Parse("http://localhost/admin/users")
+			publicURL, _ := url.Parse("http://localhost/public/search")
+
+			adminResponse := httptest.NewRecorder()
+			admin.ServeHTTP(adminResponse, httptest.NewRequest("GET", adminURL.String(), nil))
+			jar.SetCookies(adminURL, adminResponse.Result().Cookies())
+
+			publicResponse := httptest.NewRecorder()
+			public.ServeHTTP(publicResponse, httptest.NewRequest("GET", publicURL.String(), nil))
+			jar.SetCookies(publicURL, publicResponse.Result().Cookies())
+
+			Convey("Then only the admin cookie should be sent to admin routes", func() {
+
+				cookies := jar.Cookies(adminURL)
+				So(len(cookies), ShouldEqual, 1)
+				So(cookies[0].Name, ShouldEqual, "ADMIN")
+			})
+
+			Convey("And only the public cookie should be sent to public routes", func() {
+
+				cookies := jar.Cookies(publicURL)
+				So(len(cookies), ShouldEqual, 1)
+				So(cookies[0].Name, ShouldEqual, "PUBLIC")
+			})
+		})
+	})
+}
+
+// ---------------- Routes Through IsNewSession() ----------------
+
+// TestUnitIsNewSession - Verify that a session is reported as new on a first visit,
+// and not on a returning one
+func TestUnitIsNewSession(t *testing.T) {
+
+	Convey("Given I register a chain which records whether the session is new", t, func() {
+
+		id := strings.Repeat("a", 28)
+		store := state.NewStore(nil)
+		store.ID = id
+
+		encoded, _ := state.MsgPackSerializer{}.Encode(map[string]interface{}{
+			"expires": uint32(time.Now().Unix() + 3600),
+		})
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+		connection.On("Set", mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.Anything).
+			Return(redis.NewStatusResult("OK", nil))
+
+		var isNew bool
+		chain := RegisterWithOptions(alice.New(),
+			WithCookieName("TEST"),
+			WithCache(state.NewCacheWithConnection(connection)),
+		).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			isNew = IsNewSession(req)
+		}))
+
+		Convey("When a first-visit request is made with no session cookie", func() {
+
+			chain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			Convey("Then the session should be new", func() {
+
+				So(isNew, ShouldBeTrue)
+			})
+		})
+
+		Convey("When a returning request is made with a valid session cookie", func() {
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "TEST", Value: id + store.GenerateSignature()})
+
+			chain.ServeHTTP(httptest.NewRecorder(), req)
+
+			Convey("Then the session should not be new", func() {
+
+				So(isNew, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a returning request is made with an expired session's cookie", func() {
+
+			expiredID := strings.Repeat("b", 28)
+			expired, _ := state.MsgPackSerializer{}.Encode(map[string]interface{}{
+				"expires": uint32(time.Now().Unix() - 60),
+				"page":    "search",
+			})
+			connection.On("Get", expiredID).Return(redis.NewStringResult(expired, nil))
+
+			store.ID = expiredID
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "TEST", Value: expiredID + store.GenerateSignature()})
+
+			chain.ServeHTTP(httptest.NewRecorder(), req)
+
+			Convey("Then the session should be new", func() {
+
+				So(isNew, ShouldBeTrue)
+			})
+		})
+	})
+}
+
+// TestUnitRequestContext - Verify that the handler's request context carries the
+// incoming request's values and cancellation as well as the session
+func TestUnitRequestContext(t *testing.T) {
+
+	Convey("Given I register a chain which records its request's context", t, func() {
+
+		connection := &mockState.Connection{}
+		connection.On("Set", mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.Anything).
+			Return(redis.NewStatusResult("OK", nil))
+
+		var ctx context.Context
+		chain := RegisterWithOptions(alice.New(),
+			WithCookieName("TEST"),
+			WithCache(state.NewCacheWithConnection(connection)),
+		).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx = req.Context()
+		}))
+
+		Convey("When a request is made with a value on a cancelled context", func() {
+
+			parent, cancel := context.WithCancel(context.WithValue(context.Background(), ContextKey("trace"), "span"))
+			cancel()
+
+			req := httptest.NewRequest("GET", "/", nil).WithContext(parent)
+			chain.ServeHTTP(httptest.NewRecorder(), req)
+
+			Convey("Then the handler's context should carry them alongside the session", func() {
+
+				So(ctx.Value(ContextKey("trace")), ShouldEqual, "span")
+				So(ctx.Err(), ShouldEqual, context.Canceled)
+				So(ctx.Value(ContextKeySession), ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+// TestUnitCreateAnonymousSessions - Verify that a first-time visitor's empty
+// session is only stored when anonymous sessions are enabled
+func TestUnitCreateAnonymousSessions(t *testing.T) {
+
+	Convey("Given a first-time visitor to a handler which leaves the session empty", t, func() {
+
+		connection := &mockState.Connection{}
+		connection.On("Set", mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.Anything).
+			Return(redis.NewStatusResult("OK", nil))
+
+		register := func() http.Handler {
+			return RegisterWithOptions(alice.New(),
+				WithCookieName("TEST"),
+				WithCache(state.NewCacheWithConnection(connection)),
+			).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+		}
+
+		Convey("When anonymous sessions are enabled", func() {
+
+			cfg := config.Get()
+			expiration := cfg.DefaultExpiration
+			cfg.CreateAnonymousSessions, cfg.DefaultExpiration = true, "60"
+			defer func() { cfg.CreateAnonymousSessions, cfg.DefaultExpiration = false, expiration }()
+
+			w := httptest.NewRecorder()
+			register().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			Convey("Then a session should be stored under a fresh ID, and its cookie set", func() {
+
+				connection.AssertNumberOfCalls(t, "Set", 1)
+
+				cookies := w.Result().Cookies()
+				So(cookies, ShouldHaveLength, 1)
+
+				id := connection.Calls[0].Arguments.String(0)
+				So(id, ShouldNotBeBlank)
+				So(cookies[0].Value, ShouldStartWith, id)
+			})
+		})
+
+		Convey("When anonymous sessions are disabled", func() {
+
+			register().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			Convey("Then the empty session should not be stored", func() {
+
+				connection.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything)
+			})
+		})
+	})
+}
+
+// TestUnitRegenerateOnSignIn - Verify that, when enabled, the session ID is
+// regenerated on the request which signs the user in, and only on that request
+func TestUnitRegenerateOnSignIn(t *testing.T) {
+
+	Convey("Given a stored session which isn't signed in", t, func() {
+
+		cfg := config.Get()
+		expiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "60"
+		defer func() { cfg.DefaultExpiration = expiration }()
+
+		cache := state.NewMemoryCache()
+		stored := state.NewStore(cache)
+		stored.Data = map[string]interface{}{"page": "home"}
+		So(stored.Store(), ShouldBeNil)
+		oldCookie := stored.CookieValue()
+
+		signIn := func(data session.Session) {
+			data["signin_info"] = map[string]interface{}{"signed_in": int8(1)}
+		}
+
+		serve := func(regenerate bool, cookie string, handle func(req *http.Request)) *httptest.ResponseRecorder {
+			chain := RegisterWithOptions(alice.New(),
+				WithCookieName("TEST"),
+				WithCache(cache),
+				func(o *Options) { o.RegenerateOnSignIn = regenerate },
+			).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				handle(req)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "TEST", Value: cookie})
+			w := httptest.NewRecorder()
+			chain.ServeHTTP(w, req)
+			return w
+		}
+
+		Convey("When a request signs the user in, with regeneration enabled", func() {
+
+			w := serve(true, oldCookie, func(req *http.Request) { signIn(*GetSessionFromRequest(req)) })
+
+			Convey("Then the cookie should carry a new ID, with the data moved to it", func() {
+
+				cookies := w.Result().Cookies()
+				So(cookies, ShouldHaveLength, 1)
+				So(cookies[0].Value, ShouldNotEqual, oldCookie)
+
+				regenerated := state.NewStore(cache)
+				So(regenerated.Load(cookies[0].Value), ShouldBeNil)
+				So(regenerated.Data["page"], ShouldEqual, "home")
+				So(regenerated.Data.IsSignedIn(), ShouldBeTrue)
+
+				old := state.NewStore(cache)
+				So(old.Load(oldCookie), ShouldBeNil)
+				So(old.Data, ShouldBeEmpty)
+			})
+
+			Convey("And a further signed in request should keep the new ID", func() {
+
+				newCookie := w.Result().Cookies()[0].Value
+				w := serve(true, newCookie, func(req *http.Request) {
+					(*GetSessionFromRequest(req))["page"] = "account"
+				})
+
+				cookies := w.Result().Cookies()
+				So(cookies, ShouldHaveLength, 1)
+				So(cookies[0].Value, ShouldEqual, newCookie)
+			})
+
+			Convey("And signing out should expire the cookie rather than regenerate it", func() {
+
+				newCookie := w.Result().Cookies()[0].Value
+				w := serve(true, newCookie, func(req *http.Request) { So(ClearSession(req), ShouldBeNil) })
+
+				cookies := w.Result().Cookies()
+				So(cookies, ShouldHaveLength, 1)
+				So(cookies[0].Value, ShouldBeBlank)
+				So(cookies[0].MaxAge, ShouldBeLessThan, 0)
+
+				old := state.NewStore(cache)
+				So(old.Load(newCookie), ShouldBeNil)
+				So(old.Data, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a request signs the user in, with regeneration disabled", func() {
+
+			w := serve(false, oldCookie, func(req *http.Request) { signIn(*GetSessionFromRequest(req)) })
+
+			Convey("Then the cookie should keep the old ID", func() {
+
+				cookies := w.Result().Cookies()
+				So(cookies, ShouldHaveLength, 1)
+				So(cookies[0].Value, ShouldEqual, oldCookie)
+			})
+		})
+	})
+}
+
+// ---------------- Routes Through WithSessionHooks() ----------------
+
+// TestUnitSessionHooks - Verify that the session hooks are called exactly once
+// as each request's session is created, loaded and destroyed
+func TestUnitSessionHooks(t *testing.T) {
+
+	Convey("Given I register a chain with session hooks", t, func() {
+
+		cfg := config.Get()
+		expiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "60"
+		defer func() { cfg.DefaultExpiration = expiration }()
+
+		var loaded, created, destroyed int
+		cache := state.NewMemoryCache()
+
+		serve := func(cookie string, handle func(req *http.Request)) *httptest.ResponseRecorder {
+			chain := RegisterWithOptions(alice.New(),
+				WithCookieName("TEST"),
+				WithCache(cache),
+				func(o *Options) { o.CreateAnonymousSessions = true },
+				WithSessionHooks(state.SessionHooks{
+					OnLoad:    func(session.Session) { loaded++ },
+					OnCreate:  func(session.Session) { created++ },
+					OnDestroy: func(session.Session) { destroyed++ },
+				}),
+			).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				handle(req)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "TEST", Value: cookie})
+			}
+			w := httptest.NewRecorder()
+			chain.ServeHTTP(w, req)
+			return w
+		}
+
+		Convey("When a request without a session starts one, adding data to it", func() {
+
+			w := serve("", func(req *http.Request) { (*GetSessionFromRequest(req))["page"] = "home" })
+			cookie := w.Result().Cookies()[0].Value
+
+			Convey("Then OnCreate should have been called once", func() {
+
+				So(created, ShouldEqual, 1)
+				So(loaded, ShouldEqual, 0)
+				So(destroyed, ShouldEqual, 0)
+			})
+
+			Convey("And a further request with its cookie should call OnLoad once", func() {
+
+				serve(cookie, func(req *http.Request) { (*GetSessionFromRequest(req))["page"] = "account" })
+
+				So(loaded, ShouldEqual, 1)
+				So(created, ShouldEqual, 1)
+				So(destroyed, ShouldEqual, 0)
+			})
+
+			Convey("And a further request clearing it should call OnDestroy once", func() {
+
+				serve(cookie, func(req *http.Request) { So(ClearSession(req), ShouldBeNil) })
+
+				So(loaded, ShouldEqual, 1)
+				So(destroyed, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+// ---------------- Routes Through setSessionIDOnResponse() ----------------
+
+// TestUnitPartitionedCookie - Verify that the session cookie carries the Partitioned
+// attribute only when enabled alongside SameSite=None, which is always Secure
+func TestUnitPartitionedCookie(t *testing.T) {
+
+	Convey("Given I register chains with different cookie attributes", t, func() {
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+		connection := &mockState.Connection{}
+		connection.On("Set", mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.Anything).
+			Return(redis.NewStatusResult("OK", nil))
+
+		register := func(secure bool, sameSite string, partitioned bool) http.Handler {
+			return RegisterWithOptions(alice.New(),
+				WithCookieName("TEST"),
+				WithCache(state.NewCacheWithConnection(connection)),
+				func(o *Options) {
+					o.CookieSecure = secure
+					o.CookieSameSite = sameSite
+					o.CookiePartitioned = partitioned
+				},
+			).Then(next)
+		}
+
+		setCookie := func(h http.Handler) string {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			return w.Header().Get("Set-Cookie")
+		}
+
+		Convey("When partitioning is enabled with SameSite=None and Secure", func() {
+
+			header := setCookie(register(true, "None", true))
+
+			Convey("Then the Set-Cookie header should carry the Partitioned attribute", func() {
+
+				So(header, ShouldStartWith, "TEST=")
+				So(header, ShouldContainSubstring, "; Secure")
+				So(header, ShouldContainSubstring, "; SameSite=None")
+				So(header, ShouldEndWith, "; Partitioned")
+			})
+		})
+
+		Convey("When partitioning is enabled with SameSite=None, but not Secure", func() {
+
+			header := setCookie(register(false, "None", true))
+
+			Convey("Then the Set-Cookie header should be Secure anyway, and carry the Partitioned attribute", func() {
+
+				So(header, ShouldContainSubstring, "; Secure")
+				So(header, ShouldContainSubstring, "; SameSite=None")
+				So(header, ShouldEndWith, "; Partitioned")
+			})
+		})
+
+		Convey("When SameSite=None is chosen without partitioning", func() {
+
+			header := setCookie(register(false, "None", false))
+
+			Convey("Then the Set-Cookie header should be Secure, without the Partitioned attribute", func() {
+
+				So(header, ShouldContainSubstring, "; Secure")
+				So(header, ShouldEndWith, "; SameSite=None")
+			})
+		})
+
+		Convey("When partitioning is enabled without SameSite=None", func() {
+
+			header := setCookie(register(true, "Lax", true))
+
+			Convey("Then the Set-Cookie header should not carry the Partitioned attribute", func() {
+
+				So(header, ShouldContainSubstring, "; SameSite=Lax")
+				So(header, ShouldNotContainSubstring, "Partitioned")
+			})
+		})
+
+		Convey("When partitioning isn't enabled", func() {
+
+			header := setCookie(register(false, "", false))
+
+			Convey("Then the Set-Cookie header should not carry the Partitioned attribute", func() {
+
+				So(header, ShouldNotContainSubstring, "Partitioned")
+				So(header, ShouldNotContainSubstring, "SameSite")
+			})
+		})
+	})
+}
+
+// TestUnitValidateCookieValue - Verify that cookie values are only accepted if
+// every octet is valid in a cookie value
+func TestUnitValidateCookieValue(t *testing.T) {
+
+	Convey("Given session cookie values in either base64 alphabet", t, func() {
+
+		values := []string{"ab+/cd==", "ab-_cd", "~AbC123-_"}
+
+		Convey("Then they should be accepted", func() {
+
+			for _, value := range values {
+				So(validateCookieValue(value), ShouldBeNil)
+			}
+		})
+	})
+
+	Convey("Given cookie values with an invalid octet", t, func() {
+
+		values := []string{"ab cd", "ab;cd", "ab,cd", "ab\"cd", "ab\\cd", "ab\x7fcd", "ab\ncd", "abé"}
+
+		Convey("Then each should be rejected with ErrInvalidCookieValue", func() {
+
+			for _, value := range values {
+				So(validateCookieValue(value), ShouldEqual, ErrInvalidCookieValue)
+			}
+		})
+	})
+}
+
+// ---------------- Routes Through ClearSession() ----------------
+
+// TestUnitClearSession - Verify that a session cleared by the handler isn't stored
+// again by the middleware, and its cookie is expired instead
+func TestUnitClearSession(t *testing.T) {
+
+	Convey("Given I register a chain whose handler clears the session", t, func() {
+
+		id := strings.Repeat("a", 28)
+		store := state.NewStore(nil)
+		store.ID = id
+
+		encoded, _ := state.MsgPackSerializer{}.Encode(map[string]interface{}{
+			"expires": uint32(time.Now().Unix() + 3600),
+		})
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+		connection.On("Del", id).Return(redis.NewIntResult(1, nil))
+
+		var clearErr error
+		chain := RegisterWithOptions(alice.New(),
+			WithCookieName("TEST"),
+			WithCache(state.NewCacheWithConnection(connection)),
+		).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			clearErr = ClearSession(req)
+		}))
+
+		Convey("When a request is made with a valid session cookie", func() {
+
+			req := httptest.NewRequest("GET", "/logout", nil)
+			req.AddCookie(&http.Cookie{Name: "TEST", Value: id + store.GenerateSignature()})
+
+			w := httptest.NewRecorder()
+			chain.ServeHTTP(w, req)
+
+			Convey("Then the session should be deleted and not stored again", func() {
+
+				So(clearErr, ShouldBeNil)
+				connection.AssertCalled(t, "Del", id)
+				connection.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything)
+			})
+
+			Convey("And the session cookie should be expired", func() {
+
+				cookies := w.Result().Cookies()
+				So(len(cookies), ShouldEqual, 1)
+				So(cookies[0].Name, ShouldEqual, "TEST")
+				So(cookies[0].Value, ShouldBeBlank)
+				So(cookies[0].MaxAge, ShouldBeLessThan, 0)
+			})
+		})
+	})
+
+	Convey("Given a request which hasn't been through the session middleware", t, func() {
+
+		req := httptest.NewRequest("GET", "/logout", nil)
+
+		Convey("When I clear its session", func() {
+
+			err := ClearSession(req)
+
+			Convey("Then an error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+// ---------------- Routes Through GetSessionDataFromRequest() ----------------
+
+// appSession is an application's own session data type, layering a strongly
+// typed method onto the session data
+type appSession struct {
+	session.Session
+}
+
+func (a *appSession) Role() string {
+	role, _ := a.Session["role"].(string)
+	return role
+}
+
+// TestUnitGetSessionDataFromRequest - Verify that the session data is built as
+// the application's own type when the SessionData option is set
+func TestUnitGetSessionDataFromRequest(t *testing.T) {
+
+	Convey("Given a session is stored with a role", t, func() {
+
+		cache := state.NewMemoryCache()
+		stored := state.NewStore(cache)
+		stored.Data = map[string]interface{}{
+			"role":    "admin",
+			"expires": uint32(time.Now().Unix() + 3600),
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in": uint16(3600),
+				},
+			},
+		}
+		So(stored.Store(), ShouldBeNil)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "TEST", Value: stored.CookieValue()})
+
+		Convey("When a request is made through a chain building an appSession", func() {
+
+			var data session.SessionData
+			chain := RegisterWithOptions(alice.New(),
+				WithCookieName("TEST"),
+				WithCache(cache),
+				WithSessionData(func(s *session.Session) session.SessionData {
+					return &appSession{Session: *s}
+				}),
+			).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				data = GetSessionDataFromRequest(req)
+			}))
+
+			chain.ServeHTTP(httptest.NewRecorder(), req)
+
+			Convey("Then the session data should be the appSession", func() {
+
+				app, ok := data.(*appSession)
+				So(ok, ShouldBeTrue)
+				So(app.Role(), ShouldEqual, "admin")
+				So(app.IsSignedIn(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When a request is made through a chain without the option", func() {
+
+			var data session.SessionData
+			chain := RegisterWithOptions(alice.New(),
+				WithCookieName("TEST"),
+				WithCache(cache),
+			).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				data = GetSessionDataFromRequest(req)
+			}))
+
+			chain.ServeHTTP(httptest.NewRecorder(), req)
+
+			Convey("Then the session data should be the session itself", func() {
+
+				s, ok := data.(*session.Session)
+				So(ok, ShouldBeTrue)
+				So((*s)["role"], ShouldEqual, "admin")
+			})
+		})
+	})
+}
+
+// ---------------- Routes Through SkipStore() ----------------
+
+// TestUnitSkipStore - Verify that the session isn't stored, nor the cookie
+// written, when the handler opts out
+func TestUnitSkipStore(t *testing.T) {
+
+	Convey("Given I register a chain whose handler opts out of storing the session", t, func() {
+
+		connection := &mockState.Connection{}
+
+		chain := RegisterWithOptions(alice.New(),
+			WithCookieName("TEST"),
+			WithCache(state.NewCacheWithConnection(connection)),
+		).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			SkipStore(req)
+		}))
+
+		Convey("When a request is made", func() {
+
+			w := httptest.NewRecorder()
+			chain.ServeHTTP(w, httptest.NewRequest("GET", "/download", nil))
+
+			Convey("Then the session should not be stored, nor the cookie written", func() {
+
+				connection.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything)
+				So(w.Result().Cookies(), ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a request which hasn't been through the session middleware", t, func() {
+
+		req := httptest.NewRequest("GET", "/download", nil)
+
+		Convey("Then opting out of storing the session should do nothing", func() {
+
+			So(func() { SkipStore(req) }, ShouldNotPanic)
+		})
+	})
+}
+
+// infoLogger is a state.Logger which records the data of each info message
+type infoLogger struct {
+	infos []map[string]interface{}
+}
+
+func (l *infoLogger) Info(msg string, data map[string]interface{}) {
+	l.infos = append(l.infos, data)
+}
+
+func (l *infoLogger) Trace(msg string, data map[string]interface{}) {}
+
+func (l *infoLogger) Warn(msg string, data map[string]interface{}) {}
+
+func (l *infoLogger) Error(err error, data map[string]interface{}) {}
+
+// TestUnitLogChangedKeys - Verify that the keys changed by a request are logged
+// without their values
+func TestUnitLogChangedKeys(t *testing.T) {
+
+	Convey("Given I register a chain with a logger", t, func() {
+
+		cfg := config.Get()
+		expiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "60"
+		defer func() { cfg.DefaultExpiration = expiration }()
+
+		logger := &infoLogger{}
+		chain := RegisterWithOptions(alice.New(),
+			WithCookieName("TEST"),
+			WithCache(state.NewMemoryCache()),
+			WithLogger(logger),
+		).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			*GetSessionFromRequest(req) = session.Session{"role": "secret-role"}
+		}))
+
+		Convey("When a request changes the session", func() {
+
+			chain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			Convey("Then the changed key, but not its value, should be logged", func() {
+
+				So(logger.infos, ShouldHaveLength, 1)
+				So(logger.infos[0]["changed_keys"], ShouldResemble, []string{"role"})
+				So(fmt.Sprint(logger.infos[0]), ShouldNotContainSubstring, "secret-role")
+			})
+		})
+	})
+}
+
+// TestUnitConfigUnavailable - Verify that requests fail with a 500, rather than
+// a panic, when the config can't be loaded
+func TestUnitConfigUnavailable(t *testing.T) {
+
+	Convey("Given the config can't be loaded", t, func() {
+
+		defer func(original func() *config.Config) { getConfig = original }(getConfig)
+		getConfig = func() *config.Config { return nil }
+
+		Convey("When a request is made through a chain registered from config", func() {
+
+			var handledErr error
+			called := false
+			chain := RegisterWithOptions(alice.New(),
+				WithErrorHandler(func(w http.ResponseWriter, req *http.Request, err error) {
+					handledErr = err
+					w.WriteHeader(http.StatusInternalServerError)
+				}),
+			).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				called = true
+			}))
+
+			w := httptest.NewRecorder()
+			So(func() { chain.ServeHTTP(w, httptest.NewRequest("GET", "/", nil)) }, ShouldNotPanic)
+
+			Convey("Then the error handler should respond with a 500 without calling the handler", func() {
+
+				So(handledErr, ShouldEqual, ErrConfigUnavailable)
+				So(w.Code, ShouldEqual, http.StatusInternalServerError)
+				So(called, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a request is made through a chain with its own cookie name and cache", func() {
+
+			chain := RegisterWithOptions(alice.New(),
+				WithCookieName("TEST"),
+				WithCache(state.NewMemoryCache()),
+			).Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+			w := httptest.NewRecorder()
+			So(func() { chain.ServeHTTP(w, httptest.NewRequest("GET", "/", nil)) }, ShouldNotPanic)
+
+			Convey("Then the default error handler should respond with a 500", func() {
+
+				So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+	})
+}
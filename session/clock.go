@@ -0,0 +1,17 @@
+package session
+
+import "time"
+
+// Clock tells the time expirations are computed from, so that tests can freeze
+// or advance it rather than sleeping
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock which reads the real time. It is the default
+type SystemClock struct{}
+
+// Now returns the current time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
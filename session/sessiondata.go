@@ -1,7 +1,9 @@
 package session
 
 import (
-	"strconv"
+	"errors"
+	"sort"
+	"strings"
 	"time"
 
 	goauth2 "golang.org/x/oauth2"
@@ -11,6 +13,21 @@ import (
 // Session is a map respresentation of the session data
 type Session map[string]interface{}
 
+// SessionData is the set of accessors common to session data. *Session
+// implements it, and an application can layer its own strongly typed methods
+// onto the session data by embedding Session in a type of its own, which will
+// still satisfy SessionData
+type SessionData interface {
+	GetAccessToken() string
+	GetUserID() string
+	IsSignedIn() bool
+	GetExpiration() uint64
+	RefreshExpiration() error
+	GetOauth2Token() *goauth2.Token
+}
+
+var _ SessionData = (*Session)(nil)
+
 // GetAccessToken retrieves the access token from the session data
 func (data *Session) GetAccessToken() string {
 	signinInfo := (*data)["signin_info"].(map[string]interface{})
@@ -18,6 +35,25 @@ func (data *Session) GetAccessToken() string {
 	return (accessTokenMap)["access_token"].(string)
 }
 
+// GetUserID retrieves the ID of the signed in user from the session data.
+// Returns an empty string if there is no user profile on the session
+func (data *Session) GetUserID() string {
+	signinInfo, _ := (*data)["signin_info"].(map[string]interface{})
+	userProfile, _ := signinInfo["user_profile"].(map[string]interface{})
+	userID, _ := userProfile["id"].(string)
+	return userID
+}
+
+// TokenFamilyID retrieves the ID of the refresh token family the session's
+// access token was issued from, used to revoke every token derived from it.
+// Returns an empty string if there is none on the session
+func (data *Session) TokenFamilyID() string {
+	signinInfo, _ := (*data)["signin_info"].(map[string]interface{})
+	accessTokenMap, _ := signinInfo["access_token"].(map[string]interface{})
+	familyID, _ := accessTokenMap["family_id"].(string)
+	return familyID
+}
+
 // getRefreshToken retrieves the refresh token from the session data
 func (data *Session) getRefreshToken() string {
 	signinInfo := (*data)["signin_info"].(map[string]interface{})
@@ -28,13 +64,13 @@ func (data *Session) getRefreshToken() string {
 // getExpiry retrieves the 'expires' value from the session data and converts it
 // to a time
 func (data *Session) getExpiry() time.Time {
-	expiry := (*data)["expires"].(uint32)
-	return time.Unix(int64(expiry), 0)
+	expiry, _ := ToInt64((*data)["expires"])
+	return time.Unix(expiry, 0)
 }
 
-// isSignedIn checks whether a user is signed in given the session data. Returns
+// IsSignedIn checks whether a user is signed in given the session data. Returns
 // a boolean
-func (data *Session) isSignedIn() bool {
+func (data *Session) IsSignedIn() bool {
 	signinInfo, ok := (*data)["signin_info"].(map[string]interface{})
 	if !ok {
 		return false
@@ -51,17 +87,25 @@ func (data *Session) SetAccessToken(accessToken string) {
 	accessTokenMap["access_token"] = accessToken
 }
 
-// SetRefreshToken sets the refresh token on the session data map
+// SetRefreshToken sets the refresh token on the session data map, recording
+// when it was issued in 'refresh_issued_at' for RefreshTokenExpiry
 func (data *Session) SetRefreshToken(refreshToken string) {
+	data.SetRefreshTokenWithClock(refreshToken, SystemClock{})
+}
+
+// SetRefreshTokenWithClock sets the refresh token in the same way as
+// SetRefreshToken, taking the time it was issued from the given Clock
+func (data *Session) SetRefreshTokenWithClock(refreshToken string, clock Clock) {
 	signinInfo := (*data)["signin_info"].(map[string]interface{})
 	accessTokenMap := signinInfo["access_token"].(map[string]interface{})
 	accessTokenMap["refresh_token"] = refreshToken
+	accessTokenMap["refresh_issued_at"] = clock.Now().Unix()
 }
 
 // GetExpiration returns the expiration period from the session data
 func (data *Session) GetExpiration() uint64 {
-	signinInfo := (*data)["signin_info"].(map[string]interface{})
-	accessTokenMap := (signinInfo)["access_token"].(map[string]interface{})
+	signinInfo, _ := (*data)["signin_info"].(map[string]interface{})
+	accessTokenMap, _ := (signinInfo)["access_token"].(map[string]interface{})
 	expiration, ok := (accessTokenMap)["expires_in"].(uint16)
 	if !ok {
 		return uint64(0)
@@ -69,26 +113,61 @@ func (data *Session) GetExpiration() uint64 {
 	return uint64(expiration)
 }
 
+// ExpClaim returns the time at which the session expires, in seconds since the
+// epoch, from a JWT-style 'exp' claim on the session data, for sessions seeded
+// from token-derived data. A top-level 'exp' is preferred to one on the access
+// token. As JSON decodes numbers as float64, float and integer claims are both
+// accepted. Returns false if there is no positive claim
+func (data *Session) ExpClaim() (uint64, bool) {
+	exp, ok := toSeconds((*data)["exp"])
+	if !ok {
+		signinInfo, _ := (*data)["signin_info"].(map[string]interface{})
+		accessTokenMap, _ := signinInfo["access_token"].(map[string]interface{})
+		exp, ok = toSeconds(accessTokenMap["exp"])
+	}
+	if !ok || exp <= 0 {
+		return 0, false
+	}
+	return uint64(exp), true
+}
+
+// ExpirationOverride returns the number of seconds the session lives for, if it
+// has been overridden for this session, from the 'expiration_period' value on
+// the session data. Returns false if it hasn't been overridden
+func (data *Session) ExpirationOverride() (uint64, bool) {
+	period, ok := ToInt64((*data)["expiration_period"])
+	if !ok || period <= 0 {
+		return 0, false
+	}
+	return uint64(period), true
+}
+
 // RefreshExpiration updates the 'expires' value on the session to the current
 // time plus the expiration period
 func (data *Session) RefreshExpiration() error {
+	return data.RefreshExpirationWithClock(SystemClock{})
+}
+
+// RefreshExpirationWithClock updates the 'expires' value on the session in the
+// same way as RefreshExpiration, taking the current time from the given Clock
+func (data *Session) RefreshExpirationWithClock(clock Clock) error {
 	var err error
     expiration := data.GetExpiration()
 	if expiration == uint64(0) {
-		expiration, err = strconv.ParseUint(config.Get().DefaultExpiration, 0, 64)
+		expiration, err = config.Get().DefaultExpirationSeconds()
 		if err != nil {
 			return err
 		}
 	}
 
-	(*data)["expires"] = uint32(uint64(time.Now().Unix()) + expiration)
+	(*data)["expires"] = clock.Now().Unix() + int64(expiration)
 	return nil
 }
 
 // GetOauth2Token returns an oauth2 token derived from the session data. Returns
 // nil if the user is not yet signed in
 func (data *Session) GetOauth2Token() *goauth2.Token {
-	if data.isSignedIn() {
+	if data.IsSignedIn() {
 		tok := &goauth2.Token{AccessToken: data.GetAccessToken(),
 			RefreshToken: data.getRefreshToken(),
 			Expiry:       data.getExpiry(),
@@ -99,3 +178,315 @@ func (data *Session) GetOauth2Token() *goauth2.Token {
 
 	return nil
 }
+
+// FeatureFlags returns the feature flag overrides stored on the session data.
+// An empty map is returned if no overrides have been set
+func (data *Session) FeatureFlags() map[string]bool {
+	flags := map[string]bool{}
+
+	featureFlags, ok := (*data)["feature_flags"].(map[string]interface{})
+	if !ok {
+		return flags
+	}
+
+	for name, value := range featureFlags {
+		if enabled, ok := value.(bool); ok {
+			flags[name] = enabled
+		}
+	}
+
+	return flags
+}
+
+// SetFeatureFlag sets a feature flag override on the session data map, creating
+// the 'feature_flags' map if it doesn't already exist
+func (data *Session) SetFeatureFlag(name string, enabled bool) {
+	featureFlags, ok := (*data)["feature_flags"].(map[string]interface{})
+	if !ok {
+		featureFlags = map[string]interface{}{}
+		(*data)["feature_flags"] = featureFlags
+	}
+	featureFlags[name] = enabled
+}
+
+// protectedKeys are the internal keys on the session data which Clear keeps,
+// as they belong to the session itself rather than to its contents
+var protectedKeys = map[string]bool{
+	"csrf_token":        true,
+	"expires":           true,
+	"expiration_period": true,
+	"created_at":        true,
+	"last_access":       true,
+	"renewal_count":     true,
+	"schema_version":    true,
+	"_version":          true,
+}
+
+// IsProtectedKey reports whether the key is one of the internal keys on the
+// session data, such as its expiry, which Clear keeps
+func IsProtectedKey(key string) bool {
+	return protectedKeys[key]
+}
+
+// Delete removes the value stored under the key from the session data. It is
+// a no-op if the key isn't present
+func (data *Session) Delete(key string) {
+	delete(*data, key)
+}
+
+// Clear empties the session data in place, except for protected internal keys
+// such as 'csrf_token' and the session's expiry, so that the session remains
+// valid. Use ClearAll to remove those too
+func (data *Session) Clear() {
+	for key := range *data {
+		if !protectedKeys[key] {
+			delete(*data, key)
+		}
+	}
+}
+
+// ClearAll empties the session data in place, including protected internal
+// keys
+func (data *Session) ClearAll() {
+	for key := range *data {
+		delete(*data, key)
+	}
+}
+
+// bookkeepingKeys are the keys the session handler keeps on the session data to
+// track the session's lifetime and storage, which ApplicationKeys leaves out
+var bookkeepingKeys = map[string]bool{
+	"expires":           true,
+	"expiration_period": true,
+	"created_at":        true,
+	"last_access":       true,
+	"renewal_count":     true,
+	"schema_version":    true,
+	"_version":          true,
+}
+
+// Keys returns a sorted copy of the top-level keys of the session data, without
+// their values, so that it is safe to log
+func (data *Session) Keys() []string {
+	return data.filteredKeys(func(string) bool { return true })
+}
+
+// ApplicationKeys returns the keys of the session data in the same way as Keys,
+// leaving out the session handler's bookkeeping keys, such as 'expires',
+// 'last_access' and '_version'. Other internal keys, such as 'csrf_token', are
+// kept
+func (data *Session) ApplicationKeys() []string {
+	return data.filteredKeys(func(key string) bool { return !bookkeepingKeys[key] })
+}
+
+// filteredKeys returns a sorted copy of the top-level keys of the session data
+// for which include returns true
+func (data *Session) filteredKeys(include func(key string) bool) []string {
+	keys := make([]string, 0, len(*data))
+	for key := range *data {
+		if include(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LastAccess returns the time the session was last accessed, from the
+// 'last_access' value on the session data. Returns false if it isn't known
+func (data *Session) LastAccess() (time.Time, bool) {
+	lastAccess, ok := ToInt64((*data)["last_access"])
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(lastAccess, 0), true
+}
+
+// CreatedAt returns the time the session was created, from the 'created_at'
+// value on the session data. Returns false if it isn't known
+func (data *Session) CreatedAt() (time.Time, bool) {
+	createdAt, ok := ToInt64((*data)["created_at"])
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(createdAt, 0), true
+}
+
+// RenewalCount returns the number of times the session's expiry has been slid,
+// from the 'renewal_count' value on the session data. Returns 0 if it is unset
+func (data *Session) RenewalCount() int64 {
+	renewals, _ := ToInt64((*data)["renewal_count"])
+	return renewals
+}
+
+// RefreshTokenExpiry returns the time at which the refresh token expires, after
+// which the user must sign in again. The 'refresh_expires_in' value of the
+// access token in the session data counts seconds from when the refresh token
+// was issued, recorded in its 'refresh_issued_at' value when it is set. The
+// session's own expiry is no guide, since it moves whenever the session is
+// renewed. Returns false if either value isn't known
+func (data *Session) RefreshTokenExpiry() (time.Time, bool) {
+	signinInfo, _ := (*data)["signin_info"].(map[string]interface{})
+	accessTokenMap, _ := signinInfo["access_token"].(map[string]interface{})
+
+	refreshExpiresIn, ok := ToInt64(accessTokenMap["refresh_expires_in"])
+	if !ok {
+		return time.Time{}, false
+	}
+	issued, ok := ToInt64(accessTokenMap["refresh_issued_at"])
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(issued+refreshExpiresIn, 0), true
+}
+
+// SchemaVersion returns the version of the session data's schema, from the
+// 'schema_version' value on the session data. Returns 0 if it is unset
+func (data *Session) SchemaVersion() int64 {
+	version, _ := ToInt64((*data)["schema_version"])
+	return version
+}
+
+// Version returns the number of times the session has been stored with
+// optimistic locking enabled, from the '_version' value on the session data.
+// Returns 0 if it is unset
+func (data *Session) Version() int64 {
+	version, _ := ToInt64((*data)["_version"])
+	return version
+}
+
+// AbsoluteDeadline returns the time by which the user must re-authenticate
+// regardless of activity, being the 'created_at' value on the session data plus
+// the given maximum lifetime. Returns false if the creation time isn't known
+func (data *Session) AbsoluteDeadline(maxLifetime time.Duration) (time.Time, bool) {
+	createdAt, ok := data.CreatedAt()
+	if !ok {
+		return time.Time{}, false
+	}
+	return createdAt.Add(maxLifetime), true
+}
+
+// Project returns a new session containing only the given keys, e.g. to forward
+// to a downstream service without leaking tokens. Keys may be dotted paths into
+// nested maps, such as "signin_info.user_profile.id", in which case only that
+// branch of the nested maps is included. Keys not present are skipped
+func (data *Session) Project(keys ...string) Session {
+	projected := Session{}
+
+	for _, key := range keys {
+		path := strings.Split(key, ".")
+
+		var source interface{} = map[string]interface{}(*data)
+		for _, name := range path {
+			nested, ok := source.(map[string]interface{})
+			if !ok {
+				source = nil
+				break
+			}
+			if source, ok = nested[name]; !ok {
+				break
+			}
+		}
+		if source == nil {
+			continue
+		}
+
+		target := map[string]interface{}(projected)
+		for _, name := range path[:len(path)-1] {
+			nested, ok := target[name].(map[string]interface{})
+			if !ok {
+				nested = map[string]interface{}{}
+				target[name] = nested
+			}
+			target = nested
+		}
+		target[path[len(path)-1]] = source
+	}
+
+	return projected
+}
+
+// Scopes returns the scopes granted to the session's access token, from the
+// space separated 'scope' value of the access token in the session data, as
+// issued by the OAuth2 server. A list of scopes is also accepted. Returns nil if
+// no scopes have been granted
+func (data *Session) Scopes() []string {
+	signinInfo, _ := (*data)["signin_info"].(map[string]interface{})
+	accessTokenMap, _ := signinInfo["access_token"].(map[string]interface{})
+
+	switch scope := accessTokenMap["scope"].(type) {
+	case string:
+		return strings.Fields(scope)
+	case []interface{}:
+		var scopes []string
+		for _, value := range scope {
+			if name, ok := value.(string); ok {
+				scopes = append(scopes, name)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
+
+// RequireScopes checks that the session's access token has been granted every
+// one of the required scopes, returning an error listing any which are missing
+func (data *Session) RequireScopes(required ...string) error {
+	granted := map[string]bool{}
+	for _, scope := range data.Scopes() {
+		granted[scope] = true
+	}
+
+	var missing []string
+	for _, scope := range required {
+		if !granted[scope] {
+			missing = append(missing, scope)
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.New("Session is missing required scopes: " + strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// ToInt64 converts any of the integer types msgpack may decode a number to into
+// an int64. Returns false if the value isn't an integer
+func ToInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// toSeconds converts a number of seconds of any integer or float type to an
+// int64, truncating any fraction. Returns false if the value isn't a number
+func toSeconds(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return int64(v), true
+	case float32:
+		return int64(v), true
+	}
+	return ToInt64(value)
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/companieshouse/go-session-handler/config"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -207,9 +208,9 @@ func TestUnitIsSignedInEmptySessionDataMap(t *testing.T) {
 
 		var sessionData Session = map[string]interface{}{}
 
-		Convey("When I call isSignedIn", func() {
+		Convey("When I call IsSignedIn", func() {
 
-			signedIn := sessionData.isSignedIn()
+			signedIn := sessionData.IsSignedIn()
 
 			Convey("Then I should return false", func() {
 
@@ -296,3 +297,693 @@ func TestUnitRefreshExpiration(t *testing.T) {
 
 	cleanupConfig()
 }
+
+// TestUnitRefreshExpirationDefault verifies that the default expiration is used
+// when the session data has none, and that an unparsable one is reported
+func TestUnitRefreshExpirationDefault(t *testing.T) {
+
+	Convey("Given I have session data without an expiration period", t, func() {
+
+		cfg := config.Get()
+		defaultExpiration := cfg.DefaultExpiration
+		defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("When I call RefreshExpiration with a valid default expiration", func() {
+
+			cfg.DefaultExpiration = "600"
+			before := time.Now().Unix()
+			err := sessionData.RefreshExpiration()
+
+			Convey("Then 'expires' should be the default expiration from now", func() {
+
+				So(err, ShouldBeNil)
+				So(sessionData["expires"], ShouldBeGreaterThanOrEqualTo, before+600)
+				So(sessionData["expires"], ShouldBeLessThanOrEqualTo, time.Now().Unix()+600)
+			})
+		})
+
+		Convey("When I call RefreshExpiration with an unparsable default expiration", func() {
+
+			cfg.DefaultExpiration = "ten minutes"
+			err := sessionData.RefreshExpiration()
+
+			Convey("Then a config error should be returned and 'expires' left unset", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "DEFAULT_SESSION_EXPIRATION")
+				So(sessionData, ShouldNotContainKey, "expires")
+			})
+		})
+	})
+}
+
+// fixedClock is a Clock frozen at a single time
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// TestUnitRefreshExpirationWithClock verifies that the expiry is counted from
+// the time on the given Clock
+func TestUnitRefreshExpirationWithClock(t *testing.T) {
+
+	Convey("Given I have session data with an 'expires_in' of 123 seconds", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in": uint16(123),
+				},
+			},
+		}
+
+		Convey("When I refresh the expiration with a clock frozen at a fixed time", func() {
+
+			err := sessionData.RefreshExpirationWithClock(fixedClock(time.Unix(1700000000, 0)))
+
+			Convey("Then it should expire exactly 123 seconds after that time", func() {
+
+				So(err, ShouldBeNil)
+				So(sessionData["expires"], ShouldEqual, int64(1700000123))
+			})
+		})
+	})
+}
+
+// TestUnitFeatureFlags verifies that feature flag overrides are read from the
+// session data
+func TestUnitFeatureFlags(t *testing.T) {
+
+	Convey("Given I have session data with feature flag overrides", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"feature_flags": map[string]interface{}{
+				"new_search":  true,
+				"beta_banner": false,
+			},
+		}
+
+		Convey("When I call FeatureFlags", func() {
+
+			flags := sessionData.FeatureFlags()
+
+			Convey("Then the overrides should be returned", func() {
+
+				So(len(flags), ShouldEqual, 2)
+				So(flags["new_search"], ShouldBeTrue)
+				So(flags["beta_banner"], ShouldBeFalse)
+			})
+		})
+	})
+}
+
+// TestUnitFeatureFlagsNonePresent verifies that an empty map is returned when
+// no feature flag overrides are present on the session
+func TestUnitFeatureFlagsNonePresent(t *testing.T) {
+
+	Convey("Given I have session data with no feature flag overrides", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("When I call FeatureFlags", func() {
+
+			flags := sessionData.FeatureFlags()
+
+			Convey("Then an empty map should be returned", func() {
+
+				So(flags, ShouldNotBeNil)
+				So(len(flags), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+// TestUnitSetFeatureFlag verifies that a feature flag override is stored on the
+// session data, even if no overrides were previously present
+func TestUnitSetFeatureFlag(t *testing.T) {
+
+	Convey("Given I have session data with no feature flag overrides", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("When I call SetFeatureFlag", func() {
+
+			sessionData.SetFeatureFlag("new_search", true)
+
+			Convey("Then the override should be stored", func() {
+
+				So(sessionData.FeatureFlags()["new_search"], ShouldBeTrue)
+
+				Convey("And can subsequently be updated", func() {
+
+					sessionData.SetFeatureFlag("new_search", false)
+					So(sessionData.FeatureFlags()["new_search"], ShouldBeFalse)
+				})
+			})
+		})
+	})
+}
+
+// TestUnitGetExpirationNotSignedIn verifies that when there is no signin info on
+// the session, 0 is returned
+func TestUnitGetExpirationNotSignedIn(t *testing.T) {
+
+	Convey("Given I have an empty session map", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("When I call GetExpiration", func() {
+
+			expiration := sessionData.GetExpiration()
+
+			Convey("Then 0 should be returned", func() {
+
+				So(expiration, ShouldEqual, uint64(0))
+			})
+		})
+	})
+}
+
+// TestUnitAbsoluteDeadline verifies that the absolute deadline is calculated from
+// the session creation time
+func TestUnitAbsoluteDeadline(t *testing.T) {
+
+	Convey("Given I have session data with a 'created_at' time", t, func() {
+
+		createdAt := uint32(1500000000)
+
+		var sessionData Session = map[string]interface{}{
+			"created_at": createdAt,
+		}
+
+		Convey("When I call AbsoluteDeadline with a maximum lifetime of 12 hours", func() {
+
+			deadline, ok := sessionData.AbsoluteDeadline(12 * time.Hour)
+
+			Convey("Then the deadline should be 12 hours after creation", func() {
+
+				So(ok, ShouldBeTrue)
+				So(deadline, ShouldEqual, time.Unix(int64(createdAt), 0).Add(12*time.Hour))
+			})
+		})
+	})
+}
+
+// TestUnitAbsoluteDeadlineNoCreationTime verifies that no deadline is returned when
+// the session creation time is unknown
+func TestUnitAbsoluteDeadlineNoCreationTime(t *testing.T) {
+
+	Convey("Given I have session data with no 'created_at' time", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("When I call AbsoluteDeadline", func() {
+
+			deadline, ok := sessionData.AbsoluteDeadline(12 * time.Hour)
+
+			Convey("Then no deadline should be returned", func() {
+
+				So(ok, ShouldBeFalse)
+				So(deadline.IsZero(), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+// TestUnitGetUserID verifies that the user ID is returned from the user profile,
+// or blank if there is none
+func TestUnitGetUserID(t *testing.T) {
+
+	Convey("Given I have session data with a user profile", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"signin_info": map[string]interface{}{
+				"user_profile": map[string]interface{}{
+					"id": "Foo",
+				},
+			},
+		}
+
+		Convey("When I call GetUserID", func() {
+
+			Convey("Then the user ID should be returned", func() {
+
+				So(sessionData.GetUserID(), ShouldEqual, "Foo")
+			})
+		})
+	})
+
+	Convey("Given I have session data with no signin info", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("When I call GetUserID", func() {
+
+			Convey("Then a blank user ID should be returned", func() {
+
+				So(sessionData.GetUserID(), ShouldBeBlank)
+			})
+		})
+	})
+}
+
+// TestUnitLastAccess verifies that the last access time is read from the session
+// data, whichever integer type it was decoded to
+func TestUnitLastAccess(t *testing.T) {
+
+	Convey("Given I have session data with a 'last_access' time", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"last_access": uint32(1500000000),
+		}
+
+		Convey("When I call LastAccess", func() {
+
+			lastAccess, ok := sessionData.LastAccess()
+
+			Convey("Then the last access time should be returned", func() {
+
+				So(ok, ShouldBeTrue)
+				So(lastAccess, ShouldEqual, time.Unix(1500000000, 0))
+			})
+		})
+	})
+}
+
+// TestUnitProject verifies that only the named keys, including nested paths, are
+// included in a projected session
+func TestUnitProject(t *testing.T) {
+
+	Convey("Given I have session data holding tokens", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"expires": uint32(1500000000),
+			"signin_info": map[string]interface{}{
+				"signed_in": int8(1),
+				"access_token": map[string]interface{}{
+					"access_token":  "secret",
+					"refresh_token": "also-secret",
+				},
+				"user_profile": map[string]interface{}{
+					"id":    "user-1",
+					"email": "user@example.com",
+				},
+			},
+		}
+
+		Convey("When I project a top level key, a nested path and a missing key", func() {
+
+			projected := sessionData.Project("expires", "signin_info.user_profile.id", "signin_info.missing", "missing")
+
+			Convey("Then only the named keys should be present", func() {
+
+				So(projected, ShouldResemble, Session{
+					"expires": uint32(1500000000),
+					"signin_info": map[string]interface{}{
+						"user_profile": map[string]interface{}{
+							"id": "user-1",
+						},
+					},
+				})
+			})
+
+			Convey("And the original session should be left untouched", func() {
+
+				userProfile := sessionData["signin_info"].(map[string]interface{})["user_profile"].(map[string]interface{})
+				So(userProfile["email"], ShouldEqual, "user@example.com")
+			})
+		})
+	})
+}
+
+// TestUnitRenewalCount verifies that the renewal count is read from the session
+// data, defaulting to zero
+func TestUnitRenewalCount(t *testing.T) {
+
+	Convey("Given I have session data with a 'renewal_count'", t, func() {
+
+		var sessionData Session = map[string]interface{}{"renewal_count": uint8(3)}
+
+		Convey("Then the renewal count should be returned", func() {
+
+			So(sessionData.RenewalCount(), ShouldEqual, 3)
+		})
+	})
+
+	Convey("Given I have session data with no 'renewal_count'", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("Then zero should be returned", func() {
+
+			So(sessionData.RenewalCount(), ShouldEqual, 0)
+		})
+	})
+}
+
+// TestUnitRequireScopes verifies that the required scopes are checked against
+// those granted to the session's access token
+func TestUnitRequireScopes(t *testing.T) {
+
+	Convey("Given I have session data whose access token has been granted scopes", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"scope": "https://account.example.com/user.read https://api.example.com/company.write",
+				},
+			},
+		}
+
+		Convey("When every required scope has been granted", func() {
+
+			err := sessionData.RequireScopes("https://api.example.com/company.write", "https://account.example.com/user.read")
+
+			Convey("Then no error should be returned", func() {
+
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When some required scopes haven't been granted", func() {
+
+			err := sessionData.RequireScopes("https://account.example.com/user.read", "payments", "filing")
+
+			Convey("Then the missing scopes should be listed in the error", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Session is missing required scopes: payments, filing")
+			})
+		})
+	})
+
+	Convey("Given I have session data with no scopes", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("Then every required scope should be missing", func() {
+
+			So(sessionData.Scopes(), ShouldBeEmpty)
+			So(sessionData.RequireScopes("payments").Error(), ShouldEqual, "Session is missing required scopes: payments")
+			So(sessionData.RequireScopes(), ShouldBeNil)
+		})
+	})
+}
+
+// TestUnitRefreshTokenExpiry verifies that the refresh token's expiry is counted
+// from when the refresh token was set, and is unknown if it isn't on the session
+func TestUnitRefreshTokenExpiry(t *testing.T) {
+
+	Convey("Given I have set a refresh token on session data with a 'refresh_expires_in'", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"expires": uint32(1000),
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in":         uint16(100),
+					"refresh_expires_in": uint32(500),
+				},
+			},
+		}
+		sessionData.SetRefreshTokenWithClock("Bar", fixedClock(time.Unix(900, 0)))
+
+		Convey("Then the refresh token should expire that long after it was set", func() {
+
+			expiry, ok := sessionData.RefreshTokenExpiry()
+
+			So(ok, ShouldBeTrue)
+			So(expiry, ShouldEqual, time.Unix(1400, 0))
+		})
+
+		Convey("When the session's expiry is slid on", func() {
+
+			sessionData["expires"] = uint32(5000)
+
+			Convey("Then the refresh token's expiry should be unchanged", func() {
+
+				expiry, ok := sessionData.RefreshTokenExpiry()
+
+				So(ok, ShouldBeTrue)
+				So(expiry, ShouldEqual, time.Unix(1400, 0))
+			})
+		})
+	})
+
+	Convey("Given I have session data with a 'refresh_expires_in' but no record of when the refresh token was set", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"expires": uint32(1000),
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in":         uint16(100),
+					"refresh_expires_in": uint32(500),
+				},
+			},
+		}
+
+		Convey("Then the refresh token's expiry should be unknown", func() {
+
+			_, ok := sessionData.RefreshTokenExpiry()
+
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given I have session data with no 'refresh_expires_in'", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"expires": uint32(1000),
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in": uint16(100),
+				},
+			},
+		}
+
+		Convey("Then the refresh token's expiry should be unknown", func() {
+
+			_, ok := sessionData.RefreshTokenExpiry()
+
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+// TestUnitExpClaim verifies that a JWT-style 'exp' claim is read from the top
+// level or the access token, whether decoded as a float64 or an integer
+func TestUnitExpClaim(t *testing.T) {
+
+	Convey("Given I have session data with a float64 'exp', as decoded from JSON", t, func() {
+
+		var sessionData Session = map[string]interface{}{"exp": float64(1700000000)}
+
+		Convey("Then the claim should be returned in whole seconds", func() {
+
+			exp, ok := sessionData.ExpClaim()
+
+			So(ok, ShouldBeTrue)
+			So(exp, ShouldEqual, uint64(1700000000))
+		})
+	})
+
+	Convey("Given I have session data with an integer 'exp' on the access token", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"exp": int64(1700000000),
+				},
+			},
+		}
+
+		Convey("Then the nested claim should be returned", func() {
+
+			exp, ok := sessionData.ExpClaim()
+
+			So(ok, ShouldBeTrue)
+			So(exp, ShouldEqual, uint64(1700000000))
+		})
+	})
+
+	Convey("Given I have session data with both a top-level and a nested 'exp'", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"exp": uint32(1800000000),
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"exp": float64(1700000000),
+				},
+			},
+		}
+
+		Convey("Then the top-level claim should be preferred", func() {
+
+			exp, ok := sessionData.ExpClaim()
+
+			So(ok, ShouldBeTrue)
+			So(exp, ShouldEqual, uint64(1800000000))
+		})
+	})
+
+	Convey("Given I have session data with no 'exp', or one which isn't a number", t, func() {
+
+		for _, sessionData := range []Session{{}, {"exp": "soon"}, {"exp": float64(0)}} {
+
+			_, ok := sessionData.ExpClaim()
+
+			So(ok, ShouldBeFalse)
+		}
+	})
+}
+
+// TestUnitCreatedAt verifies that the creation time is read from the session
+// data, and is unknown if it isn't set
+func TestUnitCreatedAt(t *testing.T) {
+
+	Convey("Given I have session data with a 'created_at'", t, func() {
+
+		var sessionData Session = map[string]interface{}{"created_at": uint64(1000)}
+
+		Convey("Then the creation time should be returned", func() {
+
+			createdAt, ok := sessionData.CreatedAt()
+
+			So(ok, ShouldBeTrue)
+			So(createdAt, ShouldEqual, time.Unix(1000, 0))
+		})
+	})
+
+	Convey("Given I have session data with no 'created_at'", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("Then the creation time should be unknown", func() {
+
+			_, ok := sessionData.CreatedAt()
+
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+// TestUnitDelete verifies that a single value is removed from the session data,
+// and that deleting a missing key leaves it unchanged
+func TestUnitDelete(t *testing.T) {
+
+	Convey("Given I have session data with a redirect target", t, func() {
+
+		var sessionData Session = map[string]interface{}{"redirect": "/done", "role": "user"}
+
+		Convey("When I delete the redirect target", func() {
+
+			sessionData.Delete("redirect")
+
+			Convey("Then only it should be removed", func() {
+
+				So(sessionData, ShouldResemble, Session{"role": "user"})
+			})
+		})
+
+		Convey("When I delete a key which isn't present", func() {
+
+			sessionData.Delete("missing")
+
+			Convey("Then the session data should be unchanged", func() {
+
+				So(sessionData, ShouldResemble, Session{"redirect": "/done", "role": "user"})
+			})
+		})
+	})
+}
+
+// TestUnitClear verifies that clearing the session data keeps the protected
+// internal keys, unless everything is cleared
+func TestUnitClear(t *testing.T) {
+
+	Convey("Given I have session data with protected and unprotected keys", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"csrf_token":  "token",
+			"expires":     uint32(1000),
+			"signin_info": map[string]interface{}{},
+			"role":        "user",
+		}
+
+		Convey("When I clear it", func() {
+
+			sessionData.Clear()
+
+			Convey("Then only the protected keys should remain", func() {
+
+				So(sessionData, ShouldResemble, Session{"csrf_token": "token", "expires": uint32(1000)})
+			})
+		})
+
+		Convey("When I clear all of it", func() {
+
+			sessionData.ClearAll()
+
+			Convey("Then it should be empty", func() {
+
+				So(sessionData, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+// TestUnitKeys verifies that the keys of the session data are returned sorted,
+// without the bookkeeping keys when requested
+func TestUnitKeys(t *testing.T) {
+
+	Convey("Given I have session data with bookkeeping and application keys", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"role":        "user",
+			"csrf_token":  "token",
+			"expires":     int64(1000),
+			"last_access": int64(900),
+			"_version":    uint64(3),
+			"basket":      map[string]interface{}{"items": 2},
+		}
+
+		Convey("When I list its keys", func() {
+
+			keys := sessionData.Keys()
+
+			Convey("Then every key should be returned, sorted", func() {
+
+				So(keys, ShouldResemble, []string{"_version", "basket", "csrf_token", "expires", "last_access", "role"})
+			})
+		})
+
+		Convey("When I list its application keys", func() {
+
+			keys := sessionData.ApplicationKeys()
+
+			Convey("Then only the bookkeeping keys should be left out, sorted", func() {
+
+				So(keys, ShouldResemble, []string{"basket", "csrf_token", "role"})
+			})
+
+			Convey("Then changing the list should leave the session data unchanged", func() {
+
+				keys[0] = "changed"
+				So(sessionData, ShouldContainKey, "basket")
+			})
+		})
+	})
+
+	Convey("Given I have empty session data", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("Then an empty list of keys should be returned", func() {
+
+			So(sessionData.Keys(), ShouldBeEmpty)
+			So(sessionData.ApplicationKeys(), ShouldBeEmpty)
+		})
+	})
+}
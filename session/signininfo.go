@@ -0,0 +1,77 @@
+package session
+
+// SignInInfo is a typed view of the commonly used fields of the 'signin_info'
+// map on the session data
+type SignInInfo struct {
+	SignedIn     bool   `json:"signed_in"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    uint16 `json:"expires_in"`
+	UserID       string `json:"user_id"`
+	Email        string `json:"email"`
+}
+
+// SignInInfo projects the 'signin_info' map on the session data into a
+// SignInInfo. Any fields missing from the map are left as their zero values.
+// Returns false if there is no 'signin_info' on the session
+func (data *Session) SignInInfo() (SignInInfo, bool) {
+	signinInfo, ok := (*data)["signin_info"].(map[string]interface{})
+	if !ok {
+		return SignInInfo{}, false
+	}
+
+	accessToken, _ := signinInfo["access_token"].(map[string]interface{})
+	userProfile, _ := signinInfo["user_profile"].(map[string]interface{})
+
+	info := SignInInfo{SignedIn: data.IsSignedIn()}
+	info.AccessToken, _ = accessToken["access_token"].(string)
+	info.RefreshToken, _ = accessToken["refresh_token"].(string)
+	if expiresIn, ok := ToInt64(accessToken["expires_in"]); ok {
+		info.ExpiresIn = uint16(expiresIn)
+	}
+	info.UserID, _ = userProfile["id"].(string)
+	info.Email, _ = userProfile["email"].(string)
+
+	return info, true
+}
+
+// SetSignInInfo writes a SignInInfo back into the 'signin_info' map on the
+// session data, creating it if necessary. Any other values already in the map
+// are left as they are. The user profile is only written if the SignInInfo has
+// a user ID or email, or there is already a profile on the session. A refresh
+// token which differs from the one on the session is recorded as issued now,
+// as by SetRefreshToken
+func (data *Session) SetSignInInfo(info SignInInfo) {
+	signinInfo := nestedMap(*data, "signin_info")
+
+	signedIn := int8(0)
+	if info.SignedIn {
+		signedIn = 1
+	}
+	signinInfo["signed_in"] = signedIn
+
+	accessToken := nestedMap(signinInfo, "access_token")
+	accessToken["access_token"] = info.AccessToken
+	if refreshToken, _ := accessToken["refresh_token"].(string); refreshToken != info.RefreshToken {
+		data.SetRefreshToken(info.RefreshToken)
+	}
+	accessToken["expires_in"] = info.ExpiresIn
+
+	_, hasProfile := signinInfo["user_profile"].(map[string]interface{})
+	if hasProfile || info.UserID != "" || info.Email != "" {
+		userProfile := nestedMap(signinInfo, "user_profile")
+		userProfile["id"] = info.UserID
+		userProfile["email"] = info.Email
+	}
+}
+
+// nestedMap returns the map stored under key in the parent map, creating it if
+// it doesn't already exist
+func nestedMap(parent map[string]interface{}, key string) map[string]interface{} {
+	nested, ok := parent[key].(map[string]interface{})
+	if !ok {
+		nested = map[string]interface{}{}
+		parent[key] = nested
+	}
+	return nested
+}
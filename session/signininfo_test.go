@@ -0,0 +1,107 @@
+package session
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/vmihailenco/msgpack"
+)
+
+// TestUnitSignInInfoRoundTrip verifies that a SignInInfo written to the session
+// data reads back the same, including after being encoded and decoded
+func TestUnitSignInInfoRoundTrip(t *testing.T) {
+
+	Convey("Given I have an empty session", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		info := SignInInfo{
+			SignedIn:     true,
+			AccessToken:  "access",
+			RefreshToken: "refresh",
+			ExpiresIn:    3600,
+			UserID:       "user-1",
+			Email:        "user@example.com",
+		}
+
+		Convey("When I set the sign in info", func() {
+
+			sessionData.SetSignInInfo(info)
+
+			Convey("Then it should read back the same", func() {
+
+				read, ok := sessionData.SignInInfo()
+				So(ok, ShouldBeTrue)
+				So(read, ShouldResemble, info)
+			})
+
+			Convey("And the existing helpers should see it", func() {
+
+				So(sessionData.IsSignedIn(), ShouldBeTrue)
+				So(sessionData.GetAccessToken(), ShouldEqual, "access")
+				So(sessionData.GetUserID(), ShouldEqual, "user-1")
+				So(sessionData.GetExpiration(), ShouldEqual, 3600)
+			})
+
+			Convey("And it should survive being encoded and decoded", func() {
+
+				encoded, err := msgpack.Marshal(map[string]interface{}(sessionData))
+				So(err, ShouldBeNil)
+
+				var decoded Session
+				So(msgpack.Unmarshal(encoded, &decoded), ShouldBeNil)
+
+				read, ok := decoded.SignInInfo()
+				So(ok, ShouldBeTrue)
+				So(read, ShouldResemble, info)
+			})
+		})
+	})
+}
+
+// TestUnitSignInInfoPartial verifies that absent and partial sign in info is read
+// without panicking
+func TestUnitSignInInfoPartial(t *testing.T) {
+
+	Convey("Given I have a session with no sign in info", t, func() {
+
+		var sessionData Session = map[string]interface{}{}
+
+		Convey("Then no sign in info should be returned", func() {
+
+			info, ok := sessionData.SignInInfo()
+			So(ok, ShouldBeFalse)
+			So(info, ShouldResemble, SignInInfo{})
+		})
+	})
+
+	Convey("Given I have a session with only an access token", t, func() {
+
+		var sessionData Session = map[string]interface{}{
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"access_token": "access",
+					"expires_in":   "not a number",
+				},
+				"extra": "kept",
+			},
+		}
+
+		Convey("Then only the access token should be set", func() {
+
+			info, ok := sessionData.SignInInfo()
+			So(ok, ShouldBeTrue)
+			So(info, ShouldResemble, SignInInfo{AccessToken: "access"})
+		})
+
+		Convey("And writing it back should keep other values", func() {
+
+			info, _ := sessionData.SignInInfo()
+			sessionData.SetSignInInfo(info)
+
+			signinInfo := sessionData["signin_info"].(map[string]interface{})
+			So(signinInfo["extra"], ShouldEqual, "kept")
+			So(signinInfo["user_profile"], ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,65 @@
+package state
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+//aclDialer returns a Redis dialer which authenticates each new connection as
+//the given ACL user, as Redis 6 and later require for users other than the
+//default. The Redis client's Options have no username, and it only sends AUTH
+//with a password, so this is done before the connection is handed to it, and
+//the client shouldn't be given the password as well. Connections are dialed
+//with the options' DialTimeout and, if they have a TLSConfig, over TLS, as the
+//client's own dialer would.
+func aclDialer(options *redis.Options, username string, password string) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn, err := net.DialTimeout("tcp", options.Addr, options.DialTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if options.TLSConfig != nil {
+			conn = tls.Client(conn, options.TLSConfig)
+		}
+
+		if err := authenticate(conn, username, password, options.ReadTimeout); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+//authenticate sends AUTH with the username and password over the connection
+//and reads the reply, returning an error if it isn't OK.
+func authenticate(conn net.Conn, username string, password string, timeout time.Duration) error {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	command := fmt.Sprintf("*3\r\n$4\r\nAUTH\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(username), username, len(password), password)
+	if _, err := conn.Write([]byte(command)); err != nil {
+		return err
+	}
+
+	// Redis sends nothing after the reply until the client's next command, so
+	// nothing the client needs is lost with the buffer
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	reply = strings.TrimSuffix(reply, "\r\n")
+	if reply != "+OK" {
+		return errors.New("Redis ACL authentication failed: " + strings.TrimPrefix(reply, "-"))
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
@@ -0,0 +1,161 @@
+package state
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// fakeRedisServer accepts a single connection, records the AUTH command sent
+// over it and answers with the given reply
+func fakeRedisServer(reply string) (addr string, commands chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+
+	commands = make(chan []string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Read the *3 array header, then the length and value of each argument
+		reader := bufio.NewReader(conn)
+		reader.ReadString('\n')
+		var args []string
+		for i := 0; i < 3; i++ {
+			reader.ReadString('\n')
+			arg, _ := reader.ReadString('\n')
+			args = append(args, arg[:len(arg)-2])
+		}
+		commands <- args
+		conn.Write([]byte(reply + "\r\n"))
+	}()
+
+	return listener.Addr().String(), commands
+}
+
+// ------------------- Routes Through aclDialer() -------------------
+
+// TestUnitCacheUsername - Verify that with a cache username configured, new
+// connections authenticate as that ACL user
+func TestUnitCacheUsername(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given the Redis client options are captured", t, func() {
+
+		var options *redis.Options
+		defer func(original func(*redis.Options) Connection) { newRedisConnection = original }(newRedisConnection)
+		newRedisConnection = func(o *redis.Options) Connection {
+			options = o
+			return newFakeConnection()
+		}
+
+		Convey("When I create a cache without a username", func() {
+
+			NewCache("localhost:6379", 0, "secret")
+
+			Convey("Then the client should authenticate with the password alone", func() {
+
+				So(options.Password, ShouldEqual, "secret")
+			})
+		})
+
+		Convey("When I create a cache with a username and the server accepts it", func() {
+
+			cfg := config.Get()
+			cfg.CacheUsername = "sessions"
+			defer func() { cfg.CacheUsername = "" }()
+
+			addr, commands := fakeRedisServer("+OK")
+			NewCache(addr, 0, "secret")
+
+			conn, err := options.Dialer()
+
+			Convey("Then the connection should be authenticated as the ACL user", func() {
+
+				So(err, ShouldBeNil)
+				So(conn, ShouldNotBeNil)
+				So(<-commands, ShouldResemble, []string{"AUTH", "sessions", "secret"})
+				So(options.Password, ShouldBeBlank)
+				So(options.Dialer, ShouldNotBeNil)
+				So(options.DialTimeout, ShouldEqual, defaultDialTimeout)
+				conn.Close()
+			})
+		})
+
+		Convey("When I create a cache with a username and the server rejects it", func() {
+
+			cfg := config.Get()
+			cfg.CacheUsername = "sessions"
+			defer func() { cfg.CacheUsername = "" }()
+
+			addr, _ := fakeRedisServer("-WRONGPASS invalid username-password pair")
+			NewCache(addr, 0, "wrong")
+
+			_, err := options.Dialer()
+
+			Convey("Then dialing should fail with the server's error", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Redis ACL authentication failed: WRONGPASS invalid username-password pair")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitACLDialerTimeout - Verify that the ACL dialer dials with the options'
+// dial timeout rather than a fixed one
+func TestUnitACLDialerTimeout(t *testing.T) {
+
+	Convey("Given a listener which never answers", t, func() {
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+
+		options := &redis.Options{
+			Addr:        listener.Addr().String(),
+			DialTimeout: time.Second,
+			ReadTimeout: 50 * time.Millisecond,
+		}
+
+		Convey("When I dial it as an ACL user", func() {
+
+			start := time.Now()
+			_, err := aclDialer(options, "sessions", "secret")()
+
+			Convey("Then authenticating should give up after the options' read timeout", func() {
+
+				So(err, ShouldNotBeNil)
+				So(time.Since(start), ShouldBeLessThan, time.Second)
+			})
+		})
+
+		Convey("When I dial an unroutable address with a short dial timeout", func() {
+
+			options.Addr = "10.255.255.1:6379"
+			options.DialTimeout = 50 * time.Millisecond
+
+			start := time.Now()
+			_, err := aclDialer(options, "sessions", "secret")()
+
+			Convey("Then dialing should give up after the options' dial timeout", func() {
+
+				So(err, ShouldNotBeNil)
+				So(time.Since(start), ShouldBeLessThan, time.Second)
+			})
+		})
+	})
+}
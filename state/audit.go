@@ -0,0 +1,71 @@
+package state
+
+import (
+	"reflect"
+	"sort"
+
+	session "github.com/companieshouse/go-session-handler/session"
+)
+
+//ChangedKeys returns the top-level keys of the session data which were added,
+//removed or modified by the last successful Store, compared with the data as
+//loaded or last stored, e.g. for audit logging without logging the values.
+//Internal keys, such as the session's expiry, are left out. A session which
+//wasn't loaded has all of its keys reported as added.
+func (s *Store) ChangedKeys() []string {
+	return s.changedKeys
+}
+
+//takeSnapshot records a copy of the session data to compare against when it is
+//next stored. Nested maps and slices are copied too, so that changes made to
+//them in place are still detected.
+func (s *Store) takeSnapshot() {
+	s.snapshot = map[string]interface{}{}
+	for key, value := range s.Data {
+		if !session.IsProtectedKey(key) {
+			s.snapshot[key] = copyValue(value)
+		}
+	}
+}
+
+//diffSnapshot returns the sorted top-level keys whose values differ between the
+//snapshot and the session data, ignoring internal keys.
+func (s *Store) diffSnapshot() []string {
+	var changed []string
+	for key, value := range s.Data {
+		if session.IsProtectedKey(key) {
+			continue
+		}
+		if snapshotted, ok := s.snapshot[key]; !ok || !reflect.DeepEqual(snapshotted, value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range s.snapshot {
+		if _, ok := s.Data[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+//copyValue returns a deep copy of the maps and slices decoded session data may
+//hold. Any other value is returned as is.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			copied[key] = copyValue(nested)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, nested := range v {
+			copied[i] = copyValue(nested)
+		}
+		return copied
+	}
+	return value
+}
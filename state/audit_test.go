@@ -0,0 +1,116 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ------------------- Routes Through ChangedKeys() -------------------
+
+// TestUnitChangedKeys - Verify that storing a session reports which top-level
+// keys were added, removed or modified, leaving out internal keys
+func TestUnitChangedKeys(t *testing.T) {
+
+	initConfig()
+
+	cfg := config.Get()
+	defaultExpiration := cfg.DefaultExpiration
+	cfg.DefaultExpiration = "600"
+	defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+	Convey("Given I have loaded a stored session", t, func() {
+
+		cache := NewMemoryCache()
+		stored := NewStore(cache)
+		stored.Data = map[string]interface{}{
+			"expires": time.Now().Unix() + 3600,
+			"role":    "admin",
+			"page":    "search",
+			"basket":  map[string]interface{}{"items": []interface{}{"a"}},
+		}
+		So(stored.Store(), ShouldBeNil)
+
+		s := NewStore(cache)
+		So(s.Load(stored.CookieValue()), ShouldBeNil)
+
+		Convey("When I store it unchanged", func() {
+
+			So(s.Store(), ShouldBeNil)
+
+			Convey("Then no keys should have changed, despite its last access moving on", func() {
+
+				So(s.ChangedKeys(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I add, remove and modify keys", func() {
+
+			s.Data["team"] = "red"
+			delete(s.Data, "page")
+			s.Data["role"] = "viewer"
+
+			So(s.Store(), ShouldBeNil)
+
+			Convey("Then each of those keys should have changed", func() {
+
+				So(s.ChangedKeys(), ShouldResemble, []string{"page", "role", "team"})
+			})
+
+			Convey("And when I store it again unchanged", func() {
+
+				So(s.Store(), ShouldBeNil)
+
+				Convey("Then the changes should be relative to the last store", func() {
+
+					So(s.ChangedKeys(), ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("When I modify a nested value in place", func() {
+
+			basket := s.Data["basket"].(map[string]interface{})
+			basket["items"] = append(basket["items"].([]interface{}), "b")
+
+			So(s.Store(), ShouldBeNil)
+
+			Convey("Then its top-level key should have changed", func() {
+
+				So(s.ChangedKeys(), ShouldResemble, []string{"basket"})
+			})
+		})
+
+		Convey("When I only change internal keys", func() {
+
+			s.SetExpiration(7200)
+
+			So(s.Store(), ShouldBeNil)
+
+			Convey("Then no keys should have changed", func() {
+
+				So(s.ChangedKeys(), ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given I have a new session", t, func() {
+
+		s := NewStore(NewMemoryCache())
+		s.Data = map[string]interface{}{"role": "admin"}
+
+		Convey("When I store it", func() {
+
+			So(s.Store(), ShouldBeNil)
+
+			Convey("Then all of its keys should have been added", func() {
+
+				So(s.ChangedKeys(), ShouldResemble, []string{"role"})
+			})
+		})
+	})
+
+	cleanupConfig()
+}
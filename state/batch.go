@@ -0,0 +1,161 @@
+package state
+
+import (
+	"sync"
+	"time"
+)
+
+//indexBatcher coalesces additions to the per-user session indexes so that
+//repeated stores for a hot user are written behind as a single SADD per index,
+//either once maxSize additions are pending or every flush interval.
+type indexBatcher struct {
+	mu      sync.Mutex
+	pending map[string]map[string]struct{}
+	ttls    map[string]time.Duration
+	size    int
+	maxSize int
+
+	write func(key string, ttl time.Duration, members ...interface{}) error
+
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+//newIndexBatcher creates an indexBatcher which writes using the given function,
+//flushing in the background every interval until it is closed.
+func newIndexBatcher(maxSize int, interval time.Duration, write func(key string, ttl time.Duration, members ...interface{}) error) *indexBatcher {
+	b := &indexBatcher{
+		pending: map[string]map[string]struct{}{},
+		ttls:    map[string]time.Duration{},
+		maxSize: maxSize,
+		write:   write,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go b.run(interval)
+	return b
+}
+
+//run flushes the pending additions every interval until the batcher is closed.
+//Failed writes stay pending, so are retried on the next flush.
+func (b *indexBatcher) run(interval time.Duration) {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.flush()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+//add queues the member to be added to the set stored at key, flushing
+//immediately if the batch has reached its maximum size. The set is written
+//with the longest ttl of the members pending for it.
+func (b *indexBatcher) add(key string, member string, ttl time.Duration) error {
+	b.mu.Lock()
+	members, ok := b.pending[key]
+	if !ok {
+		members = map[string]struct{}{}
+		b.pending[key] = members
+	}
+	b.ttls[key] = longerTTL(b.ttls, key, ttl)
+	if _, ok := members[member]; !ok {
+		members[member] = struct{}{}
+		b.size++
+	}
+	full := b.maxSize > 0 && b.size >= b.maxSize
+	b.mu.Unlock()
+
+	if full {
+		return b.flush()
+	}
+	return nil
+}
+
+//discard drops a pending addition, e.g. when the session has since been
+//removed from the index.
+func (b *indexBatcher) discard(key string, member string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.pending[key][member]; ok {
+		delete(b.pending[key], member)
+		b.size--
+	}
+}
+
+//flush writes every pending addition, one SADD per index. Additions which fail
+//to be written are put back to be retried, and the first error is returned.
+func (b *indexBatcher) flush() error {
+	b.mu.Lock()
+	pending, ttls := b.pending, b.ttls
+	b.pending = map[string]map[string]struct{}{}
+	b.ttls = map[string]time.Duration{}
+	b.size = 0
+	b.mu.Unlock()
+
+	var firstErr error
+	for key, members := range pending {
+		if len(members) == 0 {
+			continue
+		}
+
+		values := make([]interface{}, 0, len(members))
+		for member := range members {
+			values = append(values, member)
+		}
+
+		if err := b.write(key, ttls[key], values...); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			b.requeue(key, members, ttls[key])
+		}
+	}
+	return firstErr
+}
+
+//requeue puts members which failed to be written back into the pending batch,
+//without triggering another flush.
+func (b *indexBatcher) requeue(key string, members map[string]struct{}, ttl time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.pending[key] == nil {
+		b.pending[key] = map[string]struct{}{}
+	}
+	b.ttls[key] = longerTTL(b.ttls, key, ttl)
+	for member := range members {
+		if _, ok := b.pending[key][member]; !ok {
+			b.pending[key][member] = struct{}{}
+			b.size++
+		}
+	}
+}
+
+//close stops the background flushing and writes any pending additions.
+func (b *indexBatcher) close() error {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+		<-b.stopped
+	})
+	return b.flush()
+}
+
+//longerTTL returns the longer of ttl and the one already in ttls for key, a
+//ttl of 0, for a member which never expires, being the longest.
+func longerTTL(ttls map[string]time.Duration, key string, ttl time.Duration) time.Duration {
+	current, ok := ttls[key]
+	if !ok || ttl <= 0 || (current > 0 && ttl > current) {
+		return ttl
+	}
+	return current
+}
@@ -0,0 +1,166 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// countingConnection is a fakeConnection which counts writes to the indexes.
+type countingConnection struct {
+	*fakeConnection
+	sAdds int
+}
+
+func (c *countingConnection) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	if script == indexAddScript {
+		c.sAdds++
+	}
+	return c.fakeConnection.Eval(script, keys, args...)
+}
+
+// ------------------- Routes Through WithIndexBatching() -------------------
+
+// TestUnitIndexBatchingCoalescesWrites - Verify that multiple stores within the
+// flush window produce a single batched index write
+func TestUnitIndexBatchingCoalescesWrites(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given index batching is enabled with a long flush interval", t, func() {
+
+		connection := &countingConnection{fakeConnection: newFakeConnection()}
+		cache := NewCacheWithConnection(connection).WithIndexBatching(100, time.Hour)
+		defer cache.Close()
+
+		Convey("When three sessions are stored for the same user", func() {
+
+			var ids []string
+			for i := 0; i < 3; i++ {
+				s := NewStore(cache)
+				s.Data = signedInData("user-1")
+				So(s.Store(), ShouldBeNil)
+				ids = append(ids, s.ID)
+			}
+
+			Convey("Then nothing should be written to the index until it is flushed", func() {
+
+				So(connection.sAdds, ShouldEqual, 0)
+
+				members, err := cache.getIndex(userSessionsKey("user-1"))
+
+				So(err, ShouldBeNil)
+				So(connection.sAdds, ShouldEqual, 1)
+				So(members, ShouldHaveLength, 3)
+				for _, id := range ids {
+					So(members, ShouldContain, id)
+				}
+			})
+		})
+
+		Convey("When a session is stored and then deleted before the flush", func() {
+
+			s := NewStore(cache)
+			s.Data = signedInData("user-1")
+			So(s.Store(), ShouldBeNil)
+			So(s.Delete(nil), ShouldBeNil)
+			So(cache.Close(), ShouldBeNil)
+
+			Convey("Then it should never be written to the index", func() {
+
+				So(connection.sAdds, ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given index batching is enabled with a maximum batch size of two", t, func() {
+
+		connection := &countingConnection{fakeConnection: newFakeConnection()}
+		cache := NewCacheWithConnection(connection).WithIndexBatching(2, time.Hour)
+		defer cache.Close()
+
+		Convey("When two sessions are stored", func() {
+
+			for i := 0; i < 2; i++ {
+				s := NewStore(cache)
+				s.Data = signedInData("user-1")
+				So(s.Store(), ShouldBeNil)
+			}
+
+			Convey("Then the batch should be flushed as a single write", func() {
+
+				So(connection.sAdds, ShouldEqual, 1)
+				So(connection.sets[userSessionsKey("user-1")], ShouldHaveLength, 2)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitIndexBatchingFlushesOnClose - Verify that closing the cache on shutdown
+// flushes pending index updates
+func TestUnitIndexBatchingFlushesOnClose(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given sessions for two users are pending in the index batch", t, func() {
+
+		connection := &countingConnection{fakeConnection: newFakeConnection()}
+		cache := NewCacheWithConnection(connection).WithIndexBatching(100, time.Hour)
+
+		for _, userID := range []string{"user-1", "user-2"} {
+			s := NewStore(cache)
+			s.Data = signedInData(userID)
+			So(s.Store(), ShouldBeNil)
+		}
+
+		Convey("When the cache is closed", func() {
+
+			err := cache.Close()
+
+			Convey("Then one write per user's index should be flushed", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.sAdds, ShouldEqual, 2)
+				So(connection.sets[userSessionsKey("user-1")], ShouldHaveLength, 1)
+				So(connection.sets[userSessionsKey("user-2")], ShouldHaveLength, 1)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitIndexBatchingFlushesPeriodically - Verify that pending index updates are
+// flushed once the interval has passed
+func TestUnitIndexBatchingFlushesPeriodically(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given index batching is enabled with a short flush interval", t, func() {
+
+		connection := newFakeConnection()
+		cache := NewCacheWithConnection(connection).WithIndexBatching(100, 10*time.Millisecond)
+
+		s := NewStore(cache)
+		s.Data = signedInData("user-1")
+		So(s.Store(), ShouldBeNil)
+
+		Convey("When the interval has passed", func() {
+
+			time.Sleep(50 * time.Millisecond)
+			So(cache.Close(), ShouldBeNil)
+
+			Convey("Then the session should have been written to the index", func() {
+
+				So(connection.sets[userSessionsKey("user-1")], ShouldContainKey, s.ID)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
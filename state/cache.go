@@ -5,22 +5,68 @@ from the cache.
 package state
 
 import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/companieshouse/go-session-handler/config"
+	"github.com/companieshouse/go-session-handler/session"
 	redis "gopkg.in/redis.v5"
 )
 
 // Connection is the interface used to interact with the Redis database
 type Connection interface {
+	Ping() *redis.StatusCmd
 	Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd
+	SetXX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd
 	Get(key string) *redis.StringCmd
+	MGet(keys ...string) *redis.SliceCmd
 	Del(key ...string) *redis.IntCmd
+	Exists(key string) *redis.BoolCmd
+	Expire(key string, expiration time.Duration) *redis.BoolCmd
+	PTTL(key string) *redis.DurationCmd
+	Scan(cursor uint64, match string, count int64) *redis.ScanCmd
+	SAdd(key string, members ...interface{}) *redis.IntCmd
+	SRem(key string, members ...interface{}) *redis.IntCmd
+	SMembers(key string) *redis.StringSliceCmd
+	HMSet(key string, fields map[string]string) *redis.StatusCmd
+	HGetAll(key string) *redis.StringStringMapCmd
+	HDel(key string, fields ...string) *redis.IntCmd
+	Eval(script string, keys []string, args ...interface{}) *redis.Cmd
+	Close() error
 }
 
+//scanCount is the number of keys requested per SCAN iteration.
+const scanCount = 100
+
+//maxWarmUpConnections bounds the number of connections opened by WithWarmUp,
+//matching the default size of the Redis client's connection pool.
+const maxWarmUpConnections = 10
+
+//defaultCommandTimeout is how long a Redis command may spend reading or writing
+//before it fails, unless configured otherwise, so that a stalled Redis can't
+//hang a request indefinitely.
+const defaultCommandTimeout = 2 * time.Second
+
+//defaultDialTimeout is how long establishing a new connection to Redis may
+//take before it fails.
+const defaultDialTimeout = 5 * time.Second
+
 //Cache is the struct that contains the connection info for retrieving/saving
 //The session data.
 type Cache struct {
+	dialCount  uint64 // accessed atomically, so kept first for alignment
+	mu         sync.RWMutex
 	connection Connection
+	options    *redis.Options
+	batcher    *indexBatcher
+	keepAlive  *keepAlive
+	hashes     bool
+
+	transactions bool
 }
 
 //NewCache will properly initialise a new Cache object.
@@ -28,37 +74,296 @@ func NewCache(addr string, db int, password string) *Cache {
 	cache := &Cache{}
 
 	redisOptions := &redis.Options{
-		Addr:     addr,
-		DB:       db,
-		Password: password,
+		Addr:         addr,
+		DB:           db,
+		Password:     password,
+		DialTimeout:  defaultDialTimeout,
+		ReadTimeout:  defaultCommandTimeout,
+		WriteTimeout: defaultCommandTimeout,
+	}
+
+	if cfg := config.Get(); cfg != nil {
+		redisOptions.ReadTimeout = commandTimeout(cfg.CacheReadTimeout)
+		redisOptions.WriteTimeout = commandTimeout(cfg.CacheWriteTimeout)
+
+		// Authenticate as the ACL user when dialing, rather than as the default user
+		if cfg.CacheUsername != "" {
+			redisOptions.Dialer = aclDialer(redisOptions, cfg.CacheUsername, password)
+			redisOptions.Password = ""
+		}
 	}
 
 	cache.setRedisClient(redisOptions)
 	return cache
 }
 
+//commandTimeout converts a configured timeout in milliseconds to a Duration,
+//falling back to defaultCommandTimeout if it isn't set.
+func commandTimeout(milliseconds int) time.Duration {
+	if milliseconds <= 0 {
+		return defaultCommandTimeout
+	}
+	return time.Duration(milliseconds) * time.Millisecond
+}
+
+//NewCacheWithConnection will initialise a new Cache object using an existing
+//Connection, rather than dialling a new Redis client.
+func NewCacheWithConnection(connection Connection) *Cache {
+	return &Cache{connection: connection}
+}
+
+//WithWarmUp eagerly opens up to the given number of pooled connections, by
+//pinging over each of them concurrently, so that the first requests after boot
+//don't pay the cost of dialing. The number is bounded by maxWarmUpConnections.
+//Failed pings are ignored, as the connection will simply be dialed on first use.
+func (c *Cache) WithWarmUp(connections int) *Cache {
+	if connections > maxWarmUpConnections {
+		connections = maxWarmUpConnections
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < connections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.getConnection().Ping()
+		}()
+	}
+	wg.Wait()
+
+	return c
+}
+
+//WithIndexBatching enables write-behind batching of the per-user session
+//index, so that additions are coalesced into a single SADD per index, written
+//once maxSize additions are pending or every interval, whichever comes first.
+//Close should be called on shutdown so that pending additions are flushed.
+func (c *Cache) WithIndexBatching(maxSize int, interval time.Duration) *Cache {
+	if c.batcher != nil {
+		c.batcher.close()
+	}
+	c.batcher = newIndexBatcher(maxSize, interval, c.writeIndex)
+	return c
+}
+
+//Close stops any keep-alive, flushes any batched index additions and stops
+//batching, then closes the connection to Redis, releasing its pooled
+//connections. It should be called on shutdown, after which the Cache can't be
+//used.
+func (c *Cache) Close() error {
+	if c.keepAlive != nil {
+		c.keepAlive.close()
+	}
+
+	var err error
+	if c.batcher != nil {
+		err = c.batcher.close()
+	}
+
+	if connection := c.getConnection(); connection != nil {
+		if closeErr := connection.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+//getConnection returns the Connection to Redis, which may be replaced by the
+//keep-alive on reconnecting.
+func (c *Cache) getConnection() Connection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connection
+}
+
 /*
    CACHE
 */
 
-//setSessionData stores the Session data in the Cache.
-func (c *Cache) setSessionData(key string, value interface{}) *redis.StatusCmd {
-	return c.connection.Set(key, value, 0)
+//setSessionData stores the Session data in the Cache, to be evicted once the
+//expiration has passed, or kept until deleted if it is 0.
+func (c *Cache) setSessionData(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	return c.getConnection().Set(key, value, expiration)
+}
+
+//setSessionDataIf stores the Session data in the Cache only if the condition
+//is met. Conditional sets reply with whether they were applied, rather than a
+//status, so false is returned with a nil error if the condition wasn't met.
+func (c *Cache) setSessionDataIf(key string, value interface{}, condition SetCondition, expiration time.Duration) (bool, error) {
+	switch condition {
+	case SetIfAbsent:
+		return c.getConnection().SetNX(key, value, expiration).Result()
+	case SetIfPresent:
+		return c.getConnection().SetXX(key, value, expiration).Result()
+	}
+
+	_, err := c.setSessionData(key, value, expiration).Result()
+	return err == nil, err
+}
+
+//setExpiringData stores a value in the Cache which will be evicted once the
+//expiration has passed.
+func (c *Cache) setExpiringData(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	return c.getConnection().Set(key, value, expiration)
+}
+
+//expireSessionData sets the time after which the Session data will be evicted
+//from the Cache, without rewriting it.
+func (c *Cache) expireSessionData(key string, expiration time.Duration) error {
+	_, err := c.getConnection().Expire(key, expiration).Result()
+	return err
+}
+
+//takeSessionData deletes the data at key from the Cache with takeScript,
+//returning it and the time it had left before being evicted, or a negative
+//duration if it never would have been, so that of several concurrent callers,
+//only one takes it. redis.Nil is returned if nothing is stored at key.
+func (c *Cache) takeSessionData(key string) (string, time.Duration, error) {
+	result, err := c.getConnection().Eval(takeScript, []string{key}).Result()
+	if err != nil {
+		return "", 0, err
+	}
+
+	reply, _ := result.([]interface{})
+	if len(reply) != 2 {
+		return "", 0, fmt.Errorf("Unexpected reply taking %s: %v", key, result)
+	}
+	value, _ := reply[0].(string)
+	ttl, _ := session.ToInt64(reply[1])
+	return value, time.Duration(ttl) * time.Millisecond, nil
 }
 
 //getSessionData loads the Session data from the Cache.
 func (c *Cache) getSessionData(key string) (string, error) {
-	return c.connection.Get(key).Result()
+	return c.getConnection().Get(key).Result()
+}
+
+//sessionDataExists reports whether anything is stored at key in the Cache,
+//without loading it.
+func (c *Cache) sessionDataExists(key string) (bool, error) {
+	return c.getConnection().Exists(key).Result()
+}
+
+//getManySessionData loads the Session data stored at each of the keys from the
+//Cache in a single round trip. Keys with nothing stored are omitted from the
+//returned map.
+func (c *Cache) getManySessionData(keys ...string) (map[string]string, error) {
+	values, err := c.getConnection().MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]string{}
+	for i, value := range values {
+		if stored, ok := value.(string); ok && i < len(keys) {
+			data[keys[i]] = stored
+		}
+	}
+	return data, nil
 }
 
 //deleteSessionData removes the Session data from the Cache.
-func (c *Cache) deleteSessionData(key string) error {
-	_, err := c.connection.Del(key).Result()
+func (c *Cache) deleteSessionData(keys ...string) error {
+	_, err := c.getConnection().Del(keys...).Result()
+	return err
+}
+
+//DeleteByPattern removes every key in the Cache matching the given glob-style
+//pattern, returning the number of keys removed. Keys are found using SCAN
+//rather than KEYS so that Redis isn't blocked while iterating.
+func (c *Cache) DeleteByPattern(pattern string) (int, error) {
+	var cursor uint64
+	deleted := 0
+
+	for {
+		keys, next, err := c.getConnection().Scan(cursor, pattern, scanCount).Result()
+		if err != nil {
+			return deleted, err
+		}
+
+		if len(keys) > 0 {
+			count, err := c.getConnection().Del(keys...).Result()
+			deleted += int(count)
+			if err != nil {
+				return deleted, err
+			}
+		}
+
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
+}
+
+//addToIndex adds the member to the set stored at key, or queues it to be added
+//if index batching is enabled. The member expires after ttl, or never if it is
+//0, and the set is kept for at least as long.
+func (c *Cache) addToIndex(key string, member string, ttl time.Duration) error {
+	if c.batcher != nil {
+		return c.batcher.add(key, member, ttl)
+	}
+	return c.writeIndex(key, ttl, member)
+}
+
+//writeIndex adds the members to the set stored at key, extending its TTL to
+//ttl if that is longer, with indexAddScript.
+func (c *Cache) writeIndex(key string, ttl time.Duration, members ...interface{}) error {
+	_, err := c.getConnection().Eval(indexAddScript, []string{key}, indexAddArgs(ttl, members)...).Result()
+	return err
+}
+
+//indexAddArgs returns the arguments of indexAddScript: the TTL in
+//milliseconds, then the members.
+func indexAddArgs(ttl time.Duration, members []interface{}) []interface{} {
+	return append([]interface{}{int64(ttl / time.Millisecond)}, members...)
+}
+
+//removeFromIndex removes the member from the set stored at key, along with any
+//batched addition of it which hasn't been written yet.
+func (c *Cache) removeFromIndex(key string, member string) error {
+	if c.batcher != nil {
+		c.batcher.discard(key, member)
+	}
+	_, err := c.getConnection().SRem(key, member).Result()
 	return err
 }
 
+//getIndex returns the members of the set stored at key, flushing any batched
+//additions first so that none are missed.
+func (c *Cache) getIndex(key string) ([]string, error) {
+	if c.batcher != nil {
+		if err := c.batcher.flush(); err != nil {
+			return nil, err
+		}
+	}
+	return c.getConnection().SMembers(key).Result()
+}
+
 //setRedisClient into the Cache struct
 func (c *Cache) setRedisClient(options *redis.Options) {
-	client := redis.NewClient(options)
-	c.connection = client
+	dial := options.Dialer
+	if dial == nil {
+		dial = func() (net.Conn, error) {
+			return net.DialTimeout("tcp", options.Addr, options.DialTimeout)
+		}
+	}
+	options.Dialer = c.countingDialer(dial)
+
+	c.options = options
+	c.connection = newRedisConnection(options)
+}
+
+//countingDialer wraps a Redis dialer so that every freshly-dialed connection
+//is counted, allowing operations to be classified as cold or warm.
+func (c *Cache) countingDialer(dial func() (net.Conn, error)) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		atomic.AddUint64(&c.dialCount, 1)
+		return dial()
+	}
+}
+
+//dials returns the number of connections dialed by the Cache so far.
+func (c *Cache) dials() uint64 {
+	return atomic.LoadUint64(&c.dialCount)
 }
@@ -0,0 +1,439 @@
+package state
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/config"
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// fakeConnection is an in-memory Connection which pages SCAN results two keys at
+// a time, so that callers must follow the cursor. Like Redis, keys present for
+// the whole scan are returned even if others are deleted part way through.
+type fakeConnection struct {
+	values map[string]string
+	sets   map[string]map[string]bool
+	hashes map[string]map[string]string
+	ttls   map[string]time.Duration
+	order  []string
+}
+
+func newFakeConnection() *fakeConnection {
+	return &fakeConnection{
+		values: map[string]string{},
+		sets:   map[string]map[string]bool{},
+		hashes: map[string]map[string]string{},
+		ttls:   map[string]time.Duration{},
+	}
+}
+
+func (f *fakeConnection) Ping() *redis.StatusCmd {
+	return redis.NewStatusResult("PONG", nil)
+}
+
+func (f *fakeConnection) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	if _, ok := f.values[key]; !ok {
+		f.order = append(f.order, key)
+	}
+	f.values[key] = value.(string)
+
+	// As with Redis, setting a value without an expiration removes any TTL
+	delete(f.ttls, key)
+	if expiration > 0 {
+		f.ttls[key] = expiration
+	}
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (f *fakeConnection) SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	if _, ok := f.values[key]; ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	f.Set(key, value, expiration)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (f *fakeConnection) SetXX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	if _, ok := f.values[key]; !ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	f.Set(key, value, expiration)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (f *fakeConnection) Get(key string) *redis.StringCmd {
+	value, ok := f.values[key]
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(value, nil)
+}
+
+func (f *fakeConnection) MGet(keys ...string) *redis.SliceCmd {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if value, ok := f.values[key]; ok {
+			values[i] = value
+		}
+	}
+	return redis.NewSliceResult(values, nil)
+}
+
+func (f *fakeConnection) Del(keys ...string) *redis.IntCmd {
+	var deleted int64
+	for _, key := range keys {
+		delete(f.ttls, key)
+		if _, ok := f.values[key]; ok {
+			delete(f.values, key)
+			deleted++
+		}
+		if _, ok := f.sets[key]; ok {
+			delete(f.sets, key)
+			deleted++
+		}
+		if _, ok := f.hashes[key]; ok {
+			delete(f.hashes, key)
+			deleted++
+		}
+	}
+	return redis.NewIntResult(deleted, nil)
+}
+
+// Eval only supports the compare-and-set, compare-and-delete and index scripts,
+// which it runs natively
+func (f *fakeConnection) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	switch script {
+	case compareAndSetScript:
+		if f.values[keys[0]] != args[0].(string) {
+			return redis.NewCmdResult(int64(0), nil)
+		}
+		f.Set(keys[0], args[1], time.Duration(args[2].(int64))*time.Millisecond)
+		return redis.NewCmdResult(int64(1), nil)
+	case compareAndDeleteScript:
+		if value, ok := f.values[keys[0]]; !ok || value != args[0].(string) {
+			return redis.NewCmdResult(int64(0), nil)
+		}
+		return redis.NewCmdResult(f.Del(keys[0]).Val(), nil)
+	case indexAddScript:
+		_, existed := f.sets[keys[0]]
+		f.SAdd(keys[0], args[1:]...)
+
+		ttl := time.Duration(args[0].(int64)) * time.Millisecond
+		current, expiring := f.ttls[keys[0]]
+		if ttl <= 0 {
+			delete(f.ttls, keys[0])
+		} else if !existed || (expiring && current < ttl) {
+			f.ttls[keys[0]] = ttl
+		}
+		return redis.NewCmdResult(int64(1), nil)
+	case takeScript:
+		value, ok := f.values[keys[0]]
+		if !ok {
+			return redis.NewCmdResult(nil, redis.Nil)
+		}
+		ttl := int64(f.PTTL(keys[0]).Val() / time.Millisecond)
+		f.Del(keys[0])
+		return redis.NewCmdResult([]interface{}{value, ttl}, nil)
+	}
+	return redis.NewCmdResult(nil, errors.New("unsupported script"))
+}
+
+func (f *fakeConnection) Exists(key string) *redis.BoolCmd {
+	_, isValue := f.values[key]
+	_, isSet := f.sets[key]
+	_, isHash := f.hashes[key]
+	return redis.NewBoolResult(isValue || isSet || isHash, nil)
+}
+
+func (f *fakeConnection) Close() error {
+	return nil
+}
+
+func (f *fakeConnection) Expire(key string, expiration time.Duration) *redis.BoolCmd {
+	ok := f.Exists(key).Val()
+	if ok {
+		f.ttls[key] = expiration
+	}
+	return redis.NewBoolResult(ok, nil)
+}
+
+// PTTL returns the last expiration set on key, or, as Redis does, -1ms if it
+// has none and -2ms if it doesn't exist
+func (f *fakeConnection) PTTL(key string) *redis.DurationCmd {
+	if !f.Exists(key).Val() {
+		return redis.NewDurationResult(-2*time.Millisecond, nil)
+	}
+	if ttl, ok := f.ttls[key]; ok {
+		return redis.NewDurationResult(ttl, nil)
+	}
+	return redis.NewDurationResult(-time.Millisecond, nil)
+}
+
+func (f *fakeConnection) Scan(cursor uint64, match string, count int64) *redis.ScanCmd {
+	end := int(cursor) + 2
+	next := uint64(end)
+	if end >= len(f.order) {
+		end, next = len(f.order), 0
+	}
+
+	var page []string
+	for _, key := range f.order[cursor:end] {
+		_, isValue := f.values[key]
+		_, isSet := f.sets[key]
+		if globMatch(match, key) && (isValue || isSet) {
+			page = append(page, key)
+		}
+	}
+	return redis.NewScanCmdResult(page, next, nil)
+}
+
+func (f *fakeConnection) SAdd(key string, members ...interface{}) *redis.IntCmd {
+	if f.sets[key] == nil {
+		f.order = append(f.order, key)
+		f.sets[key] = map[string]bool{}
+	}
+	for _, member := range members {
+		f.sets[key][member.(string)] = true
+	}
+	return redis.NewIntResult(int64(len(members)), nil)
+}
+
+func (f *fakeConnection) SRem(key string, members ...interface{}) *redis.IntCmd {
+	for _, member := range members {
+		delete(f.sets[key], member.(string))
+	}
+	return redis.NewIntResult(int64(len(members)), nil)
+}
+
+func (f *fakeConnection) SMembers(key string) *redis.StringSliceCmd {
+	var members []string
+	for member := range f.sets[key] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return redis.NewStringSliceResult(members, nil)
+}
+
+func (f *fakeConnection) HMSet(key string, fields map[string]string) *redis.StatusCmd {
+	if f.hashes[key] == nil {
+		f.hashes[key] = map[string]string{}
+	}
+	for field, value := range fields {
+		f.hashes[key][field] = value
+	}
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (f *fakeConnection) HGetAll(key string) *redis.StringStringMapCmd {
+	fields := map[string]string{}
+	for field, value := range f.hashes[key] {
+		fields[field] = value
+	}
+	return redis.NewStringStringMapResult(fields, nil)
+}
+
+func (f *fakeConnection) HDel(key string, fields ...string) *redis.IntCmd {
+	var removed int64
+	for _, field := range fields {
+		if _, ok := f.hashes[key][field]; ok {
+			delete(f.hashes[key], field)
+			removed++
+		}
+	}
+	if len(f.hashes[key]) == 0 {
+		delete(f.hashes, key)
+	}
+	return redis.NewIntResult(removed, nil)
+}
+
+// ------------------- Routes Through DeleteByPattern() -------------------
+
+// TestUnitDeleteByPattern - Verify that every key matching the pattern is deleted,
+// across multiple SCAN iterations, and no others
+func TestUnitDeleteByPattern(t *testing.T) {
+
+	Convey("Given the cache holds several keys matching a pattern", t, func() {
+
+		connection := newFakeConnection()
+		for _, key := range []string{"remember:a", "remember:b", "remember:c", "session1", "session2"} {
+			connection.Set(key, "value", 0)
+		}
+
+		cache := &Cache{connection: connection}
+
+		Convey("When I delete by the pattern", func() {
+
+			deleted, err := cache.DeleteByPattern("remember:*")
+
+			Convey("Then only the matching keys should be deleted", func() {
+
+				So(err, ShouldBeNil)
+				So(deleted, ShouldEqual, 3)
+				So(len(connection.values), ShouldEqual, 2)
+				So(connection.values, ShouldContainKey, "session1")
+				So(connection.values, ShouldContainKey, "session2")
+			})
+		})
+	})
+}
+
+// pingCountingConnection is a fakeConnection which counts PINGs, which may be
+// sent concurrently.
+type pingCountingConnection struct {
+	*fakeConnection
+	pings int64
+}
+
+func (c *pingCountingConnection) Ping() *redis.StatusCmd {
+	atomic.AddInt64(&c.pings, 1)
+	return c.fakeConnection.Ping()
+}
+
+// ------------------- Routes Through NewCache() -------------------
+
+// TestUnitNewCacheTimeouts - Verify that the Redis client is given the configured
+// read and write timeouts, or the default if they aren't configured
+func TestUnitNewCacheTimeouts(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given the Redis client options are captured", t, func() {
+
+		var options *redis.Options
+		defer func(original func(*redis.Options) Connection) { newRedisConnection = original }(newRedisConnection)
+		newRedisConnection = func(o *redis.Options) Connection {
+			options = o
+			return newFakeConnection()
+		}
+
+		Convey("When I create a cache without configuring timeouts", func() {
+
+			NewCache("localhost:6379", 0, "")
+
+			Convey("Then the default timeouts should be used", func() {
+
+				So(options.ReadTimeout, ShouldEqual, defaultCommandTimeout)
+				So(options.WriteTimeout, ShouldEqual, defaultCommandTimeout)
+			})
+		})
+
+		Convey("When I create a cache having configured timeouts", func() {
+
+			cfg := config.Get()
+			cfg.CacheReadTimeout, cfg.CacheWriteTimeout = 500, 250
+			defer func() { cfg.CacheReadTimeout, cfg.CacheWriteTimeout = 0, 0 }()
+
+			NewCache("localhost:6379", 0, "")
+
+			Convey("Then the configured timeouts should be used", func() {
+
+				So(options.ReadTimeout, ShouldEqual, 500*time.Millisecond)
+				So(options.WriteTimeout, ShouldEqual, 250*time.Millisecond)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through WithWarmUp() -------------------
+
+// TestUnitWithWarmUp - Verify that the configured number of connections are
+// warmed up, bounded by the maximum
+func TestUnitWithWarmUp(t *testing.T) {
+
+	Convey("Given I have a cache", t, func() {
+
+		connection := &pingCountingConnection{fakeConnection: newFakeConnection()}
+		cache := NewCacheWithConnection(connection)
+
+		Convey("When I warm up 3 connections", func() {
+
+			cache.WithWarmUp(3)
+
+			Convey("Then 3 pings should be sent", func() {
+
+				So(atomic.LoadInt64(&connection.pings), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When I warm up more connections than the maximum", func() {
+
+			cache.WithWarmUp(1000)
+
+			Convey("Then only the maximum number of pings should be sent", func() {
+
+				So(atomic.LoadInt64(&connection.pings), ShouldEqual, maxWarmUpConnections)
+			})
+		})
+
+		Convey("When I don't warm up any connections", func() {
+
+			cache.WithWarmUp(0)
+
+			Convey("Then no pings should be sent", func() {
+
+				So(atomic.LoadInt64(&connection.pings), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+// ------------------- Routes Through Close() -------------------
+
+// TestUnitCloseClosesConnection - Verify that closing a Cache, or a Store using
+// it, closes its underlying connection
+func TestUnitCloseClosesConnection(t *testing.T) {
+
+	Convey("Given I have a cache", t, func() {
+
+		connection := &mockState.Connection{}
+		cache := NewCacheWithConnection(connection)
+
+		Convey("When I close it", func() {
+
+			connection.On("Close").Return(nil)
+
+			err := cache.Close()
+
+			Convey("Then the connection should be closed", func() {
+
+				So(err, ShouldBeNil)
+				connection.AssertCalled(t, "Close")
+			})
+		})
+
+		Convey("When I close a Store using it", func() {
+
+			connection.On("Close").Return(nil)
+
+			err := NewStore(cache).Close()
+
+			Convey("Then the connection should be closed", func() {
+
+				So(err, ShouldBeNil)
+				connection.AssertCalled(t, "Close")
+			})
+		})
+
+		Convey("When I close it but the connection fails to close", func() {
+
+			connection.On("Close").Return(errors.New("Error closing connection"))
+
+			err := cache.Close()
+
+			Convey("Then the error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Error closing connection")
+			})
+		})
+	})
+}
@@ -0,0 +1,28 @@
+package state
+
+import (
+	"time"
+
+	session "github.com/companieshouse/go-session-handler/session"
+)
+
+//Clock tells the Store the time its expirations, idle timeouts and access
+//times are computed from.
+type Clock = session.Clock
+
+//WithClock sets the Clock the Store reads the time from, replacing the real
+//time, so that tests can freeze or advance it to cross an expiry exactly.
+//Latencies reported to Observers and Metrics are always measured in real time.
+func (s *Store) WithClock(clock Clock) *Store {
+	s.clock = clock
+	return s
+}
+
+//now returns the current time from the Store's Clock, falling back to the real
+//time if none has been set.
+func (s *Store) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
@@ -0,0 +1,150 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeClock is a Clock frozen at now until it is advanced
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// ------------------- Routes Through WithClock() -------------------
+
+// TestUnitClockExpiration - Verify that a session expires exactly when its
+// expiry, or its idle timeout, is reached on the Store's Clock
+func TestUnitClockExpiration(t *testing.T) {
+
+	initConfig()
+
+	cfg := config.Get()
+	defaultExpiration := cfg.DefaultExpiration
+	cfg.DefaultExpiration = "600"
+	defer func() { cfg.DefaultExpiration, cfg.IdleTimeout = defaultExpiration, 0 }()
+
+	Convey("Given a session stored, and last accessed, at a fixed time, expiring after 600 seconds", t, func() {
+
+		clock := &fakeClock{now: time.Unix(1700000000, 0)}
+		cache := NewMemoryCache()
+
+		stored := NewStore(cache).WithClock(clock)
+		stored.Data = map[string]interface{}{"page": "home", "last_access": clock.now.Unix()}
+		stored.SetExpiration(600)
+		So(stored.Store(), ShouldBeNil)
+		So(stored.Expires, ShouldEqual, uint64(1700000600))
+
+		load := func() LoadOutcome {
+			outcome, err := NewStore(cache).WithClock(clock).LoadResult(stored.CookieValue())
+			So(err, ShouldBeNil)
+			return outcome
+		}
+
+		Convey("When the clock reaches one second before its expiry", func() {
+
+			clock.Advance(599 * time.Second)
+
+			Convey("Then it should still load", func() {
+
+				So(load(), ShouldEqual, LoadLoaded)
+			})
+		})
+
+		Convey("When the clock reaches its expiry exactly", func() {
+
+			clock.Advance(600 * time.Second)
+
+			Convey("Then it should have expired", func() {
+
+				So(load(), ShouldEqual, LoadExpired)
+			})
+		})
+
+		Convey("When an idle timeout of 300 seconds is configured", func() {
+
+			cfg.IdleTimeout = 300
+
+			Convey("Then it should load after exactly 300 idle seconds", func() {
+
+				clock.Advance(300 * time.Second)
+
+				So(load(), ShouldEqual, LoadLoaded)
+			})
+
+			Convey("Then it should have expired after 301 idle seconds", func() {
+
+				clock.Advance(301 * time.Second)
+
+				So(load(), ShouldEqual, LoadExpired)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitDefaultExpiration - Verify that a session stored without an explicit
+// expiry is stored with the default one, and expires once it has passed
+func TestUnitDefaultExpiration(t *testing.T) {
+
+	initConfig()
+
+	cfg := config.Get()
+	defaultExpiration := cfg.DefaultExpiration
+	cfg.DefaultExpiration = "60"
+	defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+	Convey("Given a session stored at a fixed time with the default expiration of 60 seconds", t, func() {
+
+		clock := &fakeClock{now: time.Unix(1700000000, 0)}
+		cache := NewMemoryCache()
+
+		stored := NewStore(cache).WithClock(clock)
+		stored.Data = map[string]interface{}{"page": "home"}
+		So(stored.Store(), ShouldBeNil)
+
+		load := func() LoadOutcome {
+			outcome, err := NewStore(cache).WithClock(clock).LoadResult(stored.CookieValue())
+			So(err, ShouldBeNil)
+			return outcome
+		}
+
+		Convey("Then its expiry should be stored with it", func() {
+
+			So(stored.Data["expires"], ShouldEqual, int64(1700000060))
+		})
+
+		Convey("When the clock reaches one second before its expiry", func() {
+
+			clock.Advance(59 * time.Second)
+
+			Convey("Then it should still load", func() {
+
+				So(load(), ShouldEqual, LoadLoaded)
+			})
+		})
+
+		Convey("When the clock moves on 10 hours", func() {
+
+			clock.Advance(10 * time.Hour)
+
+			Convey("Then it should have expired", func() {
+
+				So(load(), ShouldEqual, LoadExpired)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,37 @@
+package state
+
+//CountSessions returns roughly how many sessions are stored, e.g. to report as
+//a metric, by counting the keys matching matchPattern, a glob-style pattern
+//where "" matches every key, which hold sessions rather than e.g. remember
+//tokens or user indexes. Keys are found using SCAN with a COUNT hint rather than
+//KEYS, so that Redis isn't blocked, at the cost of accuracy: SCAN may return a
+//key more than once, or miss one added or removed while it runs, and sessions
+//which have expired but not yet been evicted are still counted. The result is
+//only an approximation.
+func (s *Store) CountSessions(matchPattern string) (int64, error) {
+
+	if matchPattern == "" {
+		matchPattern = "*"
+	}
+
+	var cursor uint64
+	var count int64
+
+	for {
+		keys, next, err := s.cache.getConnection().Scan(cursor, matchPattern, scanCount).Result()
+		if err != nil {
+			return count, err
+		}
+
+		for _, key := range keys {
+			if s.isSessionKey(key) {
+				count++
+			}
+		}
+
+		if next == 0 {
+			return count, nil
+		}
+		cursor = next
+	}
+}
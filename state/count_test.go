@@ -0,0 +1,74 @@
+package state
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// ------------------- Routes Through CountSessions() -------------------
+
+// TestUnitCountSessions - Verify that only the keys holding sessions, across
+// every page of the scan, are counted
+func TestUnitCountSessions(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given the cache holds sessions alongside other keys", t, func() {
+
+		connection := newFakeConnection()
+		for _, c := range "abc" {
+			connection.Set(strings.Repeat(string(c), signatureStart()), "session", 0)
+		}
+		connection.Set(rememberKeyPrefix+"token", "remembered", 0)
+		connection.Set(tombstoneKeyPrefix+strings.Repeat("d", signatureStart()), "1", 0)
+		connection.SAdd(userSessionsKey("user-1"), strings.Repeat("a", signatureStart()))
+
+		s := NewStore(NewCacheWithConnection(connection))
+
+		Convey("When I count the sessions", func() {
+
+			count, err := s.CountSessions("")
+
+			Convey("Then only the sessions should be counted", func() {
+
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When I count the sessions matching a pattern", func() {
+
+			count, err := s.CountSessions("a*")
+
+			Convey("Then only the matching sessions should be counted", func() {
+
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given scanning the cache fails", t, func() {
+
+		scanErr := errors.New("Error scanning")
+		connection := &mockState.Connection{}
+		connection.On("Scan", uint64(0), "*", int64(scanCount)).Return(redis.NewScanCmdResult(nil, 0, scanErr))
+
+		s := NewStore(&Cache{connection: connection})
+
+		Convey("Then the error should be returned", func() {
+
+			_, err := s.CountSessions("")
+
+			So(err, ShouldEqual, scanErr)
+		})
+	})
+
+	cleanupConfig()
+}
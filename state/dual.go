@@ -0,0 +1,367 @@
+package state
+
+import (
+	"time"
+
+	"github.com/companieshouse/go-session-handler/session"
+	redis "gopkg.in/redis.v5"
+)
+
+//secondaryScan is set on the cursors returned by a dualConnection's Scan once
+//it has finished scanning the primary and moved on to the secondary.
+const secondaryScan = uint64(1) << 63
+
+//NewDualCache returns a Cache for migrating sessions between two Redis
+//instances with no downtime. Writes and deletes go to both. Reads prefer the
+//primary, falling back to the secondary, and a value found only in the
+//secondary is backfilled into the primary. Backfilled values expire from the
+//primary when they would have from the secondary. The Cache stores sessions as
+//hashes if the primary does, and doesn't support transactions. Closing it
+//closes both Caches.
+func NewDualCache(primary, secondary *Cache) *Cache {
+	return &Cache{
+		connection: &dualConnection{primary: primary, secondary: secondary},
+		hashes:     primary.hashes,
+	}
+}
+
+//dualConnection is the Connection of a dual Cache. It goes through each Cache's
+//current connection, so that either may reconnect.
+type dualConnection struct {
+	primary   *Cache
+	secondary *Cache
+}
+
+func (d *dualConnection) Ping() *redis.StatusCmd {
+	if cmd := d.primary.getConnection().Ping(); cmd.Err() != nil {
+		return cmd
+	}
+	return d.secondary.getConnection().Ping()
+}
+
+func (d *dualConnection) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	cmd := d.primary.getConnection().Set(key, value, expiration)
+	if cmd.Err() != nil {
+		return cmd
+	}
+	if secondary := d.secondary.getConnection().Set(key, value, expiration); secondary.Err() != nil {
+		return secondary
+	}
+	return cmd
+}
+
+//SetNX sets the value in both if the key is in neither. Unlike in Redis, the
+//check and set aren't atomic.
+func (d *dualConnection) SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	exists := d.Exists(key)
+	if exists.Err() != nil || exists.Val() {
+		return redis.NewBoolResult(false, exists.Err())
+	}
+	return d.setBoth(key, value, expiration)
+}
+
+//SetXX sets the value in both if the key is in either. Unlike in Redis, the
+//check and set aren't atomic.
+func (d *dualConnection) SetXX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	exists := d.Exists(key)
+	if exists.Err() != nil || !exists.Val() {
+		return redis.NewBoolResult(false, exists.Err())
+	}
+	return d.setBoth(key, value, expiration)
+}
+
+//setBoth sets the value in both, as the result of a conditional set which
+//applied.
+func (d *dualConnection) setBoth(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	if err := d.Set(key, value, expiration).Err(); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	return redis.NewBoolResult(true, nil)
+}
+
+func (d *dualConnection) Get(key string) *redis.StringCmd {
+	cmd := d.primary.getConnection().Get(key)
+	if cmd.Err() != redis.Nil {
+		return cmd
+	}
+
+	cmd = d.secondary.getConnection().Get(key)
+	if cmd.Err() == nil {
+		d.backfill(key, cmd.Val())
+	}
+	return cmd
+}
+
+func (d *dualConnection) MGet(keys ...string) *redis.SliceCmd {
+	cmd := d.primary.getConnection().MGet(keys...)
+	values, err := cmd.Result()
+	if err != nil {
+		return cmd
+	}
+
+	var missing []string
+	var indexes []int
+	for i, value := range values {
+		if value == nil && i < len(keys) {
+			missing = append(missing, keys[i])
+			indexes = append(indexes, i)
+		}
+	}
+	if len(missing) == 0 {
+		return cmd
+	}
+
+	fallback, err := d.secondary.getConnection().MGet(missing...).Result()
+	if err != nil {
+		return redis.NewSliceResult(nil, err)
+	}
+
+	for i, value := range fallback {
+		if stored, ok := value.(string); ok && i < len(indexes) {
+			values[indexes[i]] = stored
+			d.backfill(missing[i], stored)
+		}
+	}
+	return redis.NewSliceResult(values, nil)
+}
+
+//backfill copies a value found only in the secondary into the primary, with
+//the time it has left there. Failing to do so doesn't fail the read, as the
+//value will be read from the secondary again next time.
+func (d *dualConnection) backfill(key string, value string) {
+	if ttl, ok := d.secondaryTTL(key); ok {
+		d.primary.getConnection().Set(key, value, ttl)
+	}
+}
+
+//secondaryTTL returns the time left before key expires in the secondary, or 0
+//if it never will. False is returned if the key has gone from the secondary
+//since it was read, or its TTL can't be read, in which case it shouldn't be
+//backfilled.
+func (d *dualConnection) secondaryTTL(key string) (time.Duration, bool) {
+	ttl, err := d.secondary.getConnection().PTTL(key).Result()
+	if err != nil || ttl == -2*time.Millisecond {
+		return 0, false
+	}
+	if ttl < 0 {
+		return 0, true
+	}
+	return ttl, true
+}
+
+//Del deletes the keys from both, returning the larger number deleted from
+//either, so that keys only in the secondary are counted.
+func (d *dualConnection) Del(keys ...string) *redis.IntCmd {
+	cmd := d.primary.getConnection().Del(keys...)
+	if cmd.Err() != nil {
+		return cmd
+	}
+
+	secondary := d.secondary.getConnection().Del(keys...)
+	if secondary.Err() != nil || secondary.Val() > cmd.Val() {
+		return secondary
+	}
+	return cmd
+}
+
+func (d *dualConnection) Exists(key string) *redis.BoolCmd {
+	cmd := d.primary.getConnection().Exists(key)
+	if cmd.Err() != nil || cmd.Val() {
+		return cmd
+	}
+	return d.secondary.getConnection().Exists(key)
+}
+
+func (d *dualConnection) Expire(key string, expiration time.Duration) *redis.BoolCmd {
+	cmd := d.primary.getConnection().Expire(key, expiration)
+	if cmd.Err() != nil {
+		return cmd
+	}
+
+	secondary := d.secondary.getConnection().Expire(key, expiration)
+	if secondary.Err() != nil {
+		return secondary
+	}
+	return redis.NewBoolResult(cmd.Val() || secondary.Val(), nil)
+}
+
+//PTTL returns the time left before key expires in the primary or, if it isn't
+//there, the secondary.
+func (d *dualConnection) PTTL(key string) *redis.DurationCmd {
+	cmd := d.primary.getConnection().PTTL(key)
+	if cmd.Err() != nil || cmd.Val() != -2*time.Millisecond {
+		return cmd
+	}
+	return d.secondary.getConnection().PTTL(key)
+}
+
+//Scan scans the primary and then the secondary, so keys in both are returned
+//twice. The secondary's cursors are marked with secondaryScan.
+func (d *dualConnection) Scan(cursor uint64, match string, count int64) *redis.ScanCmd {
+	if cursor&secondaryScan == 0 {
+		keys, next, err := d.primary.getConnection().Scan(cursor, match, count).Result()
+		if err != nil {
+			return redis.NewScanCmdResult(nil, 0, err)
+		}
+		if next == 0 {
+			next = secondaryScan
+		}
+		return redis.NewScanCmdResult(keys, next, nil)
+	}
+
+	keys, next, err := d.secondary.getConnection().Scan(cursor&^secondaryScan, match, count).Result()
+	if err != nil {
+		return redis.NewScanCmdResult(nil, 0, err)
+	}
+	if next != 0 {
+		next |= secondaryScan
+	}
+	return redis.NewScanCmdResult(keys, next, nil)
+}
+
+func (d *dualConnection) SAdd(key string, members ...interface{}) *redis.IntCmd {
+	cmd := d.primary.getConnection().SAdd(key, members...)
+	if cmd.Err() != nil {
+		return cmd
+	}
+	if secondary := d.secondary.getConnection().SAdd(key, members...); secondary.Err() != nil {
+		return secondary
+	}
+	return cmd
+}
+
+func (d *dualConnection) SRem(key string, members ...interface{}) *redis.IntCmd {
+	cmd := d.primary.getConnection().SRem(key, members...)
+	if cmd.Err() != nil {
+		return cmd
+	}
+	if secondary := d.secondary.getConnection().SRem(key, members...); secondary.Err() != nil {
+		return secondary
+	}
+	return cmd
+}
+
+//SMembers returns the members of the set in either.
+func (d *dualConnection) SMembers(key string) *redis.StringSliceCmd {
+	primary, err := d.primary.getConnection().SMembers(key).Result()
+	if err != nil {
+		return redis.NewStringSliceResult(nil, err)
+	}
+	secondary, err := d.secondary.getConnection().SMembers(key).Result()
+	if err != nil {
+		return redis.NewStringSliceResult(nil, err)
+	}
+
+	members := primary
+	seen := map[string]bool{}
+	for _, member := range primary {
+		seen[member] = true
+	}
+	for _, member := range secondary {
+		if !seen[member] {
+			members = append(members, member)
+		}
+	}
+	return redis.NewStringSliceResult(members, nil)
+}
+
+func (d *dualConnection) HMSet(key string, fields map[string]string) *redis.StatusCmd {
+	cmd := d.primary.getConnection().HMSet(key, fields)
+	if cmd.Err() != nil {
+		return cmd
+	}
+	if secondary := d.secondary.getConnection().HMSet(key, fields); secondary.Err() != nil {
+		return secondary
+	}
+	return cmd
+}
+
+func (d *dualConnection) HGetAll(key string) *redis.StringStringMapCmd {
+	cmd := d.primary.getConnection().HGetAll(key)
+	if cmd.Err() != nil || len(cmd.Val()) > 0 {
+		return cmd
+	}
+
+	cmd = d.secondary.getConnection().HGetAll(key)
+	if cmd.Err() != nil || len(cmd.Val()) == 0 {
+		return cmd
+	}
+
+	// Backfill the hash, as backfill does a value
+	if ttl, ok := d.secondaryTTL(key); ok {
+		if d.primary.getConnection().HMSet(key, cmd.Val()).Err() == nil && ttl > 0 {
+			d.primary.getConnection().Expire(key, ttl)
+		}
+	}
+	return cmd
+}
+
+func (d *dualConnection) HDel(key string, fields ...string) *redis.IntCmd {
+	cmd := d.primary.getConnection().HDel(key, fields...)
+	if cmd.Err() != nil {
+		return cmd
+	}
+	if secondary := d.secondary.getConnection().HDel(key, fields...); secondary.Err() != nil {
+		return secondary
+	}
+	return cmd
+}
+
+//Eval runs the script against the primary. The compare-and-set,
+//compare-and-delete and index scripts are then mirrored to the secondary if
+//they applied, so that it isn't left stale. A value set is mirrored with the
+//script's TTL. The take script is run against both, as a value taken from one
+//mustn't be left to be taken again from the other.
+func (d *dualConnection) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	if script == takeScript {
+		return d.take(keys)
+	}
+
+	cmd := d.primary.getConnection().Eval(script, keys, args...)
+	if applied, _ := cmd.Val().(int64); cmd.Err() != nil || applied != 1 {
+		return cmd
+	}
+
+	var err error
+	switch script {
+	case compareAndSetScript:
+		ttl, _ := session.ToInt64(args[2])
+		err = d.secondary.getConnection().Set(keys[0], args[1], time.Duration(ttl)*time.Millisecond).Err()
+	case compareAndDeleteScript:
+		err = d.secondary.getConnection().Del(keys[0]).Err()
+	case indexAddScript:
+		err = d.secondary.getConnection().Eval(script, keys, args...).Err()
+	}
+	if err != nil {
+		return redis.NewCmdResult(nil, err)
+	}
+	return cmd
+}
+
+//take runs the take script against the primary and then the secondary,
+//returning the value taken from the primary or, if it wasn't there, the
+//secondary.
+func (d *dualConnection) take(keys []string) *redis.Cmd {
+	cmd := d.primary.getConnection().Eval(takeScript, keys)
+	if cmd.Err() != nil && cmd.Err() != redis.Nil {
+		return cmd
+	}
+
+	secondary := d.secondary.getConnection().Eval(takeScript, keys)
+	if secondary.Err() != nil && secondary.Err() != redis.Nil {
+		return secondary
+	}
+	if cmd.Err() == redis.Nil {
+		return secondary
+	}
+	return cmd
+}
+
+//Close closes both Caches, returning the first error.
+func (d *dualConnection) Close() error {
+	err := d.primary.Close()
+	if secondaryErr := d.secondary.Close(); err == nil {
+		err = secondaryErr
+	}
+	return err
+}
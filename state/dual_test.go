@@ -0,0 +1,136 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ------------------- Routes Through NewDualCache() -------------------
+
+// TestUnitDualCache - Verify that a dual cache writes and deletes in both caches,
+// and reads from the primary, falling back to and backfilling from the secondary
+func TestUnitDualCache(t *testing.T) {
+
+	initConfig()
+
+	cfg := config.Get()
+	defaultExpiration := cfg.DefaultExpiration
+	cfg.DefaultExpiration = "600"
+	defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+	Convey("Given I have a dual cache over a new primary and an old secondary", t, func() {
+
+		primary, secondary := NewMemoryCache(), NewMemoryCache()
+		dual := NewDualCache(primary, secondary)
+
+		data := map[string]interface{}{"page": "search", "expires": time.Now().Unix() + 600}
+
+		Convey("When a session is only in the secondary", func() {
+
+			old := NewStore(secondary)
+			old.Data = data
+			So(old.Store(), ShouldBeNil)
+
+			s := NewStore(dual)
+			err := s.Load(old.CookieValue())
+
+			Convey("Then it should be read from the secondary and backfilled into the primary", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data["page"], ShouldEqual, "search")
+
+				backfilled, err := primary.getSessionData(old.ID)
+				So(err, ShouldBeNil)
+				So(backfilled, ShouldNotBeBlank)
+			})
+
+			Convey("Then it should expire from the primary when it would have from the secondary", func() {
+
+				ttl, err := primary.getConnection().PTTL(old.ID).Result()
+				So(err, ShouldBeNil)
+				So(ttl, ShouldBeBetweenOrEqual, 590*time.Second, 600*time.Second)
+			})
+
+			Convey("And it is fetched with others in a single round trip", func() {
+
+				missing := strings.Repeat("z", signatureStart())
+				stored, err := dual.getManySessionData(old.ID, missing)
+
+				Convey("Then it should also fall back to the secondary", func() {
+
+					So(err, ShouldBeNil)
+					So(stored, ShouldContainKey, old.ID)
+					So(stored, ShouldNotContainKey, missing)
+				})
+			})
+		})
+
+		Convey("When a session only in the secondary is stored with optimistic locking", func() {
+
+			old := NewStore(secondary)
+			old.Data = data
+			So(old.Store(), ShouldBeNil)
+
+			s := NewStore(dual).WithOptimisticLocking()
+			So(s.Load(old.CookieValue()), ShouldBeNil)
+			s.Data["page"] = "results"
+			So(s.Store(), ShouldBeNil)
+
+			Convey("Then it should keep expiring from both caches", func() {
+
+				inPrimary, _ := primary.getConnection().PTTL(s.ID).Result()
+				inSecondary, _ := secondary.getConnection().PTTL(s.ID).Result()
+				So(inPrimary, ShouldBeBetweenOrEqual, 590*time.Second, 600*time.Second)
+				So(inSecondary, ShouldBeBetweenOrEqual, 590*time.Second, 600*time.Second)
+			})
+		})
+
+		Convey("When a session is stored through the dual cache", func() {
+
+			s := NewStore(dual)
+			s.Data = data
+			So(s.Store(), ShouldBeNil)
+
+			Convey("Then it should be written to both caches", func() {
+
+				inPrimary, _ := primary.sessionDataExists(s.ID)
+				inSecondary, _ := secondary.sessionDataExists(s.ID)
+				So(inPrimary, ShouldBeTrue)
+				So(inSecondary, ShouldBeTrue)
+			})
+
+			Convey("And then deleted", func() {
+
+				So(s.Delete(nil), ShouldBeNil)
+
+				Convey("Then it should be removed from both caches", func() {
+
+					inPrimary, _ := primary.sessionDataExists(s.ID)
+					inSecondary, _ := secondary.sessionDataExists(s.ID)
+					So(inPrimary, ShouldBeFalse)
+					So(inSecondary, ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("When keys are in each cache", func() {
+
+			primary.setSessionData("remember:a", "a", 0)
+			secondary.setSessionData("remember:b", "b", 0)
+
+			deleted, err := dual.DeleteByPattern("remember:*")
+
+			Convey("Then scanning should cover both caches", func() {
+
+				So(err, ShouldBeNil)
+				So(deleted, ShouldEqual, 2)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,49 @@
+package state
+
+import "errors"
+
+//ErrSessionExpired is returned when a session has expired, including when it
+//has been idle for too long or renewed too many times.
+var ErrSessionExpired = errors.New("Store has expired")
+
+//ErrInvalidSignature is returned when a cookie value's signature doesn't match
+//its session ID, e.g. because either has been manipulated.
+var ErrInvalidSignature = errors.New("Session signature does not match the expected value")
+
+//ErrCookieTooShort is returned when a cookie value is shorter than a session ID
+//plus its signature.
+var ErrCookieTooShort = errors.New("Cookie signature is less than the desired cookie length")
+
+//ErrCookieTooLong is returned when a cookie value is longer than a session ID
+//plus its signature, e.g. because it has been padded.
+var ErrCookieTooLong = errors.New("Cookie signature is greater than the desired cookie length")
+
+//ErrNoSessionData is returned when an operation needs a loaded session but the
+//Store has none.
+var ErrNoSessionData = errors.New("No session has been loaded")
+
+//ErrSessionTooLarge is returned when storing a session whose encoded data is
+//larger than the configured maximum, rather than storing it.
+var ErrSessionTooLarge = errors.New("Session is too large to store")
+
+//sessionError gives more detail about one of the package's sentinel errors,
+//while still matching it with errors.Is, which uses Unwrap, on Go 1.13 and
+//later. Its message replaces the sentinel's.
+type sessionError struct {
+	err     error
+	message string
+}
+
+//wrapError returns an error with the given message which unwraps to err.
+func wrapError(err error, message string) error {
+	return &sessionError{err: err, message: message}
+}
+
+func (e *sessionError) Error() string {
+	return e.message
+}
+
+//Unwrap returns the sentinel error.
+func (e *sessionError) Unwrap() error {
+	return e.err
+}
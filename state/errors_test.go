@@ -0,0 +1,171 @@
+package state
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// unwrap returns the sentinel error which err wraps, as errors.Is would find it,
+// or err itself if it doesn't wrap one
+func unwrap(err error) error {
+	if wrapped, ok := err.(interface{ Unwrap() error }); ok {
+		return wrapped.Unwrap()
+	}
+	return err
+}
+
+// ------------------- Routes Through sessionError -------------------
+
+// TestUnitSentinelErrors - Verify that each failure path returns, or wraps, its
+// exported sentinel error
+func TestUnitSentinelErrors(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a Store", t, func() {
+
+		s := NewStore(nil)
+		id := strings.Repeat("a", signatureStart())
+
+		Convey("When I validate a cookie which is too short", func() {
+
+			_, err := s.ValidateCookieOnly(strings.Repeat("a", cookieValueLength()-1))
+
+			Convey("Then ErrCookieTooShort should be returned", func() {
+
+				So(unwrap(err), ShouldEqual, ErrCookieTooShort)
+			})
+		})
+
+		Convey("When I validate a cookie which is too long", func() {
+
+			_, err := s.ValidateCookieOnly(id + generateSignature(id) + "=")
+
+			Convey("Then ErrCookieTooLong should be returned", func() {
+
+				So(unwrap(err), ShouldEqual, ErrCookieTooLong)
+			})
+		})
+
+		Convey("When I validate a cookie with a tampered signature", func() {
+
+			_, err := s.ValidateCookieOnly(id + strings.Repeat("x", signatureLength()))
+
+			Convey("Then ErrInvalidSignature should be wrapped, without giving away the expected signature", func() {
+
+				So(unwrap(err), ShouldEqual, ErrInvalidSignature)
+				So(err.Error(), ShouldEqual, "Session signature does not match the expected value! Have "+strings.Repeat("x", signatureLength()))
+				So(err.Error(), ShouldNotContainSubstring, generateSignature(id))
+			})
+		})
+
+		Convey("When I touch it without having loaded a session", func() {
+
+			err := s.Touch()
+
+			Convey("Then ErrNoSessionData should be wrapped", func() {
+
+				So(unwrap(err), ShouldEqual, ErrNoSessionData)
+				So(err.Error(), ShouldEqual, "No session has been loaded to touch")
+			})
+		})
+
+		Convey("When I reload it without having loaded a session", func() {
+
+			err := s.Reload()
+
+			Convey("Then ErrNoSessionData should be wrapped", func() {
+
+				So(unwrap(err), ShouldEqual, ErrNoSessionData)
+			})
+		})
+
+		Convey("When I validate an expired session", func() {
+
+			s.Data = map[string]interface{}{"expires": uint32(time.Now().Unix() - 10)}
+
+			err := s.validateExpiration()
+
+			Convey("Then ErrSessionExpired should be returned", func() {
+
+				So(unwrap(err), ShouldEqual, ErrSessionExpired)
+			})
+		})
+
+		Convey("When I validate a session left idle for too long", func() {
+
+			cfg := config.Get()
+			cfg.IdleTimeout = 300
+			defer func() { cfg.IdleTimeout = 0 }()
+
+			s.Data = map[string]interface{}{
+				"expires":     uint32(time.Now().Unix() + 3600),
+				"last_access": uint64(time.Now().Unix() - 600),
+			}
+
+			err := s.validateExpiration()
+
+			Convey("Then ErrSessionExpired should be wrapped", func() {
+
+				So(unwrap(err), ShouldEqual, ErrSessionExpired)
+				So(err.Error(), ShouldEqual, "Store has been idle for longer than the idle timeout")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through encodeSessionData() -------------------
+
+// TestUnitMaxSessionBytes - Verify that a session is only stored if it is no
+// larger than the configured maximum once encoded
+func TestUnitMaxSessionBytes(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have stored a session and measured it", t, func() {
+
+		connection := newFakeConnection()
+		s := NewStore(NewCacheWithConnection(connection))
+		s.Data = hashSessionData("user-1")
+		So(s.Store(), ShouldBeNil)
+
+		size := len(connection.values[s.ID])
+
+		cfg := config.Get()
+		defer func() { cfg.MaxSessionBytes = 0 }()
+
+		Convey("When I store it again with a limit of exactly its size", func() {
+
+			cfg.MaxSessionBytes = size
+			err := s.Store()
+
+			Convey("Then it should be stored", func() {
+
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I store it again with a limit of one byte less", func() {
+
+			cfg.MaxSessionBytes = size - 1
+			stored := connection.values[s.ID]
+			err := s.Store()
+
+			Convey("Then ErrSessionTooLarge should be wrapped, and the stored session left alone", func() {
+
+				So(unwrap(err), ShouldEqual, ErrSessionTooLarge)
+				So(err.Error(), ShouldContainSubstring, strconv.Itoa(size)+" bytes")
+				So(connection.values[s.ID], ShouldEqual, stored)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
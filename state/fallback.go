@@ -0,0 +1,224 @@
+package state
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+//cookieFallbackPrefix marks a cookie value holding the session itself, rather
+//than its ID. It is in neither base64 alphabet, so can't start a session ID.
+const cookieFallbackPrefix = "~"
+
+//maxCookieFallbackBytes is the largest cookie value a session may be kept in,
+//leaving room for the cookie's name and attributes within the 4KB browsers
+//allow.
+const maxCookieFallbackBytes = 3584
+
+//ErrCookieFallbackInvalid is returned when a cookie value holding a session
+//can't be decrypted, e.g. because it has been tampered with or was encrypted
+//under another secret.
+var ErrCookieFallbackInvalid = errors.New("Session cookie could not be decrypted")
+
+//CookieFallbackCache keeps sessions in the session cookie itself, encrypted and
+//authenticated with AES-GCM, while a Store's Cache is unreachable. It is a
+//degraded mode, to keep small sessions working through a Redis outage rather
+//than failing every request: a session kept in its cookie can't be deleted or
+//found through its user's index, and is limited to maxCookieFallbackBytes.
+//Each cookie is sealed with the session's expiry, after which it is rejected
+//whatever the session data holds, and is rejected once its session has been
+//tombstoned. Once the Cache is reachable again, the next store moves the session
+//back into it.
+type CookieFallbackCache struct {
+	//aeads holds the cipher for each secret, sealing with the first
+	aeads []cipher.AEAD
+}
+
+//NewCookieFallbackCache returns a CookieFallbackCache encrypting sessions with
+//a key derived from the secret, usually the cookie secret, and decrypting them
+//with a key derived from it or any of the fallbacks, so that cookies sealed
+//before the secret was rotated can still be opened.
+func NewCookieFallbackCache(secret string, fallbacks ...string) (*CookieFallbackCache, error) {
+	c := &CookieFallbackCache{}
+
+	for _, secret := range append([]string{secret}, fallbacks...) {
+		if secret == "" && len(c.aeads) > 0 {
+			continue
+		}
+
+		block, err := aes.NewCipher(deriveKey(secret, cookieFallbackKeyPurpose))
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+//seal encrypts the payload, along with the time it expires, into a cookie
+//value, returning ErrSessionTooLarge if the value would be over
+//maxCookieFallbackBytes.
+func (c *CookieFallbackCache) seal(payload string, expires uint64) (string, error) {
+	aead := c.aeads[0]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	plaintext := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint64(plaintext, expires)
+	plaintext = append(plaintext, payload...)
+
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	value := cookieFallbackPrefix + base64.RawURLEncoding.EncodeToString(sealed)
+	if len(value) > maxCookieFallbackBytes {
+		return "", wrapError(ErrSessionTooLarge, fmt.Sprintf("Session is %d bytes as a cookie, over the limit of %d", len(value), maxCookieFallbackBytes))
+	}
+	return value, nil
+}
+
+//open decrypts a cookie value made by seal, under any of the secrets,
+//returning its payload and the time it expires.
+func (c *CookieFallbackCache) open(value string) (string, uint64, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, cookieFallbackPrefix))
+	if err != nil {
+		return "", 0, ErrCookieFallbackInvalid
+	}
+
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil || len(plaintext) < 8 {
+			continue
+		}
+		return string(plaintext[8:]), binary.BigEndian.Uint64(plaintext), nil
+	}
+	return "", 0, ErrCookieFallbackInvalid
+}
+
+//isCacheUnavailable reports whether err means the Cache couldn't be reached,
+//rather than that it refused the command.
+func isCacheUnavailable(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+//WithCookieFallback enables the degraded mode of the CookieFallbackCache: if
+//the Cache is unreachable when storing, the session is kept in its cookie
+//instead, and CookieValue returns the encrypted session rather than its ID. A
+//session ID which can't be loaded because the Cache is unreachable starts a
+//fresh session, under a new ID, rather than failing.
+func (s *Store) WithCookieFallback(fallback *CookieFallbackCache) *Store {
+	s.fallback = fallback
+	return s
+}
+
+//storeInCookie keeps the session in its cookie after the Cache failed to store
+//it with err, if the cookie fallback is enabled and the Cache is unreachable.
+//Otherwise err is returned.
+func (s *Store) storeInCookie(err error) error {
+	if s.fallback == nil || !isCacheUnavailable(err) {
+		return err
+	}
+
+	encoded, encodeErr := s.encodeSessionData()
+	if encodeErr != nil {
+		return encodeErr
+	}
+
+	value, sealErr := s.fallback.seal(s.ID+encoded, s.Expires)
+	if sealErr != nil {
+		return sealErr
+	}
+
+	s.getLogger().Warn("Cache unavailable, keeping session in its cookie: "+err.Error(), map[string]interface{}{"session_id": s.ID})
+	s.fallbackValue = value
+	return nil
+}
+
+//loadFromCookie loads a session kept in its cookie by storeInCookie. A cookie
+//which can't be decrypted, was sealed with an expiry which has passed, holds an
+//expired session or one which has since been tombstoned starts a fresh one.
+func (s *Store) loadFromCookie(cookieValue string) error {
+
+	payload, expires, err := s.fallback.open(cookieValue)
+	if err == nil && len(payload) < signatureStart() {
+		err = ErrCookieFallbackInvalid
+	}
+	if err != nil {
+		s.getLogger().Warn(err.Error(), nil)
+		s.loadOutcome = LoadInvalidSignature
+		s.clearSessionData()
+		return nil
+	}
+
+	// The sealed expiry can't be extended by the session data, however it has
+	// been changed
+	if expires <= uint64(s.now().Unix()) {
+		s.getLogger().Info("Session cookie has expired", nil)
+		s.loadOutcome = LoadExpired
+		s.clearSessionData()
+		return nil
+	}
+
+	id := payload[:signatureStart()]
+	data, err := s.decodeSession(payload[signatureStart():], versionedKey(id, s.keyVersion), "")
+	if err != nil {
+		s.getLogger().Warn(err.Error(), nil)
+		s.loadOutcome = LoadInvalidSignature
+		s.clearSessionData()
+		return nil
+	}
+
+	s.ID = id
+	s.Data = data
+	s.takeSnapshot()
+
+	if err := s.validateExpiration(); err != nil {
+		s.getLogger().Info(err.Error(), map[string]interface{}{"session_id": s.ID})
+		s.loadOutcome = LoadExpired
+		s.clearSessionData()
+		return nil
+	}
+
+	if err := s.validateTokenFamily(); err != nil {
+		s.getLogger().Info(err.Error(), map[string]interface{}{"session_id": s.ID})
+		s.loadOutcome = LoadExpired
+		s.clearSessionData()
+		return nil
+	}
+
+	// A session cleared since it was kept in its cookie has been tombstoned, so
+	// the cookie is being replayed
+	if s.replayDetection() && s.tombstoned() {
+		s.getLogger().Info("Session cookie has been replayed after its session was cleared", map[string]interface{}{"session_id": s.ID})
+		s.onReplay(s.ID)
+		s.loadOutcome = LoadExpired
+		s.clearSessionData()
+		return nil
+	}
+
+	s.loadOutcome = LoadLoaded
+	s.persisted = true
+	s.runHook(s.hooks.OnLoad)
+	return nil
+}
@@ -0,0 +1,285 @@
+package state
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// outageConnection is a fakeConnection whose reads and writes fail with err
+// while it is set, as they would while Redis is unreachable
+type outageConnection struct {
+	*fakeConnection
+	err error
+}
+
+func (c *outageConnection) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	if c.err != nil {
+		return redis.NewStatusResult("", c.err)
+	}
+	return c.fakeConnection.Set(key, value, expiration)
+}
+
+func (c *outageConnection) Get(key string) *redis.StringCmd {
+	if c.err != nil {
+		return redis.NewStringResult("", c.err)
+	}
+	return c.fakeConnection.Get(key)
+}
+
+// ------------------- Routes Through WithCookieFallback() -------------------
+
+// TestUnitCookieFallback - Verify that sessions are kept in, and loaded from,
+// an encrypted cookie while the cache is unreachable, and only then
+func TestUnitCookieFallback(t *testing.T) {
+
+	initConfig()
+
+	cfg := config.Get()
+	defaultExpiration := cfg.DefaultExpiration
+	cfg.DefaultExpiration = "600"
+	defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+	Convey("Given the cache is unreachable and the cookie fallback is enabled", t, func() {
+
+		connection := &outageConnection{
+			fakeConnection: newFakeConnection(),
+			err:            &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+		}
+		cache := NewCacheWithConnection(connection)
+
+		fallback, err := NewCookieFallbackCache(cfg.CookieSecret)
+		So(err, ShouldBeNil)
+
+		s := NewStore(cache).WithCookieFallback(fallback)
+		s.Data = map[string]interface{}{"page": "basket", "items": []interface{}{"a", "b"}}
+
+		Convey("When I store the session", func() {
+
+			err := s.Store()
+
+			Convey("Then it should be kept in its encrypted cookie instead", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.values, ShouldBeEmpty)
+				So(s.CookieValue(), ShouldStartWith, cookieFallbackPrefix)
+				So(s.CookieValue(), ShouldNotContainSubstring, s.ID)
+			})
+
+			Convey("And loading the cookie should restore the session without the cache", func() {
+
+				loaded := NewStore(cache).WithCookieFallback(fallback)
+
+				So(loaded.Load(s.CookieValue()), ShouldBeNil)
+				So(loaded.ID, ShouldEqual, s.ID)
+				So(loaded.Expires, ShouldEqual, s.Expires)
+				So(loaded.Data["page"], ShouldEqual, "basket")
+				So(loaded.Data["items"], ShouldResemble, []interface{}{"a", "b"})
+			})
+
+			Convey("And once the cache is back, the next store should move it there", func() {
+
+				connection.err = nil
+				loaded := NewStore(cache).WithCookieFallback(fallback)
+				So(loaded.Load(s.CookieValue()), ShouldBeNil)
+
+				So(loaded.Store(), ShouldBeNil)
+				So(connection.values, ShouldContainKey, s.ID)
+				So(loaded.CookieValue(), ShouldEqual, s.ID+generateSignature(s.ID))
+			})
+
+			Convey("And a tampered cookie should start a fresh session", func() {
+
+				value := []byte(s.CookieValue())
+				if value[10] == 'A' {
+					value[10] = 'B'
+				} else {
+					value[10] = 'A'
+				}
+
+				loaded := NewStore(cache).WithCookieFallback(fallback)
+
+				So(loaded.Load(string(value)), ShouldBeNil)
+				So(loaded.Data, ShouldBeEmpty)
+			})
+
+			Convey("And a cookie encrypted under another secret should start a fresh session", func() {
+
+				other, err := NewCookieFallbackCache("a different secret entirely")
+				So(err, ShouldBeNil)
+
+				loaded := NewStore(cache).WithCookieFallback(other)
+
+				So(loaded.Load(s.CookieValue()), ShouldBeNil)
+				So(loaded.Data, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I store a session too large for a cookie", func() {
+
+			s.Data["notes"] = strings.Repeat("x", maxCookieFallbackBytes)
+			err := s.Store()
+
+			Convey("Then ErrSessionTooLarge should be wrapped", func() {
+
+				So(unwrap(err), ShouldEqual, ErrSessionTooLarge)
+				So(s.CookieValue(), ShouldNotStartWith, cookieFallbackPrefix)
+			})
+		})
+
+		Convey("When I load a session ID", func() {
+
+			id := strings.Repeat("a", signatureStart())
+			err := s.Load(id + generateSignature(id))
+
+			Convey("Then a fresh session should be started under a new ID", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data, ShouldBeEmpty)
+				So(s.ID, ShouldBeBlank)
+			})
+		})
+
+		Convey("When the cache refuses the store rather than being unreachable", func() {
+
+			connection.err = errors.New("READONLY You can't write against a read only replica")
+			err := s.Store()
+
+			Convey("Then the error should be returned, without falling back", func() {
+
+				So(err, ShouldEqual, connection.err)
+				So(s.CookieValue(), ShouldNotStartWith, cookieFallbackPrefix)
+			})
+		})
+	})
+
+	Convey("Given the cache is unreachable and the cookie fallback is disabled", t, func() {
+
+		connection := &outageConnection{fakeConnection: newFakeConnection(), err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+		s := NewStore(NewCacheWithConnection(connection))
+		s.Data = map[string]interface{}{"page": "basket"}
+
+		Convey("Then storing should fail", func() {
+
+			So(s.Store(), ShouldEqual, connection.err)
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitCookieFallbackRevocation - Verify that a session kept in its cookie is
+// rejected once the expiry sealed with it has passed, whatever its data holds,
+// or once it has been tombstoned
+func TestUnitCookieFallbackRevocation(t *testing.T) {
+
+	initConfig()
+
+	cfg := config.Get()
+	defaultExpiration := cfg.DefaultExpiration
+	cfg.DefaultExpiration = "600"
+	defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+	Convey("Given a session kept in its cookie while the cache was unreachable", t, func() {
+
+		clock := &fakeClock{now: time.Unix(1700000000, 0)}
+		connection := &outageConnection{
+			fakeConnection: newFakeConnection(),
+			err:            &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+		}
+		cache := NewCacheWithConnection(connection)
+
+		fallback, err := NewCookieFallbackCache(cfg.CookieSecret)
+		So(err, ShouldBeNil)
+
+		var replayed []string
+		newStore := func() *Store {
+			return NewStore(cache).
+				WithClock(clock).
+				WithCookieFallback(fallback).
+				WithReplayDetection(time.Minute, func(sessionID string) { replayed = append(replayed, sessionID) })
+		}
+
+		s := newStore()
+		s.Data = map[string]interface{}{"page": "basket"}
+		So(s.Store(), ShouldBeNil)
+		So(s.CookieValue(), ShouldStartWith, cookieFallbackPrefix)
+
+		load := func(cookieValue string) (*Store, LoadOutcome) {
+			loaded := newStore()
+			outcome, err := loaded.LoadResult(cookieValue)
+			So(err, ShouldBeNil)
+			return loaded, outcome
+		}
+
+		Convey("When the clock reaches its expiry", func() {
+
+			clock.Advance(600 * time.Second)
+			loaded, outcome := load(s.CookieValue())
+
+			Convey("Then it should have expired", func() {
+
+				So(outcome, ShouldEqual, LoadExpired)
+				So(loaded.Data, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When its data holds a later expiry than was sealed with it", func() {
+
+			s.Data["expires"] = clock.now.Unix() + 3600
+			later, err := s.encodeSessionData()
+			So(err, ShouldBeNil)
+
+			value, err := fallback.seal(s.ID+later, uint64(clock.now.Unix()+60))
+			So(err, ShouldBeNil)
+
+			clock.Advance(61 * time.Second)
+			loaded, outcome := load(value)
+
+			Convey("Then the sealed expiry should still apply", func() {
+
+				So(outcome, ShouldEqual, LoadExpired)
+				So(loaded.Data, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When its session has been tombstoned since", func() {
+
+			connection.err = nil
+			connection.values[tombstoneKeyPrefix+s.ID] = "1"
+			loaded, outcome := load(s.CookieValue())
+
+			Convey("Then it should be rejected as a replay", func() {
+
+				So(outcome, ShouldEqual, LoadExpired)
+				So(loaded.Data, ShouldBeEmpty)
+				So(replayed, ShouldResemble, []string{s.ID})
+			})
+		})
+
+		Convey("When the cookie secret has been rotated, keeping the old one as a fallback", func() {
+
+			rotated, err := NewCookieFallbackCache("a new cookie secret", cfg.CookieSecret)
+			So(err, ShouldBeNil)
+
+			loaded := NewStore(cache).WithClock(clock).WithCookieFallback(rotated)
+			outcome, err := loaded.LoadResult(s.CookieValue())
+
+			Convey("Then it should still load", func() {
+
+				So(err, ShouldBeNil)
+				So(outcome, ShouldEqual, LoadLoaded)
+				So(loaded.Data["page"], ShouldEqual, "basket")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,28 @@
+package state
+
+import "errors"
+
+//TokenFamilyChecker reports whether the OAuth refresh token family with the
+//given ID has been revoked, in which case every session derived from it must be
+//rejected.
+type TokenFamilyChecker func(familyID string) bool
+
+//WithTokenFamilyChecker sets the hook consulted by Load to reject sessions whose
+//token family has been revoked. Sessions without a token family aren't checked.
+func (s *Store) WithTokenFamilyChecker(checker TokenFamilyChecker) *Store {
+	s.familyChecker = checker
+	return s
+}
+
+//validateTokenFamily returns an error if the loaded session's token family has
+//been revoked.
+func (s *Store) validateTokenFamily() error {
+	if s.familyChecker == nil {
+		return nil
+	}
+
+	if familyID := s.Data.TokenFamilyID(); familyID != "" && s.familyChecker(familyID) {
+		return errors.New("Store belongs to a revoked token family")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ------------------- Routes Through WithTokenFamilyChecker() -------------------
+
+// TestUnitLoadRevokedTokenFamily - Verify that a session whose token family has
+// been revoked is cleared on load, and one from an active family is loaded
+func TestUnitLoadRevokedTokenFamily(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given sessions are stored from a revoked and an active token family", t, func() {
+
+		cache := NewMemoryCache()
+
+		storeFamily := func(familyID string) string {
+			s := NewStore(cache)
+			s.Data = map[string]interface{}{
+				"expires": uint32(time.Now().Unix() + 100),
+				"signin_info": map[string]interface{}{
+					"access_token": map[string]interface{}{
+						"expires_in": uint16(100),
+						"family_id":  familyID,
+					},
+				},
+			}
+			So(s.Store(), ShouldBeNil)
+			return s.CookieValue()
+		}
+		revoked := storeFamily("family-revoked")
+		active := storeFamily("family-active")
+
+		checker := func(familyID string) bool {
+			return familyID == "family-revoked"
+		}
+
+		Convey("When the session from the revoked family is loaded", func() {
+
+			s := NewStore(cache).WithTokenFamilyChecker(checker)
+			err := s.Load(revoked)
+
+			Convey("Then the session should be cleared", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the session from the active family is loaded", func() {
+
+			s := NewStore(cache).WithTokenFamilyChecker(checker)
+			err := s.Load(active)
+
+			Convey("Then the session should be loaded", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data.TokenFamilyID(), ShouldEqual, "family-active")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,251 @@
+package state
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	session "github.com/companieshouse/go-session-handler/session"
+)
+
+//ErrHashSetCondition is returned by Store when a SetCondition other than
+//SetAlways is used with a Cache storing sessions as hashes, since a hash can't
+//be written conditionally in a single command.
+var ErrHashSetCondition = errors.New("Set conditions are not supported when storing sessions as hashes")
+
+//NewHashCache will initialise a new Cache object which stores each session as
+//a Redis hash, rather than as a single string.
+//
+//Each top level key of the session data is stored as a field of the hash,
+//whose value is that key alone encoded using the Store's Serializer (and
+//sealed, if the integrity check is enabled). Nested values, such as
+//signin_info, are therefore encoded whole within their field and keep the
+//types the Serializer gives them, but can't be updated independently of the
+//rest of their top level key. Fields removed from the session data since it
+//was loaded are deleted from the hash when it is next stored.
+func NewHashCache(addr string, db int, password string) *Cache {
+	cache := NewCache(addr, db, password)
+	cache.hashes = true
+	return cache
+}
+
+//NewHashCacheWithConnection will initialise a new Cache object storing each
+//session as a Redis hash, as NewHashCache does, using an existing Connection.
+func NewHashCacheWithConnection(connection Connection) *Cache {
+	cache := NewCacheWithConnection(connection)
+	cache.hashes = true
+	return cache
+}
+
+//setSessionFields stores the fields in the hash at key, then deletes the
+//removed fields from it and, if the expiration isn't 0, has it evicted once
+//the expiration has passed. Where the Connection supports it, the commands are
+//sent in a single transaction, taking one round trip and leaving the hash
+//either wholly updated or untouched. Otherwise they are sent one at a time.
+func (c *Cache) setSessionFields(key string, fields map[string]string, removed []string, expiration time.Duration) error {
+	connection, ok := c.getConnection().(TxConnection)
+	if !ok {
+		return c.setSessionFieldsSequentially(key, fields, removed, expiration)
+	}
+
+	tx := connection.TxPipeline()
+	tx.HMSet(key, fields)
+	if len(removed) > 0 {
+		tx.HDel(key, removed...)
+	}
+	if expiration > 0 {
+		tx.Expire(key, expiration)
+	}
+
+	_, err := tx.Exec()
+	return err
+}
+
+//setSessionFieldsSequentially sends the commands storing a hash one at a time,
+//stopping at the first error, for Connections which can't execute
+//transactions.
+func (c *Cache) setSessionFieldsSequentially(key string, fields map[string]string, removed []string, expiration time.Duration) error {
+	if _, err := c.getConnection().HMSet(key, fields).Result(); err != nil {
+		return err
+	}
+
+	if len(removed) > 0 {
+		if _, err := c.getConnection().HDel(key, removed...).Result(); err != nil {
+			return err
+		}
+	}
+
+	if expiration > 0 {
+		return c.expireSessionData(key, expiration)
+	}
+	return nil
+}
+
+//getSessionFields loads every field of the hash at key. A missing key is
+//returned as an empty map.
+func (c *Cache) getSessionFields(key string) (map[string]string, error) {
+	return c.getConnection().HGetAll(key).Result()
+}
+
+//fetchData gets and decodes the session stored under the Store's ID, from a
+//hash if the Cache stores sessions as hashes.
+func (s *Store) fetchData() (map[string]interface{}, error) {
+	if s.cache.hashes {
+		return s.fetchHash()
+	}
+
+	key, stored, err := s.fetchSessionWithKey()
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeSession(stored, key, "")
+}
+
+//save stamps the session data with the current schema version, and its
+//version counter if optimistic locking is enabled, then encodes it and stores
+//it in the cache, as a hash if the Cache stores sessions as hashes.
+func (s *Store) save() error {
+	s.stampSchemaVersion()
+
+	if s.cache.hashes {
+		if s.optimisticLocking {
+			return ErrHashOptimisticLocking
+		}
+		return s.storeHash()
+	}
+
+	if s.optimisticLocking {
+		s.incrementVersion()
+	}
+
+	encodedData, err := s.encodeSessionData()
+	if err != nil {
+		return err
+	}
+	return s.storeSession(encodedData)
+}
+
+//fetchHash gets the session stored as a hash under the Store's ID, returning
+//ErrSessionNotFound if there is none. As with fetchSession, each older key
+//version is tried in turn on a miss.
+func (s *Store) fetchHash() (map[string]interface{}, error) {
+
+	for i, key := range s.sessionKeys(s.ID) {
+		span := s.startSpan("hgetall", len(key))
+		start, dials := time.Now(), s.cache.dials()
+		fields, err := s.cache.getSessionFields(key)
+		span.End(err)
+		s.observe("hgetall", start, dials, err)
+		s.getMetrics().ObserveRedisLatency(time.Since(start))
+		if err != nil {
+			return nil, err
+		}
+
+		// Redis doesn't store empty hashes, so no fields means no session
+		if len(fields) == 0 {
+			continue
+		}
+
+		data := map[string]interface{}{}
+		for field, value := range fields {
+			decoded, err := s.decodeSession(value, key, field)
+			if err != nil {
+				return nil, err
+			}
+			data[field] = decoded[field]
+		}
+
+		if i > 0 {
+			// Found under an older version, so move it on the next store
+			s.migrateFromKey = key
+		}
+		s.storedFields = sortedKeys(fields)
+		return data, nil
+	}
+
+	return nil, ErrSessionNotFound
+}
+
+//storeHash stores each top level key of the session data as a field of the
+//hash under the Store's ID, deleting any fields stored previously which have
+//since been removed from the data.
+func (s *Store) storeHash() error {
+
+	if s.setCondition != SetAlways {
+		return ErrHashSetCondition
+	}
+
+	key := s.sessionKey()
+	fields := map[string]string{}
+	for field, value := range s.Data {
+		encoded, err := s.encodeData(map[string]interface{}{field: value}, key, field)
+		if err != nil {
+			return err
+		}
+		fields[field] = encoded
+	}
+
+	var removed []string
+	for _, field := range s.storedFields {
+		if _, ok := fields[field]; !ok {
+			removed = append(removed, field)
+		}
+	}
+
+	span := s.startSpan("hmset", len(key))
+	start, dials := time.Now(), s.cache.dials()
+	err := s.cache.setSessionFields(key, fields, removed, s.cacheTTL())
+	span.End(err)
+	s.observe("hmset", start, dials, err)
+	s.getMetrics().ObserveRedisLatency(time.Since(start))
+	if err != nil {
+		return err
+	}
+
+	s.storedFields = sortedKeys(fields)
+	return s.stored()
+}
+
+//loadManyHashes loads the sessions stored as hashes under the given keys,
+//which are indexed by signed session ID, one key at a time. As with LoadMany,
+//sessions which are missing or can't be decoded are omitted.
+func (s *Store) loadManyHashes(keys map[string]string) (map[string]session.Session, error) {
+
+	sessions := map[string]session.Session{}
+	for sessionID, key := range keys {
+		fields, err := s.cache.getSessionFields(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		data := session.Session{}
+		for field, value := range fields {
+			decoded, err := s.decodeSession(value, key, field)
+			if err != nil {
+				s.getLogger().Warn("Session could not be decoded: "+err.Error(), map[string]interface{}{"session_id": key})
+				data = nil
+				break
+			}
+			data[field] = decoded[field]
+		}
+
+		if data != nil {
+			sessions[sessionID] = data
+		}
+	}
+
+	return sessions, nil
+}
+
+//sortedKeys returns the keys of the map in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,204 @@
+package state
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// hashSessionData returns session data for a signed in user which is still
+// valid, since Store doesn't set "expires" itself
+func hashSessionData(userID string) map[string]interface{} {
+	data := signedInData(userID)
+	data["expires"] = uint32(time.Now().Unix() + 100)
+	return data
+}
+
+// ------------------- Routes Through NewHashCache() -------------------
+
+// TestUnitHashCacheRoundTrip - Verify that a session stored as a hash is loaded
+// back with its nested values intact
+func TestUnitHashCacheRoundTrip(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a session is stored using a hash Cache", t, func() {
+
+		connection := newFakeConnection()
+		cache := NewHashCacheWithConnection(connection)
+
+		s := NewStore(cache)
+		s.Data = hashSessionData("user-1")
+		So(s.Store(), ShouldBeNil)
+
+		Convey("Then each top level key should be stored as a field of the hash", func() {
+
+			So(connection.values, ShouldBeEmpty)
+			So(connection.hashes[s.ID], ShouldContainKey, "signin_info")
+			So(connection.hashes[s.ID], ShouldContainKey, "expires")
+			So(connection.hashes[s.ID], ShouldContainKey, "last_access")
+		})
+
+		Convey("When the session is loaded", func() {
+
+			loaded := NewStore(cache)
+			err := loaded.Load(s.CookieValue())
+
+			Convey("Then the session data should be reconstructed", func() {
+
+				So(err, ShouldBeNil)
+				So(loaded.ID, ShouldEqual, s.ID)
+				So(loaded.Data.GetUserID(), ShouldEqual, "user-1")
+				So(loaded.Data.GetExpiration(), ShouldEqual, 123)
+				So(loaded.Expires, ShouldEqual, s.Data["expires"])
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitHashCacheRemovesFields - Verify that keys removed from the session
+// data are deleted from the hash when it is next stored
+func TestUnitHashCacheRemovesFields(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a stored hash session has an extra key", t, func() {
+
+		connection := newFakeConnection()
+		cache := NewHashCacheWithConnection(connection)
+
+		s := NewStore(cache)
+		s.Data = hashSessionData("user-1")
+		s.Data["page"] = "home"
+		So(s.Store(), ShouldBeNil)
+		So(connection.hashes[s.ID], ShouldContainKey, "page")
+
+		Convey("When it is loaded, the key removed and the session stored again", func() {
+
+			loaded := NewStore(cache)
+			So(loaded.Load(s.CookieValue()), ShouldBeNil)
+			delete(loaded.Data, "page")
+			err := loaded.Store()
+
+			Convey("Then the field should be deleted and the others kept", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.hashes[s.ID], ShouldNotContainKey, "page")
+				So(connection.hashes[s.ID], ShouldContainKey, "signin_info")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitHashCacheTransaction - Verify that a hash session's fields are set,
+// deleted and expired in a single transaction where the connection supports it
+func TestUnitHashCacheTransaction(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a stored hash session has an extra key, on a connection with transactions", t, func() {
+
+		connection := &txConnection{fakeConnection: newFakeConnection()}
+		cache := NewHashCacheWithConnection(connection)
+
+		s := NewStore(cache)
+		s.Data = hashSessionData("user-1")
+		s.Data["page"] = "home"
+		So(s.Store(), ShouldBeNil)
+		So(connection.hashes[s.ID], ShouldContainKey, "page")
+		So(connection.ttls[s.ID], ShouldBeGreaterThan, 0)
+
+		loaded := NewStore(cache)
+		So(loaded.Load(s.CookieValue()), ShouldBeNil)
+		delete(loaded.Data, "page")
+		loaded.Data["step"] = "2"
+
+		Convey("When the transaction storing it again fails", func() {
+
+			connection.err = errors.New("EXECABORT")
+			err := loaded.Store()
+
+			Convey("Then the hash should be left untouched", func() {
+
+				So(err, ShouldEqual, connection.err)
+				So(connection.hashes[s.ID], ShouldContainKey, "page")
+				So(connection.hashes[s.ID], ShouldNotContainKey, "step")
+			})
+		})
+
+		Convey("When the transaction storing it again succeeds", func() {
+
+			err := loaded.Store()
+
+			Convey("Then the field should be replaced", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.hashes[s.ID], ShouldNotContainKey, "page")
+				So(connection.hashes[s.ID], ShouldContainKey, "step")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitHashCacheSessionNotFound - Verify that loading a session with no hash
+// stored starts an empty session
+func TestUnitHashCacheSessionNotFound(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a valid session ID with no hash stored", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		Convey("When I load the session using a hash Cache", func() {
+
+			s := NewStore(NewHashCacheWithConnection(newFakeConnection()))
+			err := s.Load(sessionID)
+
+			Convey("Then an empty session should be loaded", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data, ShouldBeEmpty)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitHashCacheSetCondition - Verify that conditional stores are rejected
+// when sessions are stored as hashes
+func TestUnitHashCacheSetCondition(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a Store using a hash Cache with a set condition", t, func() {
+
+		connection := newFakeConnection()
+		s := NewStore(NewHashCacheWithConnection(connection)).WithSetCondition(SetIfAbsent)
+		s.Data = hashSessionData("user-1")
+
+		Convey("When the session is stored", func() {
+
+			err := s.Store()
+
+			Convey("Then it should be rejected without being written", func() {
+
+				So(err, ShouldEqual, ErrHashSetCondition)
+				So(connection.hashes, ShouldBeEmpty)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,55 @@
+package state
+
+import (
+	session "github.com/companieshouse/go-session-handler/session"
+)
+
+//SessionHooks are called at points in the lifecycle of a Store's session, e.g.
+//for audit trails or cache warming. Each hook is optional, and is passed a
+//copy of the session's data: changes made to it aren't stored.
+//
+//Hooks run synchronously, on the request's goroutine, so should return quickly,
+//handing any slow work off to another goroutine.
+type SessionHooks struct {
+	//OnLoad is called when a stored session is loaded and found to be valid
+	OnLoad func(data session.Session)
+
+	//OnCreate is called when a session which wasn't loaded is first stored
+	OnCreate func(data session.Session)
+
+	//OnDestroy is called when the loaded session is deleted or cleared, with its
+	//data as it was before being removed
+	OnDestroy func(data session.Session)
+}
+
+//WithSessionHooks sets the hooks called as the Store's session is loaded,
+//created and destroyed.
+func (s *Store) WithSessionHooks(hooks SessionHooks) *Store {
+	s.hooks = hooks
+	return s
+}
+
+//runHook calls hook, if set, with a copy of the session data, so that the hook
+//can't change the session through it.
+func (s *Store) runHook(hook func(data session.Session)) {
+	if hook == nil {
+		return
+	}
+
+	view := session.Session{}
+	for key, value := range s.Data {
+		view[key] = copyValue(value)
+	}
+	hook(view)
+}
+
+//destroyed calls the OnDestroy hook once the Store's session has been removed
+//from the Cache, if it had been loaded or stored.
+func (s *Store) destroyed() {
+	if !s.persisted {
+		return
+	}
+
+	s.persisted = false
+	s.runHook(s.hooks.OnDestroy)
+}
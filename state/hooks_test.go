@@ -0,0 +1,155 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/companieshouse/go-session-handler/config"
+	session "github.com/companieshouse/go-session-handler/session"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordingHooks records the session data each of its hooks is called with
+type recordingHooks struct {
+	loaded, created, destroyed []session.Session
+}
+
+func (r *recordingHooks) hooks() SessionHooks {
+	return SessionHooks{
+		OnLoad:    func(data session.Session) { r.loaded = append(r.loaded, data) },
+		OnCreate:  func(data session.Session) { r.created = append(r.created, data) },
+		OnDestroy: func(data session.Session) { r.destroyed = append(r.destroyed, data) },
+	}
+}
+
+// ------------------- Routes Through WithSessionHooks() -------------------
+
+// TestUnitSessionHooks - Verify that each session hook is called exactly once,
+// with a copy of the session's data, as a session is created, loaded and
+// destroyed
+func TestUnitSessionHooks(t *testing.T) {
+
+	initConfig()
+
+	cfg := config.Get()
+	defaultExpiration := cfg.DefaultExpiration
+	cfg.DefaultExpiration = "600"
+	defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+	Convey("Given I have a Store with session hooks", t, func() {
+
+		cache := NewCacheWithConnection(newFakeConnection())
+		recorded := &recordingHooks{}
+		s := NewStore(cache).WithSessionHooks(recorded.hooks())
+		s.Data = map[string]interface{}{"page": "basket"}
+
+		Convey("When I store a new session, and store it again", func() {
+
+			So(s.Store(), ShouldBeNil)
+			s.Data["page"] = "checkout"
+			So(s.Store(), ShouldBeNil)
+
+			Convey("Then OnCreate should have been called once, with its data as first stored", func() {
+
+				So(recorded.created, ShouldHaveLength, 1)
+				So(recorded.created[0]["page"], ShouldEqual, "basket")
+				So(recorded.loaded, ShouldBeEmpty)
+				So(recorded.destroyed, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I load a stored session, and store it", func() {
+
+			So(s.Store(), ShouldBeNil)
+			cookieValue := s.CookieValue()
+
+			loadedHooks := &recordingHooks{}
+			loaded := NewStore(cache).WithSessionHooks(loadedHooks.hooks())
+			So(loaded.Load(cookieValue), ShouldBeNil)
+			So(loaded.Store(), ShouldBeNil)
+
+			Convey("Then OnLoad should have been called once, and OnCreate not at all", func() {
+
+				So(loadedHooks.loaded, ShouldHaveLength, 1)
+				So(loadedHooks.loaded[0]["page"], ShouldEqual, "basket")
+				So(loadedHooks.created, ShouldBeEmpty)
+				So(loadedHooks.destroyed, ShouldBeEmpty)
+			})
+
+			Convey("And changes made through the hook's data shouldn't change the session", func() {
+
+				loadedHooks.loaded[0]["page"] = "changed"
+
+				So(loaded.Data["page"], ShouldEqual, "basket")
+			})
+		})
+
+		Convey("When I load a session which isn't stored", func() {
+
+			id := strings.Repeat("a", signatureStart())
+			So(s.Load(id+generateSignature(id)), ShouldBeNil)
+
+			Convey("Then no hook should have been called", func() {
+
+				So(recorded.loaded, ShouldBeEmpty)
+				So(recorded.created, ShouldBeEmpty)
+				So(recorded.destroyed, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I clear a stored session", func() {
+
+			So(s.Store(), ShouldBeNil)
+			So(s.Clear(), ShouldBeNil)
+
+			Convey("Then OnDestroy should have been called once, with its data before it was cleared", func() {
+
+				So(recorded.destroyed, ShouldHaveLength, 1)
+				So(recorded.destroyed[0]["page"], ShouldEqual, "basket")
+			})
+
+			Convey("And storing the fresh session should call OnCreate again", func() {
+
+				So(s.Store(), ShouldBeNil)
+
+				So(recorded.created, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When I delete a stored session, and delete it again", func() {
+
+			So(s.Store(), ShouldBeNil)
+			So(s.Delete(nil), ShouldBeNil)
+			So(s.Delete(nil), ShouldBeNil)
+
+			Convey("Then OnDestroy should have been called once", func() {
+
+				So(recorded.destroyed, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When I clear a session which was never stored", func() {
+
+			So(s.Clear(), ShouldBeNil)
+
+			Convey("Then OnDestroy shouldn't have been called", func() {
+
+				So(recorded.destroyed, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I regenerate a stored session's ID, keeping its data", func() {
+
+			So(s.Store(), ShouldBeNil)
+			So(s.RegenerateKeepData(), ShouldBeNil)
+
+			Convey("Then it should neither be created again, nor destroyed", func() {
+
+				So(recorded.created, ShouldHaveLength, 1)
+				So(recorded.destroyed, ShouldBeEmpty)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,97 @@
+package state
+
+//indexAddScript adds ARGV[2] onwards to the set at KEYS[1], then extends the
+//set's TTL to ARGV[1] milliseconds unless it already has a longer one, so that
+//an index outlives each of its members, but is evicted once they have all
+//expired. A TTL of 0 is for a member which never expires, so removes the set's
+//TTL, and an existing set with no TTL is left without one.
+const indexAddScript = `
+local existed = redis.call("EXISTS", KEYS[1]) == 1
+redis.call("SADD", KEYS[1], unpack(ARGV, 2))
+local ttl = tonumber(ARGV[1])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+	return 1
+end
+local current = redis.call("PTTL", KEYS[1])
+if not existed or (current >= 0 and current < ttl) then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
+`
+
+//userSessionsKey returns the key of the set indexing the IDs of every session
+//stored for the given user
+func userSessionsKey(userID string) string {
+	return "user:" + userID + ":sessions"
+}
+
+//userRememberTokensKey returns the key of the set indexing the IDs of every
+//remember token issued to the given user
+func userRememberTokensKey(userID string) string {
+	return "user:" + userID + ":remember"
+}
+
+//DeleteUserSessions removes every session belonging to the given user from the
+//cache, e.g. when their account has been compromised, along with their remember
+//tokens, so that no new session can be minted from one, and the indexes of
+//both. The indexes are maintained whenever a signed in session is stored, or a
+//remember token issued, so only those stored or issued since then can be found.
+//
+//Each index expires once everything in it has, but in the meantime keeps the
+//IDs of sessions and tokens the cache has evicted. Those are pruned first.
+func (s *Store) DeleteUserSessions(userID string) error {
+	key := userSessionsKey(userID)
+	tokensKey := userRememberTokensKey(userID)
+
+	sessionIDs, err := s.pruneIndex(key, s.sessionKeys)
+	if err != nil {
+		return err
+	}
+
+	tokenIDs, err := s.pruneIndex(tokensKey, func(id string) []string {
+		return []string{rememberKeyPrefix + id}
+	})
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, s.sessionKeys(sessionID)...)
+	}
+	for _, tokenID := range tokenIDs {
+		keys = append(keys, rememberKeyPrefix+tokenID)
+	}
+
+	return s.deleteKeys(append(keys, key, tokensKey)...)
+}
+
+//pruneIndex removes the members of the index at key with nothing left stored
+//under any of the keys returned for them by keysOf, returning the others.
+func (s *Store) pruneIndex(key string, keysOf func(member string) []string) ([]string, error) {
+	members, err := s.cache.getIndex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var live []string
+	for _, member := range members {
+		exists := false
+		for _, memberKey := range keysOf(member) {
+			if exists, err = s.cache.sessionDataExists(memberKey); err != nil {
+				return nil, err
+			}
+			if exists {
+				break
+			}
+		}
+
+		if exists {
+			live = append(live, member)
+		} else if err := s.cache.removeFromIndex(key, member); err != nil {
+			return nil, err
+		}
+	}
+	return live, nil
+}
@@ -0,0 +1,172 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func signedInData(userID string) map[string]interface{} {
+	return map[string]interface{}{
+		"signin_info": map[string]interface{}{
+			"user_profile": map[string]interface{}{
+				"id": userID,
+			},
+			"access_token": map[string]interface{}{
+				"expires_in": uint16(123),
+			},
+		},
+	}
+}
+
+// ------------------- Routes Through DeleteUserSessions() -------------------
+
+// TestUnitDeleteUserSessions - Verify that every session stored for a user is
+// deleted, leaving other users' sessions alone
+func TestUnitDeleteUserSessions(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given two sessions have been stored for one user and one for another", t, func() {
+
+		connection := newFakeConnection()
+		cache := &Cache{connection: connection}
+
+		var ids []string
+		for _, userID := range []string{"user1", "user1", "user2"} {
+			s := NewStore(cache)
+			s.Data = signedInData(userID)
+			So(s.Store(), ShouldBeNil)
+			ids = append(ids, s.ID)
+		}
+
+		So(connection.sets[userSessionsKey("user1")], ShouldContainKey, ids[0])
+		So(connection.sets[userSessionsKey("user1")], ShouldContainKey, ids[1])
+
+		Convey("When I delete the first user's sessions", func() {
+
+			err := NewStore(cache).DeleteUserSessions("user1")
+
+			Convey("Then their sessions and index should be removed, but not the other user's", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.values, ShouldNotContainKey, ids[0])
+				So(connection.values, ShouldNotContainKey, ids[1])
+				So(connection.sets, ShouldNotContainKey, userSessionsKey("user1"))
+				So(connection.values, ShouldContainKey, ids[2])
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitClearRemovesFromUserIndex - Verify that clearing a signed in session
+// removes it from its user's session index
+func TestUnitClearRemovesFromUserIndex(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a session has been stored for a user", t, func() {
+
+		connection := newFakeConnection()
+		s := NewStore(&Cache{connection: connection})
+		s.Data = signedInData("user1")
+		So(s.Store(), ShouldBeNil)
+
+		id := s.ID
+
+		Convey("When I clear the session", func() {
+
+			err := s.Clear()
+
+			Convey("Then it should be removed from the cache and the user's index", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.values, ShouldNotContainKey, id)
+				So(connection.sets[userSessionsKey("user1")], ShouldNotContainKey, id)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitUserIndexExpires - Verify that a user's index is kept for as long as
+// the longest lived session in it, and no longer
+func TestUnitUserIndexExpires(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a session with a long lifetime has been stored for a user", t, func() {
+
+		connection := newFakeConnection()
+		cache := &Cache{connection: connection}
+
+		long := NewStore(cache)
+		long.Data = signedInData("user1")
+		long.SetExpiration(3600)
+		So(long.Store(), ShouldBeNil)
+
+		So(connection.ttls[userSessionsKey("user1")], ShouldEqual, connection.ttls[long.ID])
+
+		Convey("When a session with a shorter lifetime is stored for them", func() {
+
+			short := NewStore(cache)
+			short.Data = signedInData("user1")
+			So(short.Store(), ShouldBeNil)
+
+			Convey("Then the index should still be kept for the longer lifetime", func() {
+
+				So(connection.ttls[short.ID], ShouldBeLessThan, connection.ttls[long.ID])
+				So(connection.ttls[userSessionsKey("user1")], ShouldEqual, connection.ttls[long.ID])
+			})
+		})
+
+		Convey("When a remember token is issued to them", func() {
+
+			_, err := long.IssueRememberToken(48 * time.Hour)
+
+			Convey("Then the token's index should be kept for the token's lifetime", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.ttls[userRememberTokensKey("user1")], ShouldEqual, 48*time.Hour)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitDeleteUserSessionsPrunesIndex - Verify that the index entries of
+// sessions which are no longer stored are pruned
+func TestUnitDeleteUserSessionsPrunesIndex(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a user's index holds one live session and one the cache has evicted", t, func() {
+
+		connection := newFakeConnection()
+		s := NewStore(&Cache{connection: connection})
+		s.Data = signedInData("user1")
+		So(s.Store(), ShouldBeNil)
+
+		connection.SAdd(userSessionsKey("user1"), "evicted")
+
+		Convey("When I prune the index", func() {
+
+			live, err := s.pruneIndex(userSessionsKey("user1"), s.sessionKeys)
+
+			Convey("Then only the evicted session should be removed from it", func() {
+
+				So(err, ShouldBeNil)
+				So(live, ShouldResemble, []string{s.ID})
+				So(connection.sets[userSessionsKey("user1")], ShouldContainKey, s.ID)
+				So(connection.sets[userSessionsKey("user1")], ShouldNotContainKey, "evicted")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
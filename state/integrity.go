@@ -0,0 +1,72 @@
+package state
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"github.com/companieshouse/go-session-handler/config"
+)
+
+//integritySeparator separates the encoded session data from its HMAC. It never
+//appears in the base64 encoded HMAC itself.
+const integritySeparator = "."
+
+//ErrSessionTampered is returned when the stored session data doesn't match its
+//HMAC, meaning it has been edited directly in the cache.
+var ErrSessionTampered = errors.New("Session data does not match its integrity check")
+
+//sealSessionData appends an HMAC of the encoded session data, and of the cache
+//key and, for a session stored as a hash, the field it is stored under, if data
+//integrity checks are enabled. This protects the data itself, whereas the
+//cookie signature only protects the session ID, and stops sealed data being
+//copied to another key, or field, where it would still verify.
+func sealSessionData(encoded string, key string, field string) string {
+	cfg := config.Get()
+	if !cfg.DataIntegrity {
+		return encoded
+	}
+	return encoded + integritySeparator + integrityMAC(encoded, key, field, cfg.CookieSecret)
+}
+
+//openSessionData verifies and strips the HMAC appended by sealSessionData for
+//the given key and field, if data integrity checks are enabled, accepting an
+//HMAC keyed by the cookie secret or any of its fallbacks.
+func openSessionData(stored string, key string, field string) (string, error) {
+	cfg := config.Get()
+	if !cfg.DataIntegrity {
+		return stored, nil
+	}
+
+	i := strings.LastIndex(stored, integritySeparator)
+	if i < 0 {
+		return "", ErrSessionTampered
+	}
+	encoded, mac := stored[:i], []byte(stored[i+len(integritySeparator):])
+
+	for _, secret := range append([]string{cfg.CookieSecret}, cfg.CookieSecretFallbacks...) {
+		if hmac.Equal(mac, []byte(integrityMAC(encoded, key, field, secret))) {
+			return encoded, nil
+		}
+	}
+	return "", ErrSessionTampered
+}
+
+//integrityMAC returns the base64 encoded HMAC-SHA256 of the cache key, field
+//and encoded session data, keyed by a key derived from the given secret. The
+//key and field are each prefixed by their length, so that no two pairs of them
+//are written the same way.
+func integrityMAC(encoded string, key string, field string, secret string) string {
+	mac := hmac.New(sha256.New, deriveKey(secret, dataIntegrityKeyPurpose))
+	for _, part := range []string{key, field} {
+		length := make([]byte, 8)
+		binary.BigEndian.PutUint64(length, uint64(len(part)))
+		mac.Write(length)
+		mac.Write([]byte(part))
+	}
+	mac.Write([]byte(encoded))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
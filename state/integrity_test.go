@@ -0,0 +1,181 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ------------------- Routes Through sealSessionData() -------------------
+
+// TestUnitDataIntegrity - Verify that session data edited directly in the cache
+// is rejected on load when data integrity checks are enabled
+func TestUnitDataIntegrity(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given data integrity checks are enabled and a session has been stored", t, func() {
+
+		cfg := config.Get()
+		cfg.DataIntegrity = true
+		defer func() { cfg.DataIntegrity = false }()
+
+		connection := newFakeConnection()
+		cache := NewCacheWithConnection(connection)
+
+		stored := NewStore(cache)
+		stored.Data = map[string]interface{}{
+			"role":    "user",
+			"expires": uint32(time.Now().Unix() + 3600),
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in": uint16(3600),
+				},
+			},
+		}
+		So(stored.Store(), ShouldBeNil)
+		cookieValue := stored.CookieValue()
+
+		Convey("When I load it untouched", func() {
+
+			s := NewStore(cache)
+			err := s.Load(cookieValue)
+
+			Convey("Then it should load as normal", func() {
+
+				So(err, ShouldBeNil)
+				So(s.ID, ShouldEqual, stored.ID)
+				So(s.Data["role"], ShouldEqual, "user")
+			})
+		})
+
+		Convey("When its data is edited in the cache without updating the HMAC", func() {
+
+			value := connection.values[stored.ID]
+			mac := value[strings.LastIndex(value, integritySeparator):]
+
+			edited, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+				"role":    "admin",
+				"expires": uint32(time.Now().Unix() + 3600),
+			})
+			So(err, ShouldBeNil)
+			connection.values[stored.ID] = edited + mac
+
+			s := NewStore(cache)
+			err = s.Load(cookieValue)
+
+			Convey("Then it should be rejected, starting a fresh session", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data, ShouldBeEmpty)
+				So(s.ID, ShouldBeBlank)
+			})
+		})
+
+		Convey("When its sealed value is copied onto another session ID in the cache", func() {
+
+			other := NewStore(cache)
+			other.Data = map[string]interface{}{"role": "user", "expires": uint32(time.Now().Unix() + 3600)}
+			So(other.Store(), ShouldBeNil)
+
+			connection.values[other.ID] = connection.values[stored.ID]
+
+			s := NewStore(cache)
+			err := s.Load(other.CookieValue())
+
+			Convey("Then it should be rejected, since it was sealed for another key", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data, ShouldBeEmpty)
+				So(s.ID, ShouldBeBlank)
+			})
+		})
+
+		Convey("When its HMAC is stripped in the cache", func() {
+
+			value := connection.values[stored.ID]
+			connection.values[stored.ID] = value[:strings.LastIndex(value, integritySeparator)]
+
+			_, err := NewStore(cache).decodeSession(connection.values[stored.ID], stored.ID, "")
+
+			Convey("Then it should fail its integrity check", func() {
+
+				So(err, ShouldEqual, ErrSessionTampered)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitDataIntegrityHash - Verify that a field of a session stored as a hash
+// is rejected if it is copied from another session, or another field
+func TestUnitDataIntegrityHash(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given data integrity checks are enabled and two sessions have been stored as hashes", t, func() {
+
+		cfg := config.Get()
+		cfg.DataIntegrity = true
+		defer func() { cfg.DataIntegrity = false }()
+
+		connection := newFakeConnection()
+		cache := NewHashCacheWithConnection(connection)
+
+		store := func(role string) *Store {
+			s := NewStore(cache)
+			s.Data = map[string]interface{}{
+				"role":    role,
+				"team":    "user",
+				"expires": uint32(time.Now().Unix() + 3600),
+			}
+			So(s.Store(), ShouldBeNil)
+			return s
+		}
+		admin, user := store("admin"), store("user")
+
+		load := func() *Store {
+			s := NewStore(cache)
+			So(s.Load(user.CookieValue()), ShouldBeNil)
+			return s
+		}
+
+		Convey("When I load one untouched", func() {
+
+			s := load()
+
+			Convey("Then it should load as normal", func() {
+
+				So(s.Data["role"], ShouldEqual, "user")
+			})
+		})
+
+		Convey("When a field is copied to it from the other session", func() {
+
+			connection.hashes[user.ID]["role"] = connection.hashes[admin.ID]["role"]
+			s := load()
+
+			Convey("Then it should be rejected", func() {
+
+				So(s.Data, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When one of its fields is copied to another of its fields", func() {
+
+			connection.hashes[user.ID]["role"] = connection.hashes[user.ID]["team"]
+			s := load()
+
+			Convey("Then it should be rejected", func() {
+
+				So(s.Data, ShouldBeEmpty)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,100 @@
+package state
+
+import (
+	"sync"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+//keepAliveFailures is the number of consecutive failed pings after which the
+//keep-alive rebuilds the Redis client.
+const keepAliveFailures = 3
+
+//newRedisConnection creates the Connection to Redis for a Cache, both on
+//creation and when the keep-alive rebuilds it.
+var newRedisConnection = func(options *redis.Options) Connection {
+	return redisConnection{redis.NewClient(options)}
+}
+
+//keepAlive controls the background pinging of a Cache's connection.
+type keepAlive struct {
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+//close stops the pinging, waiting for it to finish.
+func (k *keepAlive) close() {
+	k.stopOnce.Do(func() {
+		close(k.stop)
+		<-k.stopped
+	})
+}
+
+//WithKeepAlive pings Redis every interval in the background. After
+//keepAliveFailures consecutive failed pings, e.g. following a network
+//partition, the Redis client is rebuilt, rather than relying on its pool to
+//cycle out the broken connections. Only a Cache created by NewCache can be
+//rebuilt; one using an existing Connection is only pinged. A non-positive
+//interval leaves the keep-alive disabled. Close should be called on shutdown
+//to stop it.
+func (c *Cache) WithKeepAlive(interval time.Duration) *Cache {
+	if interval <= 0 {
+		return c
+	}
+
+	if c.keepAlive != nil {
+		c.keepAlive.close()
+	}
+	c.keepAlive = &keepAlive{
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go c.runKeepAlive(interval, c.keepAlive)
+	return c
+}
+
+//runKeepAlive pings Redis every interval until the keep-alive is closed,
+//reconnecting after too many consecutive failures.
+func (c *Cache) runKeepAlive(interval time.Duration, k *keepAlive) {
+	defer close(k.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := c.getConnection().Ping().Result(); err != nil {
+				failures++
+			} else {
+				failures = 0
+			}
+
+			if failures >= keepAliveFailures {
+				c.reconnect()
+				failures = 0
+			}
+		case <-k.stop:
+			return
+		}
+	}
+}
+
+//reconnect replaces the Redis client with a new one built from the same
+//options, closing the old one. Requests already using the old client may fail.
+func (c *Cache) reconnect() {
+	if c.options == nil {
+		return
+	}
+
+	c.mu.Lock()
+	old := c.connection
+	c.connection = newRedisConnection(c.options)
+	c.mu.Unlock()
+
+	old.Close()
+}
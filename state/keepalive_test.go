@@ -0,0 +1,96 @@
+package state
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// unreachableConnection is a fakeConnection whose PINGs always fail, as if
+// after a network partition, and which records being closed.
+type unreachableConnection struct {
+	*fakeConnection
+	closed int64
+}
+
+func (c *unreachableConnection) Ping() *redis.StatusCmd {
+	return redis.NewStatusResult("", errors.New("connection reset by peer"))
+}
+
+func (c *unreachableConnection) Close() error {
+	atomic.AddInt64(&c.closed, 1)
+	return nil
+}
+
+// ------------------- Routes Through WithKeepAlive() -------------------
+
+// TestUnitKeepAliveReconnects - Verify that repeated failed pings rebuild the
+// Redis client, and that closing the cache stops the keep-alive
+func TestUnitKeepAliveReconnects(t *testing.T) {
+
+	Convey("Given a cache whose Redis client can no longer reach the server", t, func() {
+
+		unreachable := &unreachableConnection{fakeConnection: newFakeConnection()}
+		healthy := &pingCountingConnection{fakeConnection: newFakeConnection()}
+
+		var built int64
+		defer func(original func(*redis.Options) Connection) { newRedisConnection = original }(newRedisConnection)
+		newRedisConnection = func(options *redis.Options) Connection {
+			if atomic.AddInt64(&built, 1) == 1 {
+				return unreachable
+			}
+			return healthy
+		}
+
+		cache := NewCache("localhost:6379", 0, "")
+
+		Convey("When the keep-alive has pinged it repeatedly", func() {
+
+			cache.WithKeepAlive(time.Millisecond)
+
+			deadline := time.Now().Add(time.Second)
+			for atomic.LoadInt64(&healthy.pings) == 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			So(cache.Close(), ShouldBeNil)
+
+			Convey("Then the client should have been rebuilt and the old one closed", func() {
+
+				So(atomic.LoadInt64(&built), ShouldEqual, 2)
+				So(cache.getConnection(), ShouldEqual, healthy)
+				So(atomic.LoadInt64(&unreachable.closed), ShouldEqual, 1)
+			})
+
+			Convey("Then no more pings should be sent once the cache is closed", func() {
+
+				pings := atomic.LoadInt64(&healthy.pings)
+				time.Sleep(10 * time.Millisecond)
+
+				So(atomic.LoadInt64(&healthy.pings), ShouldEqual, pings)
+			})
+		})
+	})
+
+	Convey("Given a cache using an existing connection which fails to ping", t, func() {
+
+		unreachable := &unreachableConnection{fakeConnection: newFakeConnection()}
+		cache := NewCacheWithConnection(unreachable)
+
+		Convey("When the keep-alive has pinged it repeatedly", func() {
+
+			cache.WithKeepAlive(time.Millisecond)
+			time.Sleep(10 * time.Millisecond)
+			So(cache.Close(), ShouldBeNil)
+
+			Convey("Then the connection should be kept, as it can't be rebuilt", func() {
+
+				So(cache.getConnection(), ShouldEqual, unreachable)
+			})
+		})
+	})
+}
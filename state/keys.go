@@ -0,0 +1,21 @@
+package state
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+//Each purpose a secret is put to, other than signing session IDs, has its own
+//key derived from the secret, so that no key is shared between them.
+const (
+	cookieFallbackKeyPurpose = "go-session-handler cookie fallback"
+	dataIntegrityKeyPurpose  = "go-session-handler data integrity"
+)
+
+//deriveKey returns the 256 bit key for the given purpose, derived from the
+//secret with HMAC-SHA256.
+func deriveKey(secret string, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
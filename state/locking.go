@@ -0,0 +1,93 @@
+package state
+
+import (
+	"errors"
+	"time"
+)
+
+//ErrConcurrentModification is returned by Store, with optimistic locking
+//enabled, when the session has been stored by another request since it was
+//loaded. The caller should Reload the session, reapply its changes and retry.
+var ErrConcurrentModification = errors.New("Session was modified concurrently since it was loaded")
+
+//ErrHashOptimisticLocking is returned by Store when optimistic locking is used
+//with a Cache storing sessions as hashes, since the hash fields can't be
+//compared and set as a single value.
+var ErrHashOptimisticLocking = errors.New("Optimistic locking is not supported when storing sessions as hashes")
+
+//versionKey is the key in the session data under which the number of times the
+//session has been stored is kept, when optimistic locking is enabled.
+const versionKey = "_version"
+
+//compareAndSetScript sets KEYS[1] to ARGV[2] only if its current value is
+//ARGV[1], an empty ARGV[1] meaning that nothing is stored there, to be evicted
+//after ARGV[3] milliseconds, or never if that is 0. It returns 1 if the value
+//was set and 0 if not.
+const compareAndSetScript = `
+local current = redis.call("GET", KEYS[1])
+if (current or "") ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`
+
+//WithOptimisticLocking prevents lost updates when concurrent requests load,
+//change and store the same session. Each Store increments a '_version' counter
+//in the session data, and the session is only written if what's in the cache
+//is still exactly what this Store loaded (or nothing, for a session it hasn't
+//loaded), compared and set atomically with a Lua script. Otherwise Store
+//returns ErrConcurrentModification and leaves the cache unchanged.
+//
+//The counter makes each stored value distinct, so a session changed and then
+//changed back in between is still detected. The check replaces any
+//SetCondition, and the session isn't written in a transaction.
+func (s *Store) WithOptimisticLocking() *Store {
+	s.optimisticLocking = true
+	return s
+}
+
+//incrementVersion increments the session data's '_version' counter.
+func (s *Store) incrementVersion() {
+	s.Data[versionKey] = uint64(s.Data.Version() + 1)
+}
+
+//compareAndSetSessionData stores the Session data at key only if the value
+//currently stored there is expected, returning false with a nil error if not.
+func (c *Cache) compareAndSetSessionData(key string, expected string, value string, expiration time.Duration) (bool, error) {
+	ttl := int64(expiration / time.Millisecond)
+	result, err := c.getConnection().Eval(compareAndSetScript, []string{key}, expected, value, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+
+	set, _ := result.(int64)
+	return set == 1, nil
+}
+
+//storeSessionCAS will save the encoded session in Redis only if it hasn't been
+//stored since it was loaded.
+func (s *Store) storeSessionCAS(encodedData string) error {
+
+	key := s.sessionKey()
+	span := s.startSpan("eval", len(key))
+	start, dials := time.Now(), s.cache.dials()
+	set, err := s.cache.compareAndSetSessionData(key, s.loadedValue, encodedData, s.cacheTTL())
+	span.End(err)
+	s.observe("eval", start, dials, err)
+	s.getMetrics().ObserveRedisLatency(time.Since(start))
+	if err != nil {
+		return err
+	}
+
+	if !set {
+		return ErrConcurrentModification
+	}
+
+	s.loadedValue = encodedData
+	return s.stored()
+}
@@ -0,0 +1,94 @@
+package state
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ------------------- Routes Through WithOptimisticLocking() -------------------
+
+// TestUnitOptimisticLockingVersionMismatch - Verify that a session stored by
+// another request since it was loaded isn't overwritten, and can be stored once
+// reloaded
+func TestUnitOptimisticLockingVersionMismatch(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given two requests have loaded the same session with optimistic locking", t, func() {
+
+		cache := NewMemoryCache()
+
+		s := NewStore(cache).WithOptimisticLocking()
+		s.Data = hashSessionData("user-1")
+		So(s.Store(), ShouldBeNil)
+		So(s.Data.Version(), ShouldEqual, 1)
+
+		first := NewStore(cache).WithOptimisticLocking()
+		So(first.Load(s.CookieValue()), ShouldBeNil)
+		second := NewStore(cache).WithOptimisticLocking()
+		So(second.Load(s.CookieValue()), ShouldBeNil)
+
+		Convey("When the first stores a change", func() {
+
+			first.SetValue("step", "one")
+			So(first.Store(), ShouldBeNil)
+
+			Convey("Then the version should be incremented", func() {
+
+				So(first.Data.Version(), ShouldEqual, 2)
+			})
+
+			Convey("Then the second storing its own change should fail", func() {
+
+				second.SetValue("other", "two")
+
+				So(second.Store(), ShouldEqual, ErrConcurrentModification)
+
+				reloaded := NewStore(cache)
+				So(reloaded.Load(s.CookieValue()), ShouldBeNil)
+				So(reloaded.Data["step"], ShouldEqual, "one")
+				So(reloaded.Data, ShouldNotContainKey, "other")
+			})
+
+			Convey("Then the second should be able to store once it has reloaded", func() {
+
+				So(second.Reload(), ShouldBeNil)
+				second.SetValue("other", "two")
+
+				So(second.Store(), ShouldBeNil)
+				So(second.Data.Version(), ShouldEqual, 3)
+
+				reloaded := NewStore(cache)
+				So(reloaded.Load(s.CookieValue()), ShouldBeNil)
+				So(reloaded.Data["step"], ShouldEqual, "one")
+				So(reloaded.Data["other"], ShouldEqual, "two")
+			})
+
+			Convey("Then the first should be able to store again", func() {
+
+				first.SetValue("step", "two")
+
+				So(first.Store(), ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a session with optimistic locking on a hash cache", t, func() {
+
+		s := NewStore(NewHashCacheWithConnection(newFakeConnection())).WithOptimisticLocking()
+		s.Data = hashSessionData("user-1")
+
+		Convey("When I store it", func() {
+
+			err := s.Store()
+
+			Convey("Then it should be rejected", func() {
+
+				So(err, ShouldEqual, ErrHashOptimisticLocking)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
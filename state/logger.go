@@ -0,0 +1,56 @@
+package state
+
+import "github.com/companieshouse/chs.go/log"
+
+//Logger is the interface used to log messages from the session handler, so
+//that consumers can route them into their own structured logger.
+type Logger interface {
+	Trace(msg string, data map[string]interface{})
+	Info(msg string, data map[string]interface{})
+	Warn(msg string, data map[string]interface{})
+	Error(err error, data map[string]interface{})
+}
+
+//chsLogger is the default Logger, which logs using chs.go.
+type chsLogger struct{}
+
+//DefaultLogger returns the Logger used when none has been supplied.
+func DefaultLogger() Logger {
+	return chsLogger{}
+}
+
+//Trace logs a message about routine events, such as a request arriving
+//without a session cookie, which are usually too frequent to be worth logging.
+func (chsLogger) Trace(msg string, data map[string]interface{}) {
+	log.Trace(msg, log.Data(data))
+}
+
+//Info logs an informational message.
+func (chsLogger) Info(msg string, data map[string]interface{}) {
+	log.Info(msg, log.Data(data))
+}
+
+//Warn logs a warning. chs.go has no warning level, so it is logged as info.
+func (chsLogger) Warn(msg string, data map[string]interface{}) {
+	log.Info(msg, log.Data(data))
+}
+
+//Error logs an error.
+func (chsLogger) Error(err error, data map[string]interface{}) {
+	log.Error(err, log.Data(data))
+}
+
+//WithLogger sets the Logger used by the Store, replacing the DefaultLogger.
+func (s *Store) WithLogger(logger Logger) *Store {
+	s.logger = logger
+	return s
+}
+
+//getLogger returns the Logger set on the Store, falling back to the
+//DefaultLogger if none has been set.
+func (s *Store) getLogger() Logger {
+	if s.logger == nil {
+		return DefaultLogger()
+	}
+	return s.logger
+}
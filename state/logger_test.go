@@ -0,0 +1,114 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+type logLine struct {
+	level string
+	msg   string
+	data  map[string]interface{}
+}
+
+type capturingLogger struct {
+	lines []logLine
+}
+
+func (l *capturingLogger) Trace(msg string, data map[string]interface{}) {
+	l.lines = append(l.lines, logLine{"trace", msg, data})
+}
+
+func (l *capturingLogger) Info(msg string, data map[string]interface{}) {
+	l.lines = append(l.lines, logLine{"info", msg, data})
+}
+
+func (l *capturingLogger) Warn(msg string, data map[string]interface{}) {
+	l.lines = append(l.lines, logLine{"warn", msg, data})
+}
+
+func (l *capturingLogger) Error(err error, data map[string]interface{}) {
+	l.lines = append(l.lines, logLine{"error", err.Error(), data})
+}
+
+// TestUnitLoadLogsExpiredSession - Verify that loading an expired session logs
+// through the injected Logger rather than chs.go
+func TestUnitLoadLogsExpiredSession(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a valid session ID for an expired session", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+			"expires": uint32(time.Now().Unix() - 10),
+		})
+		So(err, ShouldBeNil)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+
+		Convey("When I load the session with a Logger set", func() {
+
+			logger := &capturingLogger{}
+			s := NewStore(&Cache{connection: connection}).WithLogger(logger)
+			err := s.Load(sessionID)
+
+			Convey("Then the expiry should be logged with the session ID", func() {
+
+				So(err, ShouldBeNil)
+				So(logger.lines, ShouldHaveLength, 1)
+				So(logger.lines[0].level, ShouldEqual, "info")
+				So(logger.lines[0].msg, ShouldEqual, "Store has expired")
+				So(logger.lines[0].data["session_id"], ShouldEqual, id)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitLoadLogsMissingCookieAtTrace - Verify that a missing or unsigned
+// session cookie, which most anonymous requests have, is only logged at trace
+func TestUnitLoadLogsMissingCookieAtTrace(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a Logger set", t, func() {
+
+		logger := &capturingLogger{}
+		s := NewStore(&Cache{connection: &mockState.Connection{}}).WithLogger(logger)
+
+		Convey("When I load a session with no cookie", func() {
+
+			So(s.Load(""), ShouldBeNil)
+
+			Convey("Then it should be logged at trace", func() {
+
+				So(logger.lines, ShouldHaveLength, 1)
+				So(logger.lines[0].level, ShouldEqual, "trace")
+			})
+		})
+
+		Convey("When I load a session with an unsigned cookie", func() {
+
+			So(s.Load(strings.Repeat("a", cookieValueLength())), ShouldBeNil)
+
+			Convey("Then it should be logged at trace", func() {
+
+				So(logger.lines, ShouldHaveLength, 1)
+				So(logger.lines[0].level, ShouldEqual, "trace")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,594 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/session"
+	redis "gopkg.in/redis.v5"
+)
+
+//NewMemoryCache will initialise a new Cache object backed by an in-memory map
+//rather than Redis, for use in tests and local development.
+func NewMemoryCache() *Cache {
+	return NewCacheWithConnection(newMemoryConnection())
+}
+
+//memoryConnection is a concurrency-safe, in-memory Connection. Like Redis,
+//strings, sets and hashes share one keyspace, and keys given an expiration are evicted
+//once it has passed.
+type memoryConnection struct {
+	mu        sync.Mutex
+	values    map[string]string
+	sets      map[string]map[string]struct{}
+	hashes    map[string]map[string]string
+	timers    map[string]*time.Timer
+	deadlines map[string]time.Time
+}
+
+//newMemoryConnection creates an empty memoryConnection.
+func newMemoryConnection() *memoryConnection {
+	return &memoryConnection{
+		values:    map[string]string{},
+		sets:      map[string]map[string]struct{}{},
+		hashes:    map[string]map[string]string{},
+		timers:    map[string]*time.Timer{},
+		deadlines: map[string]time.Time{},
+	}
+}
+
+//exists reports whether anything is stored at key. The lock must be held.
+func (m *memoryConnection) exists(key string) bool {
+	_, isValue := m.values[key]
+	_, isSet := m.sets[key]
+	_, isHash := m.hashes[key]
+	return isValue || isSet || isHash
+}
+
+//delete removes anything stored at key, along with its expiration. The lock
+//must be held.
+func (m *memoryConnection) delete(key string) bool {
+	existed := m.exists(key)
+	delete(m.values, key)
+	delete(m.sets, key)
+	delete(m.hashes, key)
+	m.persist(key)
+	return existed
+}
+
+//persist removes any expiration from key. The lock must be held.
+func (m *memoryConnection) persist(key string) {
+	if timer, ok := m.timers[key]; ok {
+		timer.Stop()
+		delete(m.timers, key)
+	}
+	delete(m.deadlines, key)
+}
+
+//expire evicts key once the expiration has passed, replacing any existing
+//expiration. The lock must be held.
+func (m *memoryConnection) expire(key string, expiration time.Duration) {
+	m.persist(key)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(expiration, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// Only evict if the expiration hasn't since been replaced or removed
+		if m.timers[key] == timer {
+			m.delete(key)
+		}
+	})
+	m.timers[key] = timer
+	m.deadlines[key] = time.Now().Add(expiration)
+}
+
+//set stores the value at key, with an expiration if it is positive. The lock
+//must be held.
+func (m *memoryConnection) set(key string, value interface{}, expiration time.Duration) {
+	m.delete(key)
+	m.values[key] = toString(value)
+	if expiration > 0 {
+		m.expire(key, expiration)
+	}
+}
+
+func (m *memoryConnection) Ping() *redis.StatusCmd {
+	return redis.NewStatusResult("PONG", nil)
+}
+
+func (m *memoryConnection) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set(key, value, expiration)
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (m *memoryConnection) SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.exists(key) {
+		return redis.NewBoolResult(false, nil)
+	}
+	m.set(key, value, expiration)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (m *memoryConnection) SetXX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.exists(key) {
+		return redis.NewBoolResult(false, nil)
+	}
+	m.set(key, value, expiration)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (m *memoryConnection) Get(key string) *redis.StringCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.values[key]
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(value, nil)
+}
+
+func (m *memoryConnection) MGet(keys ...string) *redis.SliceCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if value, ok := m.values[key]; ok {
+			values[i] = value
+		}
+	}
+	return redis.NewSliceResult(values, nil)
+}
+
+func (m *memoryConnection) Del(keys ...string) *redis.IntCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for _, key := range keys {
+		if m.delete(key) {
+			deleted++
+		}
+	}
+	return redis.NewIntResult(deleted, nil)
+}
+
+func (m *memoryConnection) Exists(key string) *redis.BoolCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return redis.NewBoolResult(m.exists(key), nil)
+}
+
+func (m *memoryConnection) Expire(key string, expiration time.Duration) *redis.BoolCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return redis.NewBoolResult(m.expireExisting(key, expiration), nil)
+}
+
+//expireExisting expires key as Expire does, deleting it at once if the
+//expiration isn't positive, and reports whether it exists. The lock must be
+//held.
+func (m *memoryConnection) expireExisting(key string, expiration time.Duration) bool {
+	if !m.exists(key) {
+		return false
+	}
+
+	if expiration <= 0 {
+		m.delete(key)
+	} else {
+		m.expire(key, expiration)
+	}
+	return true
+}
+
+//PTTL returns the time left before key expires, or, as Redis does, -1ms if it
+//never expires and -2ms if it doesn't exist.
+func (m *memoryConnection) PTTL(key string) *redis.DurationCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.exists(key) {
+		return redis.NewDurationResult(-2*time.Millisecond, nil)
+	}
+	deadline, ok := m.deadlines[key]
+	if !ok {
+		return redis.NewDurationResult(-time.Millisecond, nil)
+	}
+	return redis.NewDurationResult(time.Until(deadline), nil)
+}
+
+//Scan returns every matching key in a single page, so the returned cursor is
+//always 0.
+func (m *memoryConnection) Scan(cursor uint64, match string, count int64) *redis.ScanCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for key := range m.values {
+		if globMatch(match, key) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.sets {
+		if globMatch(match, key) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.hashes {
+		if globMatch(match, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return redis.NewScanCmdResult(keys, 0, nil)
+}
+
+//globMatch reports whether key matches the glob-style pattern as Redis matches
+//them: '*' matches any run of bytes, including '/', which session IDs may
+//contain, '?' matches any one byte, '[...]' any byte in the class, negated by a
+//leading '^' and with ranges such as 'a-z', and '\' escapes the next byte.
+func globMatch(pattern string, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if globMatch(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			end, matched := matchClass(pattern, key[0])
+			if !matched {
+				return false
+			}
+			pattern, key = pattern[end:], key[1:]
+
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		}
+	}
+	return len(key) == 0
+}
+
+//matchClass reports whether b is in the '[...]' class at the start of pattern,
+//along with the length of the class, including its closing ']' if it has one.
+func matchClass(pattern string, b byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+
+	matched := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == b
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			low, high := pattern[i], pattern[i+2]
+			if low > high {
+				low, high = high, low
+			}
+			matched = matched || (low <= b && b <= high)
+			i += 2
+		default:
+			matched = matched || pattern[i] == b
+		}
+	}
+	if i < len(pattern) {
+		i++
+	}
+	return i, matched != negate
+}
+
+func (m *memoryConnection) SAdd(key string, members ...interface{}) *redis.IntCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return redis.NewIntResult(m.sadd(key, members...), nil)
+}
+
+//sadd adds the members to the set stored at key, returning the number added.
+//The lock must be held.
+func (m *memoryConnection) sadd(key string, members ...interface{}) int64 {
+	set, ok := m.sets[key]
+	if !ok {
+		set = map[string]struct{}{}
+		m.sets[key] = set
+	}
+
+	var added int64
+	for _, member := range members {
+		if _, ok := set[toString(member)]; !ok {
+			set[toString(member)] = struct{}{}
+			added++
+		}
+	}
+	return added
+}
+
+func (m *memoryConnection) SRem(key string, members ...interface{}) *redis.IntCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return redis.NewIntResult(m.srem(key, members...), nil)
+}
+
+//srem removes the members from the set stored at key, returning the number
+//removed. The lock must be held.
+func (m *memoryConnection) srem(key string, members ...interface{}) int64 {
+	var removed int64
+	for _, member := range members {
+		if _, ok := m.sets[key][toString(member)]; ok {
+			delete(m.sets[key], toString(member))
+			removed++
+		}
+	}
+
+	// Like Redis, an empty set no longer exists
+	if set, ok := m.sets[key]; ok && len(set) == 0 {
+		m.delete(key)
+	}
+	return removed
+}
+
+func (m *memoryConnection) SMembers(key string) *redis.StringSliceCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := []string{}
+	for member := range m.sets[key] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return redis.NewStringSliceResult(members, nil)
+}
+
+func (m *memoryConnection) HMSet(key string, fields map[string]string) *redis.StatusCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hmset(key, fields)
+	return redis.NewStatusResult("OK", nil)
+}
+
+//hmset sets the fields in the hash at key. The lock must be held.
+func (m *memoryConnection) hmset(key string, fields map[string]string) {
+	hash, ok := m.hashes[key]
+	if !ok {
+		hash = map[string]string{}
+		m.hashes[key] = hash
+	}
+
+	for field, value := range fields {
+		hash[field] = value
+	}
+}
+
+func (m *memoryConnection) HGetAll(key string) *redis.StringStringMapCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fields := map[string]string{}
+	for field, value := range m.hashes[key] {
+		fields[field] = value
+	}
+	return redis.NewStringStringMapResult(fields, nil)
+}
+
+func (m *memoryConnection) HDel(key string, fields ...string) *redis.IntCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return redis.NewIntResult(m.hdel(key, fields...), nil)
+}
+
+//hdel deletes the fields from the hash at key, returning how many there were.
+//The lock must be held.
+func (m *memoryConnection) hdel(key string, fields ...string) int64 {
+	var removed int64
+	for _, field := range fields {
+		if _, ok := m.hashes[key][field]; ok {
+			delete(m.hashes[key], field)
+			removed++
+		}
+	}
+
+	// Like Redis, an empty hash no longer exists
+	if hash, ok := m.hashes[key]; ok && len(hash) == 0 {
+		m.delete(key)
+	}
+	return removed
+}
+
+//Eval can't run Lua, so only supports the scripts used by the Store, which it
+//runs natively.
+func (m *memoryConnection) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.eval(script, keys, args...)
+}
+
+//eval runs one of the scripts supported by Eval. The lock must be held.
+func (m *memoryConnection) eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	switch script {
+	case compareAndSetScript:
+		if m.values[keys[0]] != toString(args[0]) {
+			return redis.NewCmdResult(int64(0), nil)
+		}
+		ttl, _ := session.ToInt64(args[2])
+		m.set(keys[0], args[1], time.Duration(ttl)*time.Millisecond)
+		return redis.NewCmdResult(int64(1), nil)
+
+	case compareAndDeleteScript:
+		if value, ok := m.values[keys[0]]; !ok || value != toString(args[0]) {
+			return redis.NewCmdResult(int64(0), nil)
+		}
+		m.delete(keys[0])
+		return redis.NewCmdResult(int64(1), nil)
+
+	case indexAddScript:
+		existed := m.exists(keys[0])
+		m.sadd(keys[0], args[1:]...)
+
+		ms, _ := session.ToInt64(args[0])
+		ttl := time.Duration(ms) * time.Millisecond
+		deadline, expiring := m.deadlines[keys[0]]
+		if ttl <= 0 {
+			m.persist(keys[0])
+		} else if !existed || (expiring && time.Until(deadline) < ttl) {
+			m.expire(keys[0], ttl)
+		}
+		return redis.NewCmdResult(int64(1), nil)
+
+	case takeScript:
+		value, ok := m.values[keys[0]]
+		if !ok {
+			return redis.NewCmdResult(nil, redis.Nil)
+		}
+		ttl := int64(-1)
+		if deadline, ok := m.deadlines[keys[0]]; ok {
+			ttl = int64(time.Until(deadline) / time.Millisecond)
+		}
+		m.delete(keys[0])
+		return redis.NewCmdResult([]interface{}{value, ttl}, nil)
+	}
+
+	return redis.NewCmdResult(nil, fmt.Errorf("Unsupported script: %q", script))
+}
+
+//Close stops any pending expirations. The stored values are kept.
+func (m *memoryConnection) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.timers {
+		m.persist(key)
+	}
+	return nil
+}
+
+func (m *memoryConnection) TxPipeline() Transaction {
+	return &memoryTransaction{connection: m}
+}
+
+//Pipeline returns a memoryTransaction too, as applying its commands atomically
+//is no weaker than pipelining them.
+func (m *memoryConnection) Pipeline() Pipeline {
+	return &memoryTransaction{connection: m}
+}
+
+//memoryTransaction queues commands against a memoryConnection, then applies
+//them all at once, under its lock, on Exec.
+type memoryTransaction struct {
+	connection *memoryConnection
+	queued     []func()
+}
+
+func (t *memoryTransaction) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	t.queued = append(t.queued, func() { t.connection.set(key, value, expiration) })
+	return redis.NewStatusResult("QUEUED", nil)
+}
+
+func (t *memoryTransaction) SAdd(key string, members ...interface{}) *redis.IntCmd {
+	t.queued = append(t.queued, func() { t.connection.sadd(key, members...) })
+	return redis.NewIntResult(0, nil)
+}
+
+func (t *memoryTransaction) SRem(key string, members ...interface{}) *redis.IntCmd {
+	t.queued = append(t.queued, func() { t.connection.srem(key, members...) })
+	return redis.NewIntResult(0, nil)
+}
+
+func (t *memoryTransaction) Del(keys ...string) *redis.IntCmd {
+	t.queued = append(t.queued, func() {
+		for _, key := range keys {
+			t.connection.delete(key)
+		}
+	})
+	return redis.NewIntResult(0, nil)
+}
+
+func (t *memoryTransaction) HMSet(key string, fields map[string]string) *redis.StatusCmd {
+	t.queued = append(t.queued, func() { t.connection.hmset(key, fields) })
+	return redis.NewStatusResult("QUEUED", nil)
+}
+
+func (t *memoryTransaction) HDel(key string, fields ...string) *redis.IntCmd {
+	t.queued = append(t.queued, func() { t.connection.hdel(key, fields...) })
+	return redis.NewIntResult(0, nil)
+}
+
+func (t *memoryTransaction) Expire(key string, expiration time.Duration) *redis.BoolCmd {
+	t.queued = append(t.queued, func() { t.connection.expireExisting(key, expiration) })
+	return redis.NewBoolResult(false, nil)
+}
+
+func (t *memoryTransaction) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	t.queued = append(t.queued, func() { t.connection.eval(script, keys, args...) })
+	return redis.NewCmdResult(nil, nil)
+}
+
+func (t *memoryTransaction) Exec() ([]redis.Cmder, error) {
+	t.connection.mu.Lock()
+	defer t.connection.mu.Unlock()
+
+	for _, command := range t.queued {
+		command()
+	}
+	t.queued = nil
+	return nil, nil
+}
+
+//toString converts a value to the string Redis would store for it.
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,242 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// ------------------- Routes Through NewMemoryCache() -------------------
+
+// TestUnitMemoryConnection - Verify that the in-memory connection behaves like
+// Redis for setting, getting, deleting and expiring keys
+func TestUnitMemoryConnection(t *testing.T) {
+
+	Convey("Given I have an in-memory connection", t, func() {
+
+		m := newMemoryConnection()
+
+		Convey("When I set a key", func() {
+
+			So(m.Set("key", "value", 0).Err(), ShouldBeNil)
+
+			Convey("Then I should be able to get it", func() {
+
+				value, err := m.Get("key").Result()
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, "value")
+			})
+
+			Convey("And only SetXX should overwrite it", func() {
+
+				applied, _ := m.SetNX("key", "nx", 0).Result()
+				So(applied, ShouldBeFalse)
+
+				applied, _ = m.SetXX("key", "xx", 0).Result()
+				So(applied, ShouldBeTrue)
+				So(m.Get("key").Val(), ShouldEqual, "xx")
+			})
+
+			Convey("And when I delete it, it should be gone", func() {
+
+				deleted, err := m.Del("key", "missing").Result()
+				So(err, ShouldBeNil)
+				So(deleted, ShouldEqual, 1)
+
+				_, err = m.Get("key").Result()
+				So(err, ShouldEqual, redis.Nil)
+			})
+		})
+
+		Convey("When I get a key which was never set", func() {
+
+			_, err := m.Get("missing").Result()
+
+			Convey("Then redis.Nil should be returned", func() {
+
+				So(err, ShouldEqual, redis.Nil)
+			})
+		})
+
+		Convey("When I set a key with a short expiration", func() {
+
+			m.Set("key", "value", 20*time.Millisecond)
+
+			Convey("Then it should be evicted once the expiration has passed", func() {
+
+				So(m.Get("key").Val(), ShouldEqual, "value")
+				time.Sleep(60 * time.Millisecond)
+
+				_, err := m.Get("key").Result()
+				So(err, ShouldEqual, redis.Nil)
+			})
+
+			Convey("And it is set again without an expiration, it should not be evicted", func() {
+
+				m.Set("key", "persistent", 0)
+				time.Sleep(60 * time.Millisecond)
+
+				So(m.Get("key").Val(), ShouldEqual, "persistent")
+			})
+		})
+
+		Convey("When I expire an existing key", func() {
+
+			m.Set("key", "value", 0)
+			applied, err := m.Expire("key", 20*time.Millisecond).Result()
+
+			Convey("Then it should be evicted once the expiration has passed", func() {
+
+				So(err, ShouldBeNil)
+				So(applied, ShouldBeTrue)
+				time.Sleep(60 * time.Millisecond)
+
+				_, err = m.Get("key").Result()
+				So(err, ShouldEqual, redis.Nil)
+			})
+		})
+
+		Convey("When I expire a missing key", func() {
+
+			applied, err := m.Expire("missing", time.Second).Result()
+
+			Convey("Then the expiration should not be applied", func() {
+
+				So(err, ShouldBeNil)
+				So(applied, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I add members to a set", func() {
+
+			m.SAdd("set", "a", "b", "a")
+
+			Convey("Then its members should be returned once each", func() {
+
+				So(m.SMembers("set").Val(), ShouldResemble, []string{"a", "b"})
+			})
+
+			Convey("And once they're all removed the set should no longer exist", func() {
+
+				m.SRem("set", "a", "b")
+
+				keys, _, _ := m.Scan(0, "*", 10).Result()
+				So(keys, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I scan for keys, some of which contain '/'", func() {
+
+			m.Set("abcdef", "value", 0)
+			m.Set("abc/def", "value", 0)
+			m.SAdd("user:a/b:sessions", "abc/def")
+			m.HMSet("ab/hash", map[string]string{"field": "value"})
+
+			Convey("Then '*' should match across '/', as in Redis", func() {
+
+				keys, _, err := m.Scan(0, "*", 10).Result()
+				So(err, ShouldBeNil)
+				So(keys, ShouldResemble, []string{"ab/hash", "abc/def", "abcdef", "user:a/b:sessions"})
+
+				keys, _, _ = m.Scan(0, "user:*:sessions", 10).Result()
+				So(keys, ShouldResemble, []string{"user:a/b:sessions"})
+			})
+
+			Convey("Then '?', classes and escapes should match as in Redis", func() {
+
+				keys, _, _ := m.Scan(0, "abc?def", 10).Result()
+				So(keys, ShouldResemble, []string{"abc/def"})
+
+				keys, _, _ = m.Scan(0, "ab[a-c]/*", 10).Result()
+				So(keys, ShouldResemble, []string{"abc/def"})
+
+				keys, _, _ = m.Scan(0, "ab[^/]*", 10).Result()
+				So(keys, ShouldResemble, []string{"abc/def", "abcdef"})
+
+				So(globMatch(`a\*`, "a*"), ShouldBeTrue)
+				So(globMatch(`a\*`, "ab"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When I take an expiring key", func() {
+
+			m.Set("key", "value", time.Minute)
+			result, err := m.Eval(takeScript, []string{"key"}).Result()
+
+			Convey("Then its value and remaining lifetime should be returned, and it deleted", func() {
+
+				So(err, ShouldBeNil)
+				reply := result.([]interface{})
+				So(reply[0], ShouldEqual, "value")
+				So(reply[1], ShouldBeBetweenOrEqual, int64(59000), int64(60000))
+				So(m.Get("key").Err(), ShouldEqual, redis.Nil)
+
+				_, err = m.Eval(takeScript, []string{"key"}).Result()
+				So(err, ShouldEqual, redis.Nil)
+			})
+		})
+
+		Convey("When I queue commands in a transaction", func() {
+
+			m.Set("old", "value", 0)
+
+			tx := m.TxPipeline()
+			tx.Set("key", "value", 0)
+			tx.Del("old")
+			tx.SAdd("set", "a")
+
+			Convey("Then none should be applied until it is executed", func() {
+
+				So(m.Get("key").Err(), ShouldEqual, redis.Nil)
+				So(m.Get("old").Val(), ShouldEqual, "value")
+
+				_, err := tx.Exec()
+				So(err, ShouldBeNil)
+				So(m.Get("key").Val(), ShouldEqual, "value")
+				So(m.Get("old").Err(), ShouldEqual, redis.Nil)
+				So(m.SMembers("set").Val(), ShouldResemble, []string{"a"})
+			})
+		})
+	})
+}
+
+// TestUnitMemoryCacheStore - Verify that a Store can round trip a session through
+// the in-memory cache
+func TestUnitMemoryCacheStore(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I store a session in the in-memory cache", t, func() {
+
+		cache := NewMemoryCache()
+
+		stored := NewStore(cache)
+		stored.Data = map[string]interface{}{
+			"test":    "hello, world!",
+			"expires": uint32(time.Now().Unix() + 3600),
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in": uint16(3600),
+				},
+			},
+		}
+		So(stored.Store(), ShouldBeNil)
+
+		Convey("When I load it", func() {
+
+			s := NewStore(cache)
+			err := s.Load(stored.CookieValue())
+
+			Convey("Then the same session should be returned", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data["test"], ShouldEqual, "hello, world!")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,102 @@
+package state
+
+import (
+	"errors"
+	"time"
+
+	session "github.com/companieshouse/go-session-handler/session"
+)
+
+//ErrSessionNotFound is returned when there is no stored session for a validly
+//signed session ID, as is normal for a new visitor.
+var ErrSessionNotFound = errors.New("Session was not found")
+
+//SessionMeta is the metadata of a stored session, as returned by LoadMeta.
+type SessionMeta struct {
+	UserID   string
+	Expires  uint64
+	SignedIn bool
+}
+
+//LoadMeta validates the signed session ID and returns the metadata of the
+//stored session, e.g. for a gateway which needs to know who the session belongs
+//to without handling the rest of its data. Unlike Load, the session isn't
+//loaded into the Store, its last access isn't refreshed, and an invalid,
+//missing or expired session is returned as an error.
+func (s *Store) LoadMeta(sessionID string) (SessionMeta, error) {
+	if err := s.validateSessionID(sessionID); err != nil {
+		return SessionMeta{}, err
+	}
+
+	data, err := s.fetchData()
+	if err != nil {
+		return SessionMeta{}, err
+	}
+
+	// Validate against a separate Store so this one is left untouched
+	loaded := &Store{ID: s.ID, Data: data, cache: s.cache}
+	if err := loaded.validateExpiration(); err != nil {
+		return SessionMeta{}, err
+	}
+
+	meta := session.Session(data)
+	return SessionMeta{
+		UserID:   meta.GetUserID(),
+		Expires:  loaded.Expires,
+		SignedIn: meta.IsSignedIn(),
+	}, nil
+}
+
+//Exists reports whether a session is currently stored for the signed session
+//ID, e.g. for a rate limiter, without fetching or decoding it. The signature is
+//validated first, so a forged ID is reported as absent without reaching the
+//cache. Each older key version is checked in turn, as with Load. The Store is
+//left untouched.
+func (s *Store) Exists(sessionID string) (bool, error) {
+	id, err := splitCookieValue(sessionID)
+	if err != nil {
+		s.getLogger().Trace(err.Error(), nil)
+		return false, nil
+	}
+
+	for _, key := range s.sessionKeys(id) {
+		span := s.startSpan("exists", len(key))
+		start, dials := time.Now(), s.cache.dials()
+		exists, err := s.cache.sessionDataExists(key)
+		span.End(err)
+		s.observe("exists", start, dials, err)
+		s.getMetrics().ObserveRedisLatency(time.Since(start))
+		if err != nil {
+			return false, err
+		}
+
+		if exists {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+//Peek validates the signed session ID and returns the data of the stored
+//session, e.g. for admin tooling inspecting a user's session read-only. Unlike
+//Load, the Store's own ID, Data and Expires are left untouched, so it can go on
+//being used, and the session's last access isn't refreshed. The data is
+//returned as stored, even if it has expired. A missing session is returned as
+//ErrSessionNotFound.
+func (s *Store) Peek(sessionID string) (session.Session, error) {
+	id, err := splitCookieValue(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch through a copy of the Store so that this one is left untouched
+	peeker := *s
+	peeker.ID = id
+
+	data, err := peeker.fetchData()
+	if err != nil {
+		return nil, err
+	}
+	return session.Session(data), nil
+}
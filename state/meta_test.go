@@ -0,0 +1,235 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// ------------------- Routes Through LoadMeta() -------------------
+
+// TestUnitLoadMeta - Verify that the metadata of a stored session is returned
+// without loading the session into the Store
+func TestUnitLoadMeta(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a signed in session is stored", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		expires := uint32(time.Now().Unix() + 600)
+		data := signedInData("user-1")
+		data["expires"] = expires
+		data["signin_info"].(map[string]interface{})["signed_in"] = int8(1)
+
+		encoded, err := MsgPackSerializer{}.Encode(data)
+		So(err, ShouldBeNil)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+
+		Convey("When I load its metadata", func() {
+
+			s := NewStore(&Cache{connection: connection})
+			meta, err := s.LoadMeta(sessionID)
+
+			Convey("Then the user ID, expiry and signed in state should be returned", func() {
+
+				So(err, ShouldBeNil)
+				So(meta, ShouldResemble, SessionMeta{UserID: "user-1", Expires: uint64(expires), SignedIn: true})
+				So(s.Data, ShouldBeNil)
+			})
+		})
+
+		Convey("When I load its metadata with an invalid signature", func() {
+
+			s := NewStore(&Cache{connection: connection})
+			_, err := s.LoadMeta(id + strings.Repeat("x", signatureLength()))
+
+			Convey("Then it should be rejected without reading the cache", func() {
+
+				So(err, ShouldNotBeNil)
+				connection.AssertNotCalled(t, "Get", id)
+			})
+		})
+	})
+
+	Convey("Given no session is stored", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult("", redis.Nil))
+
+		Convey("When I load its metadata", func() {
+
+			_, err := NewStore(&Cache{connection: connection}).LoadMeta(id + generateSignature(id))
+
+			Convey("Then ErrSessionNotFound should be returned", func() {
+
+				So(err, ShouldEqual, ErrSessionNotFound)
+			})
+		})
+	})
+
+	Convey("Given an expired session is stored", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+
+		encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+			"expires": uint32(time.Now().Unix() - 10),
+		})
+		So(err, ShouldBeNil)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+
+		Convey("When I load its metadata", func() {
+
+			_, err := NewStore(&Cache{connection: connection}).LoadMeta(id + generateSignature(id))
+
+			Convey("Then an expiry error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Store has expired")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through Exists() -------------------
+
+// TestUnitExists - Verify that a session's presence is reported without
+// fetching it, and that forged IDs never reach the cache
+func TestUnitExists(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given one session is stored and another isn't", t, func() {
+
+		stored := strings.Repeat("a", signatureStart())
+		missing := strings.Repeat("b", signatureStart())
+
+		connection := &mockState.Connection{}
+		connection.On("Exists", stored).Return(redis.NewBoolResult(true, nil))
+		connection.On("Exists", missing).Return(redis.NewBoolResult(false, nil))
+
+		s := NewStore(&Cache{connection: connection})
+
+		Convey("When I check whether the stored session exists", func() {
+
+			exists, err := s.Exists(stored + generateSignature(stored))
+
+			Convey("Then it should exist, without being fetched", func() {
+
+				So(err, ShouldBeNil)
+				So(exists, ShouldBeTrue)
+				connection.AssertNotCalled(t, "Get", stored)
+				So(s.ID, ShouldBeBlank)
+			})
+		})
+
+		Convey("When I check whether the missing session exists", func() {
+
+			exists, err := s.Exists(missing + generateSignature(missing))
+
+			Convey("Then it should not exist", func() {
+
+				So(err, ShouldBeNil)
+				So(exists, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I check whether a session with an invalid signature exists", func() {
+
+			exists, err := s.Exists(stored + strings.Repeat("x", signatureLength()))
+
+			Convey("Then it should not exist, without reading the cache", func() {
+
+				So(err, ShouldBeNil)
+				So(exists, ShouldBeFalse)
+				connection.AssertNotCalled(t, "Exists", stored)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through Peek() -------------------
+
+// TestUnitPeek - Verify that a stored session's data is returned without
+// changing the Store's own session
+func TestUnitPeek(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a Store has loaded its own session, and another session is stored", t, func() {
+
+		cache := NewCacheWithConnection(newFakeConnection())
+
+		other := NewStore(cache)
+		other.Data = signedInData("user-2")
+		other.Data["expires"] = time.Now().Unix() + 600
+		So(other.Store(), ShouldBeNil)
+
+		own := NewStore(cache)
+		own.Data = signedInData("user-1")
+		own.Data["expires"] = time.Now().Unix() + 600
+		So(own.Store(), ShouldBeNil)
+
+		s := NewStore(cache)
+		So(s.Load(own.CookieValue()), ShouldBeNil)
+
+		id, expires, data := s.ID, s.Expires, s.Data
+
+		Convey("When I peek at the other session", func() {
+
+			peeked, err := s.Peek(other.CookieValue())
+
+			Convey("Then its data should be returned and the Store's own fields left untouched", func() {
+
+				So(err, ShouldBeNil)
+				So(peeked.GetUserID(), ShouldEqual, "user-2")
+				So(s.ID, ShouldEqual, id)
+				So(s.Expires, ShouldEqual, expires)
+				So(s.Data, ShouldResemble, data)
+				So(s.Data.GetUserID(), ShouldEqual, "user-1")
+			})
+		})
+
+		Convey("When I peek at a session which isn't stored", func() {
+
+			missing := strings.Repeat("z", signatureStart())
+			_, err := s.Peek(missing + generateSignature(missing))
+
+			Convey("Then ErrSessionNotFound should be returned", func() {
+
+				So(err, ShouldEqual, ErrSessionNotFound)
+				So(s.ID, ShouldEqual, id)
+			})
+		})
+
+		Convey("When I peek with an invalid signature", func() {
+
+			_, err := s.Peek(other.ID + strings.Repeat("x", signatureLength()))
+
+			Convey("Then ErrInvalidSignature should be wrapped", func() {
+
+				So(unwrap(err), ShouldEqual, ErrInvalidSignature)
+				So(s.ID, ShouldEqual, id)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,55 @@
+package state
+
+import "time"
+
+//MetricLabels are the only labels, with their only values, which a Metrics
+//adapter needs to tell apart the calls it receives: loads are a "load"
+//operation with a "hit", "miss" or "expired" outcome, stores a "store"
+//operation with an "ok" outcome, and latencies a "redis" operation. Each is a
+//small fixed set so that the number of series stays bounded. Nothing derived
+//from a session, such as its ID or user ID, is passed to Metrics, and must
+//never be used as a label.
+var MetricLabels = map[string][]string{
+	"operation": {"load", "store", "redis"},
+	"outcome":   {"hit", "miss", "expired", "ok"},
+}
+
+//Metrics receives counters and timings from a Store, so that session behaviour
+//can be exported to a monitoring system such as Prometheus. Its methods take no
+//session content, so adapters can only label them from MetricLabels.
+type Metrics interface {
+	// IncLoadHit is called when a loaded session was found and is still valid
+	IncLoadHit()
+	// IncLoadMiss is called when a loaded session wasn't found in the cache
+	IncLoadMiss()
+	// IncStore is called when a session has been stored in the cache
+	IncStore()
+	// IncExpired is called when a loaded session had expired or been idle too long
+	IncExpired()
+	// ObserveRedisLatency is called with the duration of each Redis get or set
+	ObserveRedisLatency(d time.Duration)
+}
+
+//noopMetrics is the default Metrics, which discards everything.
+type noopMetrics struct{}
+
+func (noopMetrics) IncLoadHit()                         {}
+func (noopMetrics) IncLoadMiss()                        {}
+func (noopMetrics) IncStore()                           {}
+func (noopMetrics) IncExpired()                         {}
+func (noopMetrics) ObserveRedisLatency(d time.Duration) {}
+
+//WithMetrics sets the Metrics reported to by the Store.
+func (s *Store) WithMetrics(metrics Metrics) *Store {
+	s.metrics = metrics
+	return s
+}
+
+//getMetrics returns the Metrics set on the Store, falling back to a no-op
+//implementation if none has been set.
+func (s *Store) getMetrics() Metrics {
+	if s.metrics == nil {
+		return noopMetrics{}
+	}
+	return s.metrics
+}
@@ -0,0 +1,171 @@
+package state
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	redis "gopkg.in/redis.v5"
+)
+
+type countingMetrics struct {
+	hits, misses, stores, expired, latencies int
+}
+
+func (m *countingMetrics) IncLoadHit()                         { m.hits++ }
+func (m *countingMetrics) IncLoadMiss()                        { m.misses++ }
+func (m *countingMetrics) IncStore()                           { m.stores++ }
+func (m *countingMetrics) IncExpired()                         { m.expired++ }
+func (m *countingMetrics) ObserveRedisLatency(d time.Duration) { m.latencies++ }
+
+// ------------------- Routes Through WithMetrics() -------------------
+
+// TestUnitMetricsLoad - Verify that the correct hooks fire when a loaded session
+// is found, missing or expired
+func TestUnitMetricsLoad(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a valid session ID", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		connection := &mockState.Connection{}
+		metrics := &countingMetrics{}
+
+		Convey("When the session is found and still valid", func() {
+
+			encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+				"expires": uint32(time.Now().Unix() + 600),
+			})
+			So(err, ShouldBeNil)
+			connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+
+			err = NewStore(&Cache{connection: connection}).WithMetrics(metrics).Load(sessionID)
+
+			Convey("Then only a hit should be counted", func() {
+
+				So(err, ShouldBeNil)
+				So(*metrics, ShouldResemble, countingMetrics{hits: 1, latencies: 1})
+			})
+		})
+
+		Convey("When the session isn't in the cache", func() {
+
+			connection.On("Get", id).Return(redis.NewStringResult("", redis.Nil))
+
+			err := NewStore(&Cache{connection: connection}).WithMetrics(metrics).Load(sessionID)
+
+			Convey("Then only a miss should be counted", func() {
+
+				So(err, ShouldBeNil)
+				So(*metrics, ShouldResemble, countingMetrics{misses: 1, latencies: 1})
+			})
+		})
+
+		Convey("When the session has expired", func() {
+
+			encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+				"expires": uint32(time.Now().Unix() - 10),
+			})
+			So(err, ShouldBeNil)
+			connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+
+			err = NewStore(&Cache{connection: connection}).WithMetrics(metrics).Load(sessionID)
+
+			Convey("Then only an expiry should be counted", func() {
+
+				So(err, ShouldBeNil)
+				So(*metrics, ShouldResemble, countingMetrics{expired: 1, latencies: 1})
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitMetricsStore - Verify that storing a session counts a store
+func TestUnitMetricsStore(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a session to store", t, func() {
+
+		connection := &mockState.Connection{}
+		connection.On("Set", mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration")).
+			Return(redis.NewStatusResult("OK", nil))
+
+		metrics := &countingMetrics{}
+		s := NewStore(&Cache{connection: connection}).WithMetrics(metrics)
+		s.Data = map[string]interface{}{
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in": uint16(123),
+				},
+			},
+		}
+
+		Convey("When I store the session", func() {
+
+			err := s.Store()
+
+			Convey("Then a store and its latency should be counted", func() {
+
+				So(err, ShouldBeNil)
+				So(*metrics, ShouldResemble, countingMetrics{stores: 1, latencies: 1})
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitMetricsLabelsBounded - Verify that the metric labels are a fixed,
+// bounded set and that nothing derived from a session can reach Metrics
+func TestUnitMetricsLabelsBounded(t *testing.T) {
+
+	Convey("Given the documented metric labels", t, func() {
+
+		Convey("Then only the operation and outcome labels should be used", func() {
+
+			So(MetricLabels, ShouldHaveLength, 2)
+			So(MetricLabels, ShouldContainKey, "operation")
+			So(MetricLabels, ShouldContainKey, "outcome")
+		})
+
+		Convey("Then each label should have a small set of distinct values", func() {
+
+			for _, values := range MetricLabels {
+				So(len(values), ShouldBeBetweenOrEqual, 1, 10)
+
+				seen := map[string]bool{}
+				for _, value := range values {
+					So(seen[value], ShouldBeFalse)
+					seen[value] = true
+				}
+			}
+		})
+	})
+
+	Convey("Given the Metrics interface", t, func() {
+
+		metricsType := reflect.TypeOf((*Metrics)(nil)).Elem()
+
+		Convey("Then no method should accept a value which could carry session content", func() {
+
+			durationType := reflect.TypeOf(time.Duration(0))
+			for i := 0; i < metricsType.NumMethod(); i++ {
+				method := metricsType.Method(i)
+				for j := 0; j < method.Type.NumIn(); j++ {
+					So(method.Type.In(j), ShouldEqual, durationType)
+				}
+			}
+		})
+	})
+}
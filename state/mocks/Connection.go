@@ -11,6 +11,20 @@ type Connection struct {
 	mock.Mock
 }
 
+// Close provides a mock function with given fields:
+func (_m *Connection) Close() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Del provides a mock function with given fields: key
 func (_m *Connection) Del(key ...string) *redis.IntCmd {
 	_va := make([]interface{}, len(key))
@@ -33,6 +47,57 @@ func (_m *Connection) Del(key ...string) *redis.IntCmd {
 	return r0
 }
 
+// Eval provides a mock function with given fields: script, keys, args
+func (_m *Connection) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	var _ca []interface{}
+	_ca = append(_ca, script, keys)
+	_ca = append(_ca, args...)
+	ret := _m.Called(_ca...)
+
+	var r0 *redis.Cmd
+	if rf, ok := ret.Get(0).(func(string, []string, ...interface{}) *redis.Cmd); ok {
+		r0 = rf(script, keys, args...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.Cmd)
+		}
+	}
+
+	return r0
+}
+
+// Exists provides a mock function with given fields: key
+func (_m *Connection) Exists(key string) *redis.BoolCmd {
+	ret := _m.Called(key)
+
+	var r0 *redis.BoolCmd
+	if rf, ok := ret.Get(0).(func(string) *redis.BoolCmd); ok {
+		r0 = rf(key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.BoolCmd)
+		}
+	}
+
+	return r0
+}
+
+// Expire provides a mock function with given fields: key, expiration
+func (_m *Connection) Expire(key string, expiration time.Duration) *redis.BoolCmd {
+	ret := _m.Called(key, expiration)
+
+	var r0 *redis.BoolCmd
+	if rf, ok := ret.Get(0).(func(string, time.Duration) *redis.BoolCmd); ok {
+		r0 = rf(key, expiration)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.BoolCmd)
+		}
+	}
+
+	return r0
+}
+
 // Get provides a mock function with given fields: key
 func (_m *Connection) Get(key string) *redis.StringCmd {
 	ret := _m.Called(key)
@@ -49,6 +114,115 @@ func (_m *Connection) Get(key string) *redis.StringCmd {
 	return r0
 }
 
+// MGet provides a mock function with given fields: keys
+func (_m *Connection) MGet(keys ...string) *redis.SliceCmd {
+	_va := make([]interface{}, len(keys))
+	for _i := range keys {
+		_va[_i] = keys[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *redis.SliceCmd
+	if rf, ok := ret.Get(0).(func(...string) *redis.SliceCmd); ok {
+		r0 = rf(keys...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.SliceCmd)
+		}
+	}
+
+	return r0
+}
+
+// HDel provides a mock function with given fields: key, fields
+func (_m *Connection) HDel(key string, fields ...string) *redis.IntCmd {
+	_va := make([]interface{}, len(fields))
+	for _i := range fields {
+		_va[_i] = fields[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, key)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *redis.IntCmd
+	if rf, ok := ret.Get(0).(func(string, ...string) *redis.IntCmd); ok {
+		r0 = rf(key, fields...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.IntCmd)
+		}
+	}
+
+	return r0
+}
+
+// HGetAll provides a mock function with given fields: key
+func (_m *Connection) HGetAll(key string) *redis.StringStringMapCmd {
+	ret := _m.Called(key)
+
+	var r0 *redis.StringStringMapCmd
+	if rf, ok := ret.Get(0).(func(string) *redis.StringStringMapCmd); ok {
+		r0 = rf(key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.StringStringMapCmd)
+		}
+	}
+
+	return r0
+}
+
+// HMSet provides a mock function with given fields: key, fields
+func (_m *Connection) HMSet(key string, fields map[string]string) *redis.StatusCmd {
+	ret := _m.Called(key, fields)
+
+	var r0 *redis.StatusCmd
+	if rf, ok := ret.Get(0).(func(string, map[string]string) *redis.StatusCmd); ok {
+		r0 = rf(key, fields)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.StatusCmd)
+		}
+	}
+
+	return r0
+}
+
+// PTTL provides a mock function with given fields: key
+func (_m *Connection) PTTL(key string) *redis.DurationCmd {
+	ret := _m.Called(key)
+
+	var r0 *redis.DurationCmd
+	if rf, ok := ret.Get(0).(func(string) *redis.DurationCmd); ok {
+		r0 = rf(key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.DurationCmd)
+		}
+	}
+
+	return r0
+}
+
+// Ping provides a mock function with given fields:
+func (_m *Connection) Ping() *redis.StatusCmd {
+	ret := _m.Called()
+
+	var r0 *redis.StatusCmd
+	if rf, ok := ret.Get(0).(func() *redis.StatusCmd); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.StatusCmd)
+		}
+	}
+
+	return r0
+}
+
 // Set provides a mock function with given fields: key, value, expiration
 func (_m *Connection) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
 	ret := _m.Called(key, value, expiration)
@@ -64,3 +238,105 @@ func (_m *Connection) Set(key string, value interface{}, expiration time.Duratio
 
 	return r0
 }
+
+// SAdd provides a mock function with given fields: key, members
+func (_m *Connection) SAdd(key string, members ...interface{}) *redis.IntCmd {
+	var _ca []interface{}
+	_ca = append(_ca, key)
+	_ca = append(_ca, members...)
+	ret := _m.Called(_ca...)
+
+	var r0 *redis.IntCmd
+	if rf, ok := ret.Get(0).(func(string, ...interface{}) *redis.IntCmd); ok {
+		r0 = rf(key, members...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.IntCmd)
+		}
+	}
+
+	return r0
+}
+
+// SMembers provides a mock function with given fields: key
+func (_m *Connection) SMembers(key string) *redis.StringSliceCmd {
+	ret := _m.Called(key)
+
+	var r0 *redis.StringSliceCmd
+	if rf, ok := ret.Get(0).(func(string) *redis.StringSliceCmd); ok {
+		r0 = rf(key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.StringSliceCmd)
+		}
+	}
+
+	return r0
+}
+
+// SRem provides a mock function with given fields: key, members
+func (_m *Connection) SRem(key string, members ...interface{}) *redis.IntCmd {
+	var _ca []interface{}
+	_ca = append(_ca, key)
+	_ca = append(_ca, members...)
+	ret := _m.Called(_ca...)
+
+	var r0 *redis.IntCmd
+	if rf, ok := ret.Get(0).(func(string, ...interface{}) *redis.IntCmd); ok {
+		r0 = rf(key, members...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.IntCmd)
+		}
+	}
+
+	return r0
+}
+
+// Scan provides a mock function with given fields: cursor, match, count
+func (_m *Connection) Scan(cursor uint64, match string, count int64) *redis.ScanCmd {
+	ret := _m.Called(cursor, match, count)
+
+	var r0 *redis.ScanCmd
+	if rf, ok := ret.Get(0).(func(uint64, string, int64) *redis.ScanCmd); ok {
+		r0 = rf(cursor, match, count)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.ScanCmd)
+		}
+	}
+
+	return r0
+}
+
+// SetNX provides a mock function with given fields: key, value, expiration
+func (_m *Connection) SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	ret := _m.Called(key, value, expiration)
+
+	var r0 *redis.BoolCmd
+	if rf, ok := ret.Get(0).(func(string, interface{}, time.Duration) *redis.BoolCmd); ok {
+		r0 = rf(key, value, expiration)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.BoolCmd)
+		}
+	}
+
+	return r0
+}
+
+// SetXX provides a mock function with given fields: key, value, expiration
+func (_m *Connection) SetXX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	ret := _m.Called(key, value, expiration)
+
+	var r0 *redis.BoolCmd
+	if rf, ok := ret.Get(0).(func(string, interface{}, time.Duration) *redis.BoolCmd); ok {
+		r0 = rf(key, value, expiration)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*redis.BoolCmd)
+		}
+	}
+
+	return r0
+}
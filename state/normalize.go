@@ -0,0 +1,72 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/companieshouse/go-session-handler/session"
+)
+
+//int64Keys are the keys in the session data holding times, or periods, in
+//seconds, and counts. They are written as int64, which every encoder can
+//represent, but older versions wrote them as uint32 or uint64, so they are
+//coerced to int64 on decode.
+var int64Keys = []string{
+	"expires", "last_access", "created_at", "expiration_period", "renewal_count", schemaVersionKey,
+}
+
+//normalizeSession coerces the decoded session data, in place, into the types
+//written by Go: strings, rather than []byte, and map[string]interface{}, rather
+//than map[interface{}]interface{}, however deeply nested. Sessions written by
+//other languages, e.g. Python writing byte strings, otherwise decode to types
+//the session accessors can't assert. It also coerces the values stored under
+//int64Keys to int64, whichever integer type they were decoded as.
+func normalizeSession(data map[string]interface{}) {
+	for key, value := range data {
+		data[key] = normalizeValue(value)
+	}
+
+	for _, key := range int64Keys {
+		if value, ok := session.ToInt64(data[key]); ok {
+			data[key] = value
+		}
+	}
+}
+
+//normalizeValue returns the value with any []byte coerced to a string, and any
+//map[interface{}]interface{} to a map[string]interface{}, recursing into maps
+//and slices. Maps and slices of the expected types are normalized in place.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case map[string]interface{}:
+		for key, nested := range v {
+			v[key] = normalizeValue(nested)
+		}
+		return v
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			normalized[normalizeKey(key)] = normalizeValue(nested)
+		}
+		return normalized
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = normalizeValue(nested)
+		}
+		return v
+	}
+	return value
+}
+
+//normalizeKey returns a decoded map key as a string, formatting any key which
+//is neither a string nor a []byte.
+func normalizeKey(key interface{}) string {
+	switch k := key.(type) {
+	case string:
+		return k
+	case []byte:
+		return string(k)
+	}
+	return fmt.Sprint(key)
+}
@@ -0,0 +1,154 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/encoding"
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/vmihailenco/msgpack"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// ------------------- Routes Through normalizeSession() -------------------
+
+// TestUnitLoadLegacyNumericTypes - Verify that sessions stored with their times
+// and counts in any of the legacy numeric types still load, with them as int64
+func TestUnitLoadLegacyNumericTypes(t *testing.T) {
+
+	initConfig()
+
+	now := time.Now().Unix()
+
+	legacyTypes := map[string]func(int64) interface{}{
+		"uint32": func(v int64) interface{} { return uint32(v) },
+		"uint64": func(v int64) interface{} { return uint64(v) },
+		"int64":  func(v int64) interface{} { return v },
+	}
+
+	for name, legacy := range legacyTypes {
+
+		Convey(fmt.Sprintf("Given a session stored with its times and counts as %s", name), t, func() {
+
+			id := strings.Repeat("a", signatureStart())
+
+			encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+				"expires":           legacy(now + 600),
+				"last_access":       legacy(now - 10),
+				"created_at":        legacy(now - 100),
+				"expiration_period": legacy(600),
+				"renewal_count":     legacy(3),
+				"schema_version":    legacy(int64(currentSchemaVersion())),
+			})
+			So(err, ShouldBeNil)
+
+			connection := &mockState.Connection{}
+			connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+
+			Convey("When I load it", func() {
+
+				s := NewStore(&Cache{connection: connection})
+				err := s.Load(id + generateSignature(id))
+
+				Convey("Then it should load, with its times and counts as int64", func() {
+
+					So(err, ShouldBeNil)
+					So(s.Expires, ShouldEqual, uint64(now+600))
+					So(s.Data["expires"], ShouldHaveSameTypeAs, int64(0))
+					So(s.Data["expires"], ShouldEqual, now+600)
+					So(s.Data["last_access"], ShouldHaveSameTypeAs, int64(0))
+					So(s.Data["created_at"], ShouldResemble, now-100)
+					So(s.Data["expiration_period"], ShouldResemble, int64(600))
+					So(s.Data["renewal_count"], ShouldResemble, int64(3))
+					So(s.Data["schema_version"], ShouldResemble, int64(currentSchemaVersion()))
+				})
+			})
+		})
+	}
+
+	cleanupConfig()
+}
+
+// TestUnitLoadByteStringSession - Verify that a session written with byte string
+// keys and values, as Python writes them, loads with strings and string keyed
+// maps throughout
+func TestUnitLoadByteStringSession(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a session written with byte string keys and values", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+
+		// signin_info: {access_token: {access_token: "token"}, scopes: ["a"]},
+		// with every string written as bytes, as msgpack bin
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		So(enc.EncodeMapLen(2), ShouldBeNil)
+		So(enc.EncodeBytes([]byte("expires")), ShouldBeNil)
+		So(enc.EncodeInt(time.Now().Unix()+600), ShouldBeNil)
+		So(enc.EncodeBytes([]byte("signin_info")), ShouldBeNil)
+		So(enc.EncodeMapLen(2), ShouldBeNil)
+		So(enc.EncodeBytes([]byte("access_token")), ShouldBeNil)
+		So(enc.EncodeMapLen(1), ShouldBeNil)
+		So(enc.EncodeBytes([]byte("access_token")), ShouldBeNil)
+		So(enc.EncodeBytes([]byte("token")), ShouldBeNil)
+		So(enc.EncodeBytes([]byte("scopes")), ShouldBeNil)
+		So(enc.EncodeArrayLen(1), ShouldBeNil)
+		So(enc.EncodeBytes([]byte("a")), ShouldBeNil)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult(encoding.EncodeBase64(buf.Bytes()), nil))
+
+		Convey("When I load it", func() {
+
+			s := NewStore(&Cache{connection: connection})
+			err := s.Load(id + generateSignature(id))
+
+			Convey("Then its access token should be readable as a string", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data.GetAccessToken(), ShouldEqual, "token")
+
+				signinInfo := s.Data["signin_info"].(map[string]interface{})
+				So(signinInfo["scopes"], ShouldResemble, []interface{}{"a"})
+			})
+		})
+	})
+
+	Convey("Given decoded data containing maps keyed by interface{}", t, func() {
+
+		data := map[string]interface{}{
+			"signin_info": map[interface{}]interface{}{
+				"access_token": map[interface{}]interface{}{
+					"access_token": []byte("token"),
+				},
+				int64(1): "one",
+			},
+			"history": []interface{}{map[interface{}]interface{}{"page": []byte("basket")}},
+		}
+
+		Convey("When I normalize it", func() {
+
+			normalizeSession(data)
+
+			Convey("Then every map should be keyed by string, and every value a string", func() {
+
+				So(data, ShouldResemble, map[string]interface{}{
+					"signin_info": map[string]interface{}{
+						"access_token": map[string]interface{}{"access_token": "token"},
+						"1":            "one",
+					},
+					"history": []interface{}{map[string]interface{}{"page": "basket"}},
+				})
+			})
+		})
+	})
+
+	cleanupConfig()
+}
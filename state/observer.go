@@ -0,0 +1,39 @@
+package state
+
+import "time"
+
+//Observer is notified of every Redis operation performed by a Store, for
+//instrumentation purposes such as latency analysis.
+type Observer interface {
+	ObserveOperation(op Operation)
+}
+
+//Operation describes a single Redis operation performed by a Store.
+type Operation struct {
+	// Name is the Redis command performed, e.g. "get" or "set"
+	Name string
+	// Duration is how long the operation took to complete
+	Duration time.Duration
+	// Cold is true if a fresh connection was dialed while the operation was in
+	// progress, rather than a pooled one being reused. Under concurrent load
+	// a dial made on behalf of another operation may also be counted.
+	Cold bool
+	// Err is the error returned by the operation, if any. Note this will be
+	// redis.Nil for a get which found no session.
+	Err error
+}
+
+//observe notifies the Store's Observer, if it has one, of an operation which
+//began at start, when the Cache had dialed the given number of connections.
+func (s *Store) observe(name string, start time.Time, dials uint64, err error) {
+	if s.observer == nil {
+		return
+	}
+
+	s.observer.ObserveOperation(Operation{
+		Name:     name,
+		Duration: time.Since(start),
+		Cold:     s.cache.dials() > dials,
+		Err:      err,
+	})
+}
@@ -0,0 +1,84 @@
+package state
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	redis "gopkg.in/redis.v5"
+)
+
+type recordingObserver struct {
+	operations []Operation
+}
+
+func (o *recordingObserver) ObserveOperation(op Operation) {
+	o.operations = append(o.operations, op)
+}
+
+// ------------------- Routes Through observe() -------------------
+
+// TestUnitObserveColdAndWarmConnections - Verify that an operation is classified as
+// cold only when a connection was dialed while it was in progress
+func TestUnitObserveColdAndWarmConnections(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a Store with an observer and a cache using a mock dialer", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+
+		connection := &mockState.Connection{}
+		cache := &Cache{connection: connection}
+
+		dialed := 0
+		dial := cache.countingDialer(func() (net.Conn, error) {
+			dialed++
+			return nil, nil
+		})
+
+		observer := &recordingObserver{}
+		s := NewStore(cache).WithObserver(observer)
+		s.ID = id
+
+		Convey("When the Redis client has to dial a new connection for the operation", func() {
+
+			connection.On("Get", id).
+				Run(func(args mock.Arguments) { dial() }).
+				Return(redis.NewStringResult("", nil))
+
+			_, err := s.fetchSession()
+
+			Convey("Then the operation should be observed as cold", func() {
+
+				So(err, ShouldBeNil)
+				So(dialed, ShouldEqual, 1)
+				So(len(observer.operations), ShouldEqual, 1)
+				So(observer.operations[0].Name, ShouldEqual, "get")
+				So(observer.operations[0].Cold, ShouldBeTrue)
+			})
+		})
+
+		Convey("When the Redis client reuses a pooled connection for the operation", func() {
+
+			connection.On("Get", id).Return(redis.NewStringResult("", redis.Nil))
+
+			_, err := s.fetchSession()
+
+			Convey("Then the operation should be observed as warm", func() {
+
+				So(err, ShouldEqual, ErrSessionNotFound)
+				So(dialed, ShouldEqual, 0)
+				So(len(observer.operations), ShouldEqual, 1)
+				So(observer.operations[0].Cold, ShouldBeFalse)
+				So(observer.operations[0].Err, ShouldEqual, redis.Nil)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,41 @@
+package state
+
+//LoadOutcome describes what loading a session found, since Load starts a fresh
+//session, without an error, in every case but a failure of the cache.
+type LoadOutcome int
+
+const (
+	//LoadNotFound means no session was stored under the ID, or no cookie value
+	//was given, so a fresh session was started.
+	LoadNotFound LoadOutcome = iota
+	//LoadLoaded means an existing, valid session was loaded.
+	LoadLoaded
+	//LoadExpired means the session had expired, been idle or renewed for too
+	//long, or had its token family revoked, so a fresh session was started.
+	LoadExpired
+	//LoadInvalidSignature means the cookie value was malformed, its signature
+	//didn't match, or the stored session had been tampered with, so a fresh
+	//session was started.
+	LoadInvalidSignature
+)
+
+//String returns the name of the outcome, e.g. for logging.
+func (o LoadOutcome) String() string {
+	switch o {
+	case LoadLoaded:
+		return "loaded"
+	case LoadExpired:
+		return "expired"
+	case LoadInvalidSignature:
+		return "invalid_signature"
+	}
+	return "not_found"
+}
+
+//LoadResult loads a session in the same way as Load, also returning what it
+//found, so that a handler can e.g. tell the user their session expired only
+//when it has. The outcome is only meaningful if the error is nil.
+func (s *Store) LoadResult(sessionID string) (LoadOutcome, error) {
+	err := s.Load(sessionID)
+	return s.loadOutcome, err
+}
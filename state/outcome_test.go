@@ -0,0 +1,84 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ------------------- Routes Through LoadResult() -------------------
+
+// TestUnitLoadResult - Verify that loading reports whether the session was
+// loaded, not found, expired or had an invalid signature
+func TestUnitLoadResult(t *testing.T) {
+
+	initConfig()
+
+	cfg := config.Get()
+	defaultExpiration := cfg.DefaultExpiration
+	cfg.DefaultExpiration = "600"
+	defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+	Convey("Given a cache holding a live and an expired session", t, func() {
+
+		cache := NewMemoryCache()
+
+		live := NewStore(cache)
+		live.Data = map[string]interface{}{"page": "home"}
+		So(live.Store(), ShouldBeNil)
+
+		expired := NewStore(cache)
+		expired.Data = map[string]interface{}{"expires": time.Now().Unix() - 10}
+		So(expired.Store(), ShouldBeNil)
+
+		missing := strings.Repeat("a", signatureStart())
+
+		outcomes := []struct {
+			name        string
+			cookieValue string
+			expected    LoadOutcome
+		}{
+			{"the live session", live.CookieValue(), LoadLoaded},
+			{"a session which was never stored", missing + generateSignature(missing), LoadNotFound},
+			{"no cookie value", "", LoadNotFound},
+			{"the expired session", expired.CookieValue(), LoadExpired},
+			{"a forged signature", missing + strings.Repeat("x", signatureLength()), LoadInvalidSignature},
+			{"a malformed cookie value", "short", LoadInvalidSignature},
+		}
+
+		for _, outcome := range outcomes {
+
+			Convey("When I load "+outcome.name, func() {
+
+				s := NewStore(cache)
+				result, err := s.LoadResult(outcome.cookieValue)
+
+				Convey("Then the outcome should be "+outcome.expected.String(), func() {
+
+					So(err, ShouldBeNil)
+					So(result, ShouldEqual, outcome.expected)
+				})
+			})
+		}
+
+		Convey("When I load the live session after an invalid one with the same Store", func() {
+
+			s := NewStore(cache)
+			_, err := s.LoadResult("short")
+			So(err, ShouldBeNil)
+
+			result, err := s.LoadResult(live.CookieValue())
+
+			Convey("Then the earlier outcome shouldn't carry over", func() {
+
+				So(err, ShouldBeNil)
+				So(result, ShouldEqual, LoadLoaded)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,109 @@
+package state
+
+import (
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+//Pipeline queues commands to be sent to Redis together, in a single round
+//trip, once Exec is called. Unlike a Transaction, the commands aren't atomic:
+//each is applied whether or not the others succeed, and Exec returns the first
+//error.
+type Pipeline interface {
+	Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	SRem(key string, members ...interface{}) *redis.IntCmd
+	Del(keys ...string) *redis.IntCmd
+	Exec() ([]redis.Cmder, error)
+}
+
+//PipeConnection is a Connection which can pipeline commands.
+type PipeConnection interface {
+	Connection
+	Pipeline() Pipeline
+}
+
+func (c redisConnection) Pipeline() Pipeline {
+	return c.Client.Pipeline()
+}
+
+//sessionRemoval is the commands needed to remove a session from the Cache.
+type sessionRemoval struct {
+	keys []string
+
+	//indexKey, if set, is the index the member is removed from
+	indexKey string
+	member   string
+
+	//tombstoneKey, if set, is stored for tombstoneTTL
+	tombstoneKey string
+	tombstoneTTL time.Duration
+}
+
+//removeSession deletes a session's keys, removes it from its user's index and
+//stores its tombstone. Where the Connection supports it, the commands are
+//pipelined, taking a single round trip rather than one each. Otherwise they
+//are sent one at a time, stopping at the first error.
+func (c *Cache) removeSession(removal sessionRemoval) error {
+	if removal.indexKey != "" && c.batcher != nil {
+		c.batcher.discard(removal.indexKey, removal.member)
+	}
+
+	connection, ok := c.getConnection().(PipeConnection)
+	if !ok {
+		return c.removeSessionSequentially(removal)
+	}
+
+	pipe := connection.Pipeline()
+	pipe.Del(removal.keys...)
+	if removal.indexKey != "" {
+		pipe.SRem(removal.indexKey, removal.member)
+	}
+	if removal.tombstoneKey != "" {
+		pipe.Set(removal.tombstoneKey, "1", removal.tombstoneTTL)
+	}
+
+	_, err := pipe.Exec()
+	return err
+}
+
+//removeSessionSequentially sends the commands removing a session one at a
+//time, for Connections which can't pipeline them.
+func (c *Cache) removeSessionSequentially(removal sessionRemoval) error {
+	if err := c.deleteSessionData(removal.keys...); err != nil {
+		return err
+	}
+
+	if removal.indexKey != "" {
+		if _, err := c.getConnection().SRem(removal.indexKey, removal.member).Result(); err != nil {
+			return err
+		}
+	}
+
+	if removal.tombstoneKey != "" {
+		return c.setExpiringData(removal.tombstoneKey, "1", removal.tombstoneTTL).Err()
+	}
+	return nil
+}
+
+//removeSession removes the session with the given ID from the Cache and, if
+//userID is given, from that user's session index. If tombstone is set and
+//replay detection is enabled, a tombstone is stored for it too.
+func (s *Store) removeSession(sessionID string, userID string, tombstone bool) error {
+	removal := sessionRemoval{keys: s.sessionKeys(sessionID)}
+
+	if userID != "" {
+		removal.indexKey = userSessionsKey(userID)
+		removal.member = sessionID
+	}
+
+	if tombstone && s.replayDetection() && len(sessionID) > 0 {
+		removal.tombstoneKey = tombstoneKeyPrefix + sessionID
+		removal.tombstoneTTL = s.tombstoneTTL
+	}
+
+	span := s.startSpan("del", keysLength(removal.keys))
+	err := s.cache.removeSession(removal)
+	span.End(err)
+	return err
+}
@@ -0,0 +1,151 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// pipeConnection is a fakeConnection which can pipeline commands, recording
+// each pipeline it executes, and failing them with err if it is set
+type pipeConnection struct {
+	*fakeConnection
+	executed [][]string
+	err      error
+}
+
+func (c *pipeConnection) Pipeline() Pipeline {
+	return &recordingPipeline{connection: c}
+}
+
+// recordingPipeline queues commands, applying them to the fakeConnection and
+// recording their names on Exec
+type recordingPipeline struct {
+	connection *pipeConnection
+	names      []string
+	queued     []func()
+}
+
+func (p *recordingPipeline) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	p.names = append(p.names, "set "+key)
+	p.queued = append(p.queued, func() { p.connection.Set(key, value, expiration) })
+	return redis.NewStatusResult("", nil)
+}
+
+func (p *recordingPipeline) SRem(key string, members ...interface{}) *redis.IntCmd {
+	p.names = append(p.names, "srem "+key)
+	p.queued = append(p.queued, func() { p.connection.SRem(key, members...) })
+	return redis.NewIntResult(0, nil)
+}
+
+func (p *recordingPipeline) Del(keys ...string) *redis.IntCmd {
+	p.names = append(p.names, "del")
+	p.queued = append(p.queued, func() { p.connection.Del(keys...) })
+	return redis.NewIntResult(0, nil)
+}
+
+func (p *recordingPipeline) Exec() ([]redis.Cmder, error) {
+	p.connection.executed = append(p.connection.executed, p.names)
+	for _, command := range p.queued {
+		command()
+	}
+	return nil, p.connection.err
+}
+
+// ------------------- Routes Through removeSession() -------------------
+
+// TestUnitClearPipelined - Verify that clearing a session deletes it, removes
+// it from its user's index and stores its tombstone in a single pipeline
+func TestUnitClearPipelined(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have stored a signed in session on a Cache which can pipeline, with replay detection", t, func() {
+
+		connection := &pipeConnection{fakeConnection: newFakeConnection()}
+		s := NewStore(NewCacheWithConnection(connection)).
+			WithReplayDetection(time.Minute, func(string) {})
+		s.Data = hashSessionData("user-1")
+		So(s.Store(), ShouldBeNil)
+
+		id := s.ID
+		indexKey := userSessionsKey("user-1")
+
+		Convey("When I clear it", func() {
+
+			err := s.Clear()
+
+			Convey("Then every command should have been sent in one pipeline, and applied", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.executed, ShouldResemble, [][]string{
+					{"del", "srem " + indexKey, "set " + tombstoneKeyPrefix + id},
+				})
+				So(connection.values, ShouldNotContainKey, id)
+				So(connection.values, ShouldContainKey, tombstoneKeyPrefix+id)
+				So(connection.sets[indexKey], ShouldNotContainKey, id)
+				So(s.Cleared(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I clear it and the pipeline fails", func() {
+
+			connection.err = errors.New("Error executing pipeline")
+			err := s.Clear()
+
+			Convey("Then the error should be returned and the session not cleared", func() {
+
+				So(err, ShouldEqual, connection.err)
+				So(s.ID, ShouldEqual, id)
+				So(s.Cleared(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When I regenerate its ID, keeping its data", func() {
+
+			err := s.RegenerateKeepData()
+
+			Convey("Then the old ID should have been removed in one pipeline, without a tombstone", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.executed, ShouldResemble, [][]string{
+					{"del", "srem " + indexKey},
+				})
+				So(connection.values, ShouldNotContainKey, id)
+				So(connection.values, ShouldContainKey, s.ID)
+				So(connection.sets[indexKey], ShouldNotContainKey, id)
+				So(connection.sets[indexKey], ShouldContainKey, s.ID)
+			})
+		})
+	})
+
+	Convey("Given I have stored a signed in session on a Cache which can't pipeline, with replay detection", t, func() {
+
+		connection := newFakeConnection()
+		s := NewStore(NewCacheWithConnection(connection)).
+			WithReplayDetection(time.Minute, func(string) {})
+		s.Data = hashSessionData("user-1")
+		So(s.Store(), ShouldBeNil)
+
+		id := s.ID
+
+		Convey("When I clear it", func() {
+
+			err := s.Clear()
+
+			Convey("Then the commands should have been sent one at a time, with the same effect", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.values, ShouldNotContainKey, id)
+				So(connection.values, ShouldContainKey, tombstoneKeyPrefix+id)
+				So(connection.sets[userSessionsKey("user-1")], ShouldNotContainKey, id)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,133 @@
+package state
+
+import (
+	"errors"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+//rememberKeyPrefix namespaces remember tokens in the cache so that they can't
+//collide with, or be loaded as, a main session
+const rememberKeyPrefix = "remember:"
+
+//takeScript deletes KEYS[1], returning its value and the milliseconds it had
+//left before expiring, or -1 if it never would have, so that a value can be
+//read and consumed in a single round trip. Nil is returned if nothing is
+//stored there.
+const takeScript = `
+local value = redis.call("GET", KEYS[1])
+if not value then
+	return nil
+end
+local ttl = redis.call("PTTL", KEYS[1])
+redis.call("DEL", KEYS[1])
+return {value, ttl}
+`
+
+//IssueRememberToken creates a long-lived "remember me" token for the signed in
+//user of the current session. The sign-in details are held in the cache under
+//their own key until the ttl has passed, and the token is indexed by user so
+//that DeleteUserSessions revokes it. The returned token is signed in the same
+//way as the session cookie, and should be set on a separate cookie so that
+//RedeemRememberToken can later mint a new session without a re-login.
+func (s *Store) IssueRememberToken(ttl time.Duration) (string, error) {
+
+	if ttl <= 0 {
+		return "", errors.New("Remember token ttl must be greater than zero")
+	}
+
+	signinInfo, ok := s.Data["signin_info"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("Cannot issue a remember token for a session with no signin info")
+	}
+
+	id, err := generateID(s.getRandom())
+	if err != nil {
+		return "", err
+	}
+
+	encodedData, err := s.encodeData(map[string]interface{}{"signin_info": signinInfo}, rememberKeyPrefix+id, "")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := s.cache.setExpiringData(rememberKeyPrefix+id, encodedData, ttl).Result(); err != nil {
+		return "", err
+	}
+
+	if userID := s.Data.GetUserID(); userID != "" {
+		if err := s.cache.addToIndex(userRememberTokensKey(userID), id, ttl); err != nil {
+			return "", err
+		}
+	}
+
+	return id + generateSignature(id), nil
+}
+
+//RedeemRememberToken validates a token previously created by
+//IssueRememberToken and, if it is still held in the cache, replaces the loaded
+//session with a fresh one under a new ID carrying the remembered sign-in
+//details. Store must be called afterwards to persist the new session.
+//
+//A token can only be redeemed once: it is taken from the cache, and a new
+//token, expiring when the redeemed one would have, is issued in its place, to
+//be read with RememberToken and set on the remember cookie. A stolen token is
+//therefore only good until either its thief or its owner next redeems it.
+func (s *Store) RedeemRememberToken(token string) error {
+
+	if len(token) < cookieValueLength() {
+		return wrapError(ErrCookieTooShort, "Remember token is less than the desired length")
+	}
+
+	id := token[0:signatureStart()]
+	if !validSignature(id, token[signatureStart():]) {
+		return wrapError(ErrInvalidSignature, "Remember token signature does not match the expected value")
+	}
+
+	// Only one of several concurrent redemptions of the token may take it
+	key := rememberKeyPrefix + id
+	storedData, ttl, err := s.cache.takeSessionData(key)
+	if err == redis.Nil || (err == nil && ttl <= 0) {
+		return errors.New("Remember token is invalid or has expired")
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := s.decodeSession(storedData, key, "")
+	if err != nil {
+		return err
+	}
+
+	signinInfo, ok := data["signin_info"].(map[string]interface{})
+	if !ok {
+		return errors.New("Remember token holds no signin info")
+	}
+
+	if err := s.regenerateID(); err != nil {
+		return err
+	}
+
+	s.Data = map[string]interface{}{"signin_info": signinInfo}
+	s.Expires = 0
+
+	if userID := s.Data.GetUserID(); userID != "" {
+		if err := s.cache.removeFromIndex(userRememberTokensKey(userID), id); err != nil {
+			return err
+		}
+	}
+
+	rememberToken, err := s.IssueRememberToken(ttl)
+	if err != nil {
+		return err
+	}
+	s.rememberToken = rememberToken
+	return nil
+}
+
+//RememberToken returns the token issued in place of the one last redeemed with
+//RedeemRememberToken, or an empty string if none has been.
+func (s *Store) RememberToken() string {
+	return s.rememberToken
+}
@@ -0,0 +1,276 @@
+package state
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	redis "gopkg.in/redis.v5"
+)
+
+func isRememberKey(key string) bool {
+	return strings.HasPrefix(key, rememberKeyPrefix)
+}
+
+// ------------------- Routes Through IssueRememberToken() -------------------
+
+// TestUnitIssueRememberTokenNotSignedIn - Verify that a remember token can't be
+// issued for a session with no signin info
+func TestUnitIssueRememberTokenNotSignedIn(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a session with no signin info", t, func() {
+
+		s := NewStore(nil)
+		s.Data = map[string]interface{}{}
+
+		Convey("When I issue a remember token", func() {
+
+			token, err := s.IssueRememberToken(time.Hour)
+
+			Convey("Then an appropriate error should be returned", func() {
+
+				So(token, ShouldBeBlank)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Cannot issue a remember token for a session with no signin info")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitIssueRememberTokenErrorOnSave - Verify error trapping if Redis returns
+// an error when saving the remember token
+func TestUnitIssueRememberTokenErrorOnSave(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a Redis error is thrown when saving the remember token", t, func() {
+
+		connection := &mockState.Connection{}
+		connection.On("Set", mock.MatchedBy(isRememberKey), mock.AnythingOfType("string"), time.Hour).
+			Return(redis.NewStatusResult("", errors.New("Unsuccessful save")))
+
+		s := NewStore(&Cache{connection: connection})
+		s.Data = map[string]interface{}{
+			"signin_info": map[string]interface{}{"signed_in": int8(1)},
+		}
+
+		Convey("When I issue a remember token", func() {
+
+			token, err := s.IssueRememberToken(time.Hour)
+
+			Convey("Then the error should be caught and returned", func() {
+
+				So(token, ShouldBeBlank)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Unsuccessful save")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through RedeemRememberToken() -------------------
+
+// TestUnitRedeemRememberTokenHappyPath - Verify that an issued remember token can
+// be redeemed for a fresh session carrying the remembered signin info
+func TestUnitRedeemRememberTokenHappyPath(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have issued a remember token for a signed in session", t, func() {
+
+		var storedKey, storedData string
+
+		connection := &mockState.Connection{}
+		connection.On("Set", mock.MatchedBy(isRememberKey), mock.AnythingOfType("string"), time.Hour).
+			Run(func(args mock.Arguments) {
+				storedKey = args.String(0)
+				storedData = args.String(1)
+			}).
+			Return(redis.NewStatusResult("", nil))
+
+		s := NewStore(&Cache{connection: connection})
+		s.ID = "abc"
+		s.Data = map[string]interface{}{
+			"test": "hello, world!",
+			"signin_info": map[string]interface{}{
+				"signed_in": int8(1),
+			},
+		}
+
+		token, err := s.IssueRememberToken(time.Hour)
+		So(err, ShouldBeNil)
+		So(len(token), ShouldEqual, cookieValueLength())
+		So(storedKey, ShouldEqual, rememberKeyPrefix+token[0:signatureStart()])
+
+		Convey("When I redeem the token against a new store", func() {
+
+			connection.On("Eval", takeScript, []string{storedKey}).
+				Return(redis.NewCmdResult([]interface{}{storedData, int64(30 * time.Minute / time.Millisecond)}, nil))
+			connection.On("Set", mock.MatchedBy(isRememberKey), mock.AnythingOfType("string"), 30*time.Minute).
+				Return(redis.NewStatusResult("", nil))
+
+			redeemed := NewStore(&Cache{connection: connection})
+			err := redeemed.RedeemRememberToken(token)
+			newToken := redeemed.RememberToken()
+
+			Convey("Then a fresh session should be created with the remembered signin info", func() {
+
+				So(err, ShouldBeNil)
+				So(redeemed.ID, ShouldNotBeBlank)
+				So(redeemed.ID, ShouldNotEqual, s.ID)
+				So(redeemed.Data["test"], ShouldBeNil)
+
+				signinInfo := redeemed.Data["signin_info"].(map[string]interface{})
+				So(signinInfo["signed_in"], ShouldEqual, int8(1))
+			})
+
+			Convey("Then the token should be consumed and replaced by one with its remaining lifetime", func() {
+
+				So(err, ShouldBeNil)
+				So(len(newToken), ShouldEqual, cookieValueLength())
+				So(newToken, ShouldNotEqual, token)
+				connection.AssertNumberOfCalls(t, "Eval", 1)
+				connection.AssertCalled(t, "Set", mock.MatchedBy(isRememberKey), mock.AnythingOfType("string"), 30*time.Minute)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitRedeemRememberTokenExpired - Verify that a remember token which is no
+// longer held in the cache can't be redeemed
+func TestUnitRedeemRememberTokenExpired(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a validly signed remember token which has expired", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		token := id + generateSignature(id)
+
+		connection := &mockState.Connection{}
+		connection.On("Eval", takeScript, []string{rememberKeyPrefix + id}).Return(redis.NewCmdResult(nil, redis.Nil))
+
+		s := NewStore(&Cache{connection: connection})
+		s.ID = "abc"
+
+		Convey("When I redeem the token", func() {
+
+			err := s.RedeemRememberToken(token)
+
+			Convey("Then an appropriate error should be returned and the session left alone", func() {
+
+				So(s.RememberToken(), ShouldBeBlank)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Remember token is invalid or has expired")
+				So(s.ID, ShouldEqual, "abc")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitRedeemRememberTokenInvalidSignature - Verify that a tampered remember
+// token is rejected without touching the cache
+func TestUnitRedeemRememberTokenInvalidSignature(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a remember token with an invalid signature", t, func() {
+
+		token := strings.Repeat("a", cookieValueLength())
+
+		connection := &mockState.Connection{}
+		s := NewStore(&Cache{connection: connection})
+
+		Convey("When I redeem the token", func() {
+
+			err := s.RedeemRememberToken(token)
+
+			Convey("Then an appropriate error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Remember token signature does not match the expected value")
+				So(len(connection.Calls), ShouldEqual, 0)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitRedeemRememberTokenOnce - Verify that a remember token can only be
+// redeemed once, being replaced by a new token with its remaining lifetime
+func TestUnitRedeemRememberTokenOnce(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have issued a remember token for a signed in user", t, func() {
+
+		connection := newFakeConnection()
+		cache := &Cache{connection: connection}
+
+		s := NewStore(cache)
+		s.Data = signedInData("user1")
+
+		token, err := s.IssueRememberToken(time.Hour)
+		So(err, ShouldBeNil)
+		So(connection.sets[userRememberTokensKey("user1")], ShouldContainKey, token[0:signatureStart()])
+
+		Convey("When I redeem the token", func() {
+
+			connection.ttls[rememberKeyPrefix+token[0:signatureStart()]] = 30 * time.Minute
+
+			redeemed := NewStore(cache)
+			err := redeemed.RedeemRememberToken(token)
+			newToken := redeemed.RememberToken()
+
+			Convey("Then it should be replaced in the cache and the user's index by a new token", func() {
+
+				So(err, ShouldBeNil)
+				So(newToken, ShouldNotEqual, token)
+
+				oldID, newID := token[0:signatureStart()], newToken[0:signatureStart()]
+				So(connection.values, ShouldNotContainKey, rememberKeyPrefix+oldID)
+				So(connection.values, ShouldContainKey, rememberKeyPrefix+newID)
+				So(connection.ttls[rememberKeyPrefix+newID], ShouldEqual, 30*time.Minute)
+				So(connection.sets[userRememberTokensKey("user1")], ShouldNotContainKey, oldID)
+				So(connection.sets[userRememberTokensKey("user1")], ShouldContainKey, newID)
+			})
+
+			Convey("Then redeeming the token again should fail", func() {
+
+				err := NewStore(cache).RedeemRememberToken(token)
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Remember token is invalid or has expired")
+			})
+
+			Convey("Then the new token should be revoked with the user's sessions", func() {
+
+				So(NewStore(cache).DeleteUserSessions("user1"), ShouldBeNil)
+
+				err := NewStore(cache).RedeemRememberToken(newToken)
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Remember token is invalid or has expired")
+				So(connection.sets, ShouldNotContainKey, userRememberTokensKey("user1"))
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,51 @@
+package state
+
+import (
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+//tombstoneKeyPrefix namespaces the tombstones of cleared sessions in the cache
+const tombstoneKeyPrefix = "tombstone:"
+
+//WithReplayDetection enables the detection of replayed sessions. Whenever a
+//session is cleared, a tombstone for its ID is held in the cache for the given
+//ttl. If a session with a tombstoned ID is subsequently loaded, e.g. by
+//replaying the cookie of a logged out user, onReplay is called with its ID.
+func (s *Store) WithReplayDetection(ttl time.Duration, onReplay func(sessionID string)) *Store {
+	s.tombstoneTTL = ttl
+	s.onReplay = onReplay
+	return s
+}
+
+//replayDetection reports whether replay detection is enabled, so that cleared
+//sessions should be tombstoned
+func (s *Store) replayDetection() bool {
+	return s.onReplay != nil && s.tombstoneTTL > 0
+}
+
+//detectReplay calls the replay hook if the current session ID, which could not
+//be found in the cache, has recently been cleared
+func (s *Store) detectReplay() {
+
+	if s.onReplay != nil && s.tombstoned() {
+		s.onReplay(s.ID)
+	}
+}
+
+//tombstoned reports whether a tombstone is held in the cache for the current
+//session ID, because it has recently been cleared. If the cache can't be read,
+//it reports that there is none.
+func (s *Store) tombstoned() bool {
+
+	_, err := s.cache.getSessionData(tombstoneKeyPrefix + s.ID)
+	if err != nil {
+		// Failing to check for a replay shouldn't fail the load itself
+		if err != redis.Nil {
+			s.getLogger().Error(err, nil)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// ------------------- Routes Through detectReplay() -------------------
+
+// TestUnitLoadReplayedSession - Verify that loading a session which has just been
+// cleared triggers the replay hook
+func TestUnitLoadReplayedSession(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given replay detection is enabled and I have cleared a session", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		cookieValue := id + generateSignature(id)
+
+		connection := &mockState.Connection{}
+		connection.On("Del", id).Return(redis.NewIntResult(1, nil))
+		connection.On("Set", tombstoneKeyPrefix+id, "1", time.Minute).Return(redis.NewStatusResult("OK", nil))
+		connection.On("Get", id).Return(redis.NewStringResult("", redis.Nil))
+		connection.On("Get", tombstoneKeyPrefix+id).Return(redis.NewStringResult("1", nil))
+
+		var replayed []string
+		onReplay := func(sessionID string) { replayed = append(replayed, sessionID) }
+
+		s := NewStore(&Cache{connection: connection}).WithReplayDetection(time.Minute, onReplay)
+		s.ID = id
+
+		So(s.Clear(), ShouldBeNil)
+		connection.AssertCalled(t, "Set", tombstoneKeyPrefix+id, "1", time.Minute)
+
+		Convey("When the old cookie is replayed", func() {
+
+			replay := NewStore(&Cache{connection: connection}).WithReplayDetection(time.Minute, onReplay)
+			err := replay.Load(cookieValue)
+
+			Convey("Then the replay hook should be called with the cleared ID", func() {
+
+				So(err, ShouldBeNil)
+				So(len(replay.Data), ShouldEqual, 0)
+				So(replayed, ShouldResemble, []string{id})
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitLoadNewSessionNotReplayed - Verify that loading a session which was never
+// stored doesn't trigger the replay hook
+func TestUnitLoadNewSessionNotReplayed(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given replay detection is enabled and a session ID with no tombstone", t, func() {
+
+		id := strings.Repeat("b", signatureStart())
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult("", redis.Nil))
+		connection.On("Get", tombstoneKeyPrefix+id).Return(redis.NewStringResult("", redis.Nil))
+
+		replayed := false
+		s := NewStore(&Cache{connection: connection}).
+			WithReplayDetection(time.Minute, func(sessionID string) { replayed = true })
+
+		Convey("When the session is loaded", func() {
+
+			err := s.Load(id + generateSignature(id))
+
+			Convey("Then the replay hook should not be called", func() {
+
+				So(err, ShouldBeNil)
+				So(replayed, ShouldBeFalse)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
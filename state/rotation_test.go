@@ -0,0 +1,91 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ------------------- Routes Through CookieSecretFallbacks -------------------
+
+// TestUnitSecretRotationRollout - Verify that, part way through rolling out a
+// new cookie secret, a session written by a server with either secret can be
+// loaded by a server with the other
+func TestUnitSecretRotationRollout(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given servers mid-rollout, with the old and new secrets swapped between primary and fallback", t, func() {
+
+		cfg := config.Get()
+		secret, fallbacks := cfg.CookieSecret, cfg.CookieSecretFallbacks
+		cfg.DataIntegrity = true
+		defer func() {
+			cfg.CookieSecret, cfg.CookieSecretFallbacks = secret, fallbacks
+			cfg.DataIntegrity = false
+		}()
+
+		oldServer := func() { cfg.CookieSecret, cfg.CookieSecretFallbacks = "old-secret", []string{"new-secret"} }
+		newServer := func() { cfg.CookieSecret, cfg.CookieSecretFallbacks = "new-secret", []string{"old-secret"} }
+
+		cache := NewMemoryCache()
+
+		storeOn := func(server func()) string {
+			server()
+			s := NewStore(cache)
+			s.Data = map[string]interface{}{
+				"role":    "user",
+				"expires": uint32(time.Now().Unix() + 3600),
+				"signin_info": map[string]interface{}{
+					"access_token": map[string]interface{}{
+						"expires_in": uint16(3600),
+					},
+				},
+			}
+			So(s.Store(), ShouldBeNil)
+			return s.CookieValue()
+		}
+
+		loadOn := func(server func(), cookieValue string) *Store {
+			server()
+			s := NewStore(cache)
+			So(s.Load(cookieValue), ShouldBeNil)
+			return s
+		}
+
+		Convey("When a session written with the new secret is loaded by a server still using the old one", func() {
+
+			s := loadOn(oldServer, storeOn(newServer))
+
+			Convey("Then it should load", func() {
+
+				So(s.Data["role"], ShouldEqual, "user")
+			})
+		})
+
+		Convey("When a session written with the old secret is loaded by a server using the new one", func() {
+
+			s := loadOn(newServer, storeOn(oldServer))
+
+			Convey("Then it should load", func() {
+
+				So(s.Data["role"], ShouldEqual, "user")
+			})
+		})
+
+		Convey("When a session written with the old secret is loaded once it is no longer listed", func() {
+
+			cookieValue := storeOn(oldServer)
+			s := loadOn(func() { cfg.CookieSecret, cfg.CookieSecretFallbacks = "new-secret", nil }, cookieValue)
+
+			Convey("Then it should be rejected", func() {
+
+				So(s.Data, ShouldBeEmpty)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,71 @@
+package state
+
+import (
+	"sync"
+
+	session "github.com/companieshouse/go-session-handler/session"
+)
+
+//schemaVersionKey is the key in the session data under which the schema version
+//it was written with is stamped.
+const schemaVersionKey = "schema_version"
+
+var (
+	upgradesMu sync.RWMutex
+	upgrades   = map[int]func(session.Session) session.Session{}
+)
+
+//RegisterUpgrade registers a function which upgrades session data written with
+//the given schema version to the next version. The current schema version is
+//one more than the highest version an upgrade is registered from, and sessions
+//are stamped with it whenever they are stored. Sessions written before any
+//upgrades were registered are treated as version 0.
+//
+//Upgrades should be registered at startup, before any sessions are loaded.
+func RegisterUpgrade(fromVersion int, fn func(session.Session) session.Session) {
+	upgradesMu.Lock()
+	defer upgradesMu.Unlock()
+
+	upgrades[fromVersion] = fn
+}
+
+//currentSchemaVersion returns the schema version sessions are stored with.
+func currentSchemaVersion() int {
+	upgradesMu.RLock()
+	defer upgradesMu.RUnlock()
+
+	current := 0
+	for fromVersion := range upgrades {
+		if fromVersion+1 > current {
+			current = fromVersion + 1
+		}
+	}
+	return current
+}
+
+//stampSchemaVersion records the current schema version in the session data.
+func (s *Store) stampSchemaVersion() {
+	s.Data[schemaVersionKey] = int64(currentSchemaVersion())
+}
+
+//upgradeSession runs each registered upgrade in turn from the schema version
+//the loaded session was written with up to the current version, returning true
+//if the session data was upgraded. A version without an upgrade registered is
+//passed over unchanged.
+func (s *Store) upgradeSession() bool {
+	version := int(s.Data.SchemaVersion())
+	current := currentSchemaVersion()
+	if version >= current {
+		return false
+	}
+
+	upgradesMu.RLock()
+	defer upgradesMu.RUnlock()
+
+	for ; version < current; version++ {
+		if upgrade, ok := upgrades[version]; ok {
+			s.Data = upgrade(s.Data)
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	session "github.com/companieshouse/go-session-handler/session"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ------------------- Routes Through RegisterUpgrade() -------------------
+
+// TestUnitSchemaUpgrade - Verify that a session written with an old schema
+// version is upgraded through each registered step on load, and re-stored
+func TestUnitSchemaUpgrade(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given upgrades are registered from versions 0 and 1", t, func() {
+
+		RegisterUpgrade(0, func(data session.Session) session.Session {
+			data["full_name"] = data["name"]
+			delete(data, "name")
+			return data
+		})
+		RegisterUpgrade(1, func(data session.Session) session.Session {
+			data["theme"] = "default"
+			return data
+		})
+		defer func() { upgrades = map[int]func(session.Session) session.Session{} }()
+
+		cache := NewMemoryCache()
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		Convey("When a session stored without a schema version is loaded", func() {
+
+			encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+				"expires": uint32(time.Now().Unix() + 100),
+				"name":    "Jane",
+			})
+			So(err, ShouldBeNil)
+			cache.connection.Set(id, encoded, 0)
+
+			s := NewStore(cache)
+			err = s.Load(sessionID)
+
+			Convey("Then both upgrades should have been applied in order", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data, ShouldNotContainKey, "name")
+				So(s.Data["full_name"], ShouldEqual, "Jane")
+				So(s.Data["theme"], ShouldEqual, "default")
+			})
+
+			Convey("Then the upgraded session should be re-stored with the current version", func() {
+
+				stored, err := cache.getSessionData(id)
+				So(err, ShouldBeNil)
+
+				data, err := MsgPackSerializer{}.Decode(stored)
+				So(err, ShouldBeNil)
+
+				restored := session.Session(data)
+				So(restored.SchemaVersion(), ShouldEqual, 2)
+				So(data["full_name"], ShouldEqual, "Jane")
+			})
+		})
+
+		Convey("When a session stored with the current version is loaded", func() {
+
+			encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+				"expires":        uint32(time.Now().Unix() + 100),
+				"name":           "Jane",
+				"schema_version": uint32(2),
+			})
+			So(err, ShouldBeNil)
+			cache.connection.Set(id, encoded, 0)
+
+			s := NewStore(cache)
+			err = s.Load(sessionID)
+
+			Convey("Then it should be left as it is", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data["name"], ShouldEqual, "Jane")
+				So(s.Data, ShouldNotContainKey, "theme")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
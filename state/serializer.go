@@ -0,0 +1,58 @@
+package state
+
+import "github.com/companieshouse/go-session-handler/encoding"
+
+//Serializer is the interface used to encode the session data for storage in
+//the Cache, and to decode it again once loaded.
+type Serializer interface {
+	Encode(data map[string]interface{}) (string, error)
+	Decode(encoded string) (map[string]interface{}, error)
+}
+
+//MsgPackSerializer is the default Serializer. It messagepack encodes the
+//session data and then base64 encodes the result.
+type MsgPackSerializer struct{}
+
+//Encode performs the messagepack and base 64 encoding on the session data and
+//returns the result, or an error if one occurs
+func (MsgPackSerializer) Encode(data map[string]interface{}) (string, error) {
+
+	msgpackEncodedData, err := encoding.EncodeMsgPack(data)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeBase64(msgpackEncodedData), nil
+}
+
+//Decode will try to base64 decode the session and then msgpack decode it.
+func (MsgPackSerializer) Decode(encoded string) (map[string]interface{}, error) {
+
+	base64DecodedSession, err := encoding.DecodeBase64(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	msgpackDecodedSession, err := encoding.DecodeMsgPack(base64DecodedSession)
+	if err != nil {
+		return nil, err
+	}
+
+	return msgpackDecodedSession, nil
+}
+
+//EncodeSession encodes session data exactly as a Store with the default
+//settings stores it under the given key, being the session ID unless key
+//versions are used: messagepack and base64 encoded, with an integrity check
+//for that key if enabled in config. It lets tooling and other services write
+//sessions which a Store can load.
+func EncodeSession(key string, data map[string]interface{}) (string, error) {
+	return NewStore(nil).encodeData(data, key, "")
+}
+
+//DecodeSession decodes session data stored under the given key by a Store with
+//the default settings, verifying its integrity check if enabled in config. It
+//lets tooling and other services read the sessions a Store has stored.
+func DecodeSession(key string, encoded string) (map[string]interface{}, error) {
+	return NewStore(nil).decodeSession(encoded, key, "")
+}
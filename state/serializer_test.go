@@ -0,0 +1,118 @@
+package state
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ------------------- Routes Through EncodeSession() -------------------
+
+// TestUnitEncodeDecodeSessionMatchStore - Verify that the package level helpers
+// encode and decode session data exactly as a Store does
+func TestUnitEncodeDecodeSessionMatchStore(t *testing.T) {
+
+	initConfig()
+
+	for _, integrity := range []bool{false, true} {
+
+		Convey("Given a Store has stored a session", t, func() {
+
+			cfg := config.Get()
+			cfg.DataIntegrity = integrity
+			defer func() { cfg.DataIntegrity = false }()
+
+			connection := newFakeConnection()
+			s := NewStore(NewCacheWithConnection(connection))
+			s.Data = hashSessionData("user-1")
+			So(s.Store(), ShouldBeNil)
+
+			stored := connection.values[s.ID]
+
+			Convey("When I encode the same data with EncodeSession", func() {
+
+				encoded, err := EncodeSession(s.ID, s.Data)
+
+				Convey("Then it should match what the Store stored byte for byte", func() {
+
+					So(err, ShouldBeNil)
+					So(encoded, ShouldEqual, stored)
+				})
+			})
+
+			Convey("When I decode what the Store stored with DecodeSession", func() {
+
+				decoded, err := DecodeSession(s.ID, stored)
+
+				Convey("Then it should match what the Store decodes", func() {
+
+					expected, _ := s.decodeSession(stored, s.ID, "")
+
+					So(err, ShouldBeNil)
+					So(decoded, ShouldResemble, expected)
+					So(decoded["signin_info"], ShouldResemble, expected["signin_info"])
+				})
+			})
+		})
+	}
+
+	cleanupConfig()
+}
+
+// failingSerializer is a Serializer whose encoding and decoding always fail
+type failingSerializer struct{}
+
+func (failingSerializer) Encode(data map[string]interface{}) (string, error) {
+	return "", errors.New("Error encoding session")
+}
+
+func (failingSerializer) Decode(encoded string) (map[string]interface{}, error) {
+	return nil, errors.New("Error decoding session")
+}
+
+// ------------------- Routes Through WithSerializer() -------------------
+
+// TestUnitWithSerializerErrors - Verify that errors from an injected Serializer
+// are returned when storing and loading
+func TestUnitWithSerializerErrors(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a Store with a failing Serializer", t, func() {
+
+		connection := newFakeConnection()
+		s := NewStore(NewCacheWithConnection(connection)).WithSerializer(failingSerializer{})
+
+		Convey("When I store a session", func() {
+
+			s.Data = hashSessionData("user-1")
+			err := s.Store()
+
+			Convey("Then the encoding error should be returned and nothing stored", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Error encoding session")
+				So(connection.values, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I load a stored session", func() {
+
+			id := strings.Repeat("a", signatureStart())
+			connection.values[id] = "stored"
+
+			err := s.Load(id + generateSignature(id))
+
+			Convey("Then the decoding error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Error decoding session")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
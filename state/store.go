@@ -1,12 +1,18 @@
 package state
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
-	"strconv"
+	"fmt"
+	"io"
+	"strings"
 	"time"
 
-	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/go-session-handler/config"
 	"github.com/companieshouse/go-session-handler/encoding"
 	session "github.com/companieshouse/go-session-handler/session"
@@ -15,10 +21,68 @@ import (
 
 //Multiples of 3 bytes avoids = padding in base64 string
 //7 * 3 bytes = (21/3) * 4 = 28 base64 characters
-const idOctets = 7 * 3
-const signatureStart = (idOctets * 4) / 3
-const signatureLength = 27 //160 bits, base 64 encoded
-const cookieValueLength = signatureStart + signatureLength
+const defaultIDOctets = 7 * 3
+
+//slidingExpirationThreshold is the number of seconds a sliding expiry must move
+//by before the session is re-stored
+const slidingExpirationThreshold = 60
+
+//expiredSessionTTL is how long the cache keeps a session which had already
+//expired when it was stored, so that it can still be loaded as expired
+const expiredSessionTTL = time.Minute
+
+//maxExpirationJitter is the largest percentage of its expiration period a
+//session's lifetime may be lengthened by to spread out expiries
+const maxExpirationJitter = 50
+
+//idOctets returns the number of random bytes making up a session ID, as set
+//in config, or the default if none is set.
+func idOctets() int {
+	if octets := config.Get().SessionIDOctets; octets > 0 {
+		return octets
+	}
+	return defaultIDOctets
+}
+
+//signatureStart returns the length of a base64 encoded session ID, which is
+//where the signature starts in the cookie value.
+func signatureStart() int {
+	return base64.StdEncoding.EncodedLen(idOctets())
+}
+
+//signatureHash returns a new instance of the hash signatures are digests of.
+//The lengths of signatures, and so of cookie values, are derived from its size,
+//so changing it changes them consistently rather than silently breaking them.
+var signatureHash = sha1.New
+
+//signatureLength returns the length of a signature, being the digest of the
+//signatureHash base64 encoded without its '=' padding: 27 characters for the
+//160 bits of SHA-1.
+func signatureLength() int {
+	return base64.RawStdEncoding.EncodedLen(signatureHash().Size())
+}
+
+//cookieValueLength returns the length of a session ID plus its signature.
+func cookieValueLength() int {
+	return signatureStart() + signatureLength()
+}
+
+//SetCondition controls whether storing a session should only apply if the
+//session is absent from, or already present in, the cache
+type SetCondition int
+
+const (
+	//SetAlways stores the session unconditionally. This is the default.
+	SetAlways SetCondition = iota
+	//SetIfAbsent only stores the session if its ID isn't already in the cache
+	SetIfAbsent
+	//SetIfPresent only stores the session if its ID is already in the cache
+	SetIfPresent
+)
+
+//ErrNotApplied is returned by Store when the session wasn't saved because its
+//SetCondition wasn't met. This isn't a failure of the cache itself.
+var ErrNotApplied = errors.New("Session was not stored as its set condition was not met")
 
 //Store is the struct that is used to load/store the session.
 type Store struct {
@@ -26,6 +90,54 @@ type Store struct {
 	Expires uint64
 	Data    session.Session
 	cache   *Cache
+
+	serializer Serializer
+	transforms []Transform
+	observer   Observer
+
+	tombstoneTTL time.Duration
+	onReplay     func(sessionID string)
+
+	familyChecker TokenFamilyChecker
+
+	setCondition SetCondition
+	logger       Logger
+	metrics      Metrics
+
+	tracer Tracer
+	ctx    context.Context
+
+	cleared bool
+	dirty   bool
+
+	keyVersion       string
+	olderKeyVersions []string
+	migrateFromKey   string
+
+	storedFields []string
+
+	optimisticLocking bool
+	loadedValue       string
+
+	snapshot    map[string]interface{}
+	changedKeys []string
+
+	fallback      *CookieFallbackCache
+	fallbackValue string
+
+	rememberToken string
+
+	loadOutcome LoadOutcome
+
+	hooks SessionHooks
+
+	//persisted is set once the session has been loaded from, or stored in, the
+	//Cache, so that storing it isn't reported as creating it
+	persisted bool
+
+	clock Clock
+
+	random io.Reader
 }
 
 //NewStore will properly initialise a new Store object.
@@ -34,34 +146,142 @@ func NewStore(cache *Cache) *Store {
 	return &Store{cache: cache}
 }
 
+//WithCache replaces the Cache the Store loads and stores sessions with, e.g. to
+//point an existing Store at a new Redis during a migration. It isn't safe for
+//concurrent use, so should only be called before the Store is shared, or
+//between requests.
+func (s *Store) WithCache(cache *Cache) *Store {
+	s.cache = cache
+	return s
+}
+
+//WithSerializer sets the Serializer used to encode and decode the session data,
+//replacing the default MsgPackSerializer.
+func (s *Store) WithSerializer(serializer Serializer) *Store {
+	s.serializer = serializer
+	return s
+}
+
+//WithObserver sets the Observer notified of each Redis operation performed by
+//the Store.
+func (s *Store) WithObserver(observer Observer) *Store {
+	s.observer = observer
+	return s
+}
+
+//WithSetCondition sets the condition which must be met for Store to save the
+//session. If it isn't met, Store returns ErrNotApplied.
+func (s *Store) WithSetCondition(condition SetCondition) *Store {
+	s.setCondition = condition
+	return s
+}
+
+//getSerializer returns the Serializer set on the Store, falling back to the
+//default MsgPackSerializer if none has been set.
+func (s *Store) getSerializer() Serializer {
+	if s.serializer == nil {
+		return MsgPackSerializer{}
+	}
+	return s.serializer
+}
+
+//WithRandom sets the source of randomness session IDs are generated from,
+//replacing crypto/rand, e.g. to plug in a hardware RNG. The source must be
+//cryptographically secure, as the IDs are only as unguessable as it is.
+func (s *Store) WithRandom(random io.Reader) *Store {
+	s.random = random
+	return s
+}
+
+//getRandom returns the source of randomness set on the Store, falling back to
+//crypto/rand if none has been set.
+func (s *Store) getRandom() io.Reader {
+	if s.random == nil {
+		return rand.Reader
+	}
+	return s.random
+}
+
 //Load is used to try and get a session from the cache. If it succeeds it will
-//load the session, otherwise it will return an error.
+//load the session, otherwise it will return an error. If sliding expiration
+//is configured, the expiry of a loaded session is extended and re-stored.
 func (s *Store) Load(sessionID string) error {
 
+	s.loadOutcome = LoadNotFound
+	s.persisted = false
+	if s.fallback != nil && strings.HasPrefix(sessionID, cookieFallbackPrefix) {
+		return s.loadFromCookie(sessionID)
+	}
+
 	err := s.validateSessionID(sessionID)
 
 	// If validateSessionID returns an error, we need to return an empty session
 	// That said, no exceptions have occurred so return a nil error
 	if err != nil {
-		log.Trace(err.Error())
+		s.getLogger().Trace(err.Error(), nil)
+		if sessionID != "" {
+			s.loadOutcome = LoadInvalidSignature
+		}
 		return nil
 	}
 
-	session, err := s.fetchSession()
-	if err != nil {
-		if err == redis.Nil {
-			//If the session isn't stored in Redis, clear any data and return nil error
-			s.clearSessionData()
-			return nil
-		}
-		return err
+	return s.loadSession()
+}
+
+//LoadParts loads a session in the same way as Load, but from a session ID and
+//signature which have already been split from the cookie value.
+func (s *Store) LoadParts(id string, signature string) error {
+
+	s.loadOutcome = LoadNotFound
+	s.persisted = false
+	if err := checkSignature(id, signature); err != nil {
+		s.loadOutcome = LoadInvalidSignature
+		s.clearSessionData()
+		s.getLogger().Trace(err.Error(), nil)
+		return nil
 	}
 
-	s.Data, err = s.decodeSession(session)
+	s.ID = id
+	return s.loadSession()
+}
+
+//loadSession fetches and validates the session stored under the Store's ID,
+//whose signature has already been validated
+func (s *Store) loadSession() error {
+
+	s.snapshot = nil
+	data, err := s.fetchData()
+	if err == ErrSessionNotFound {
+		//If the session isn't stored in Redis, clear any data and return nil error
+		s.getMetrics().IncLoadMiss()
+		s.detectReplay()
+		s.clearSessionData()
+		return nil
+	}
+	if err == ErrSessionTampered || err == encoding.ErrInvalidSessionFormat {
+		// Treat a tampered or corrupt session as invalid, starting a fresh one instead
+		s.getLogger().Error(err, map[string]interface{}{"session_id": s.ID})
+		s.loadOutcome = LoadInvalidSignature
+		s.clearSessionData()
+		s.ID = ""
+		return nil
+	}
+	if err != nil && s.fallback != nil && isCacheUnavailable(err) {
+		// Degrade to a fresh session, kept in its cookie, under an ID which won't
+		// overwrite the stored session once the cache is back
+		s.getLogger().Warn("Cache unavailable, starting a fresh session: "+err.Error(), map[string]interface{}{"session_id": s.ID})
+		s.clearSessionData()
+		s.ID = ""
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
+	s.Data = data
+	s.takeSnapshot()
+	upgraded := s.upgradeSession()
+
 	// Create a new session if the data is nil (not sure how this is possible!)
 	if s.Data == nil {
 		s.clearSessionData()
@@ -71,13 +291,132 @@ func (s *Store) Load(sessionID string) error {
 	err = s.validateExpiration()
 	if err != nil {
 		// If the session has expired, clear the data and return nil
+		s.getLogger().Info(err.Error(), map[string]interface{}{"session_id": s.ID})
+		s.getMetrics().IncExpired()
+		s.loadOutcome = LoadExpired
+		s.clearSessionData()
+		return nil
+	}
+
+	if err := s.validateTokenFamily(); err != nil {
+		// If the session's token family has been revoked, clear the data and return nil
+		s.getLogger().Info(err.Error(), map[string]interface{}{"session_id": s.ID})
+		s.loadOutcome = LoadExpired
+		s.clearSessionData()
+		return nil
+	}
+
+	s.loadOutcome = LoadLoaded
+	s.getMetrics().IncLoadHit()
+
+	// Refresh the idle window now the session has been accessed
+	s.Data["last_access"] = s.now().Unix()
+
+	s.persisted = true
+	s.runHook(s.hooks.OnLoad)
+
+	if config.Get().SlidingExpiration {
+		slid, err := s.slideExpiration()
+		if err != nil {
+			return err
+		}
+
+		if slid {
+			return s.save()
+		}
+	}
+
+	// Re-store an upgraded session so that it's only upgraded once
+	if upgraded {
+		return s.save()
+	}
+
+	return nil
+}
+
+//Reload discards any local changes to the loaded session and re-reads the
+//latest data stored under its ID, e.g. after another request has updated it
+//mid-way through a multi-step flow. The ID has already been validated, so it
+//isn't checked again. If the session is no longer stored, its data is cleared.
+func (s *Store) Reload() error {
+
+	if len(s.ID) == 0 {
+		return wrapError(ErrNoSessionData, "No session has been loaded to reload")
+	}
+
+	data, err := s.fetchData()
+	if err == ErrSessionNotFound {
+		s.persisted = false
 		s.clearSessionData()
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
+	s.Data = data
+	s.dirty = false
+	s.takeSnapshot()
+	if expires, ok := session.ToInt64(data["expires"]); ok {
+		s.Expires = uint64(expires)
+	}
 	return nil
 }
 
+//LoadMany loads the sessions for many signed session IDs at once, e.g. for an
+//admin dashboard, in a single round trip to the cache. The result is keyed by
+//the signed session IDs given. IDs whose signature is invalid, whose session
+//isn't stored, or whose session can't be decoded are omitted rather than
+//failing the whole batch. Only the current key version is read, and the
+//sessions aren't checked for expiry.
+func (s *Store) LoadMany(sessionIDs []string) (map[string]session.Session, error) {
+
+	keys := map[string]string{}
+	var toFetch []string
+	for _, sessionID := range sessionIDs {
+		id, err := splitCookieValue(sessionID)
+		if err != nil {
+			s.getLogger().Trace(err.Error(), nil)
+			continue
+		}
+
+		key := versionedKey(id, s.keyVersion)
+		keys[sessionID] = key
+		toFetch = append(toFetch, key)
+	}
+
+	sessions := map[string]session.Session{}
+	if len(toFetch) == 0 {
+		return sessions, nil
+	}
+
+	// Hashes can't be fetched together with MGET
+	if s.cache.hashes {
+		return s.loadManyHashes(keys)
+	}
+
+	stored, err := s.cache.getManySessionData(toFetch...)
+	if err != nil {
+		return nil, err
+	}
+
+	for sessionID, key := range keys {
+		encoded, ok := stored[key]
+		if !ok {
+			continue
+		}
+
+		data, err := s.decodeSession(encoded, key, "")
+		if err != nil {
+			s.getLogger().Warn("Session could not be decoded: "+err.Error(), map[string]interface{}{"session_id": key})
+			continue
+		}
+		sessions[sessionID] = data
+	}
+
+	return sessions, nil
+}
+
 // Store operates on a Store struct, saving it in the cache.
 // Firstly, if the session data is nil, it will be set to an empty map.
 // If the ID is not supplied, one will be generated.
@@ -89,7 +428,7 @@ func (s *Store) Store() error {
 		s.clearSessionData() // Set session data to an empty map rather than nil
 
 		// Since this should never happen, we'll add a log warning
-		log.Info("Session data was nil for ID " + s.ID)
+		s.getLogger().Trace("Session data was nil for ID "+s.ID, nil)
 		return nil
 	}
 
@@ -97,6 +436,7 @@ func (s *Store) Store() error {
 		if err := s.regenerateID(); err != nil {
 			return err
 		}
+		s.markCreated(uint64(s.now().Unix()))
 	}
 
 	if s.Expires == 0 {
@@ -105,22 +445,76 @@ func (s *Store) Store() error {
 		}
 	}
 
-	encodedData, err := s.encodeSessionData()
+	changed := s.diffSnapshot()
+	s.changedKeys = nil
+	s.fallbackValue = ""
+	if err := s.save(); err != nil {
+		if err := s.storeInCookie(err); err != nil {
+			return err
+		}
+	}
+
+	s.changedKeys = changed
+	s.takeSnapshot()
+
+	if !s.persisted {
+		s.persisted = true
+		s.runHook(s.hooks.OnCreate)
+	}
+	return nil
+}
+
+//Touch extends the loaded session's expiry and refreshes its last access
+//time, e.g. for a keep-alive endpoint. To keep frequent keep-alives cheap, the
+//session is only re-encoded and stored once its expiry would move on by more
+//than the sliding expiration threshold. Until then, only the TTL of its key is
+//refreshed, so last_access may lag by up to that threshold in the cache.
+//Touching a session isn't counted as a renewal.
+func (s *Store) Touch() error {
+
+	if len(s.ID) == 0 || s.Data == nil {
+		return wrapError(ErrNoSessionData, "No session has been loaded to touch")
+	}
+
+	now := uint64(s.now().Unix())
+	s.Data["last_access"] = int64(now)
+
+	extended, err := s.extendExpiration()
 	if err != nil {
 		return err
 	}
 
-	if err := s.storeSession(encodedData); err != nil {
-		return err
+	if extended {
+		return s.save()
 	}
 
-	return nil
+	if s.Expires <= now {
+		return ErrSessionExpired
+	}
+
+	return s.cache.expireSessionData(s.sessionKey(), s.cacheTTL())
+}
+
+//cacheTTL returns how long the cache should hold the session for, so that it
+//is evicted once it has expired. Each write sets it afresh, since a write would
+//otherwise clear the TTL left by an earlier write or Touch. A session which has
+//already expired is kept for expiredSessionTTL, rather than for ever.
+func (s *Store) cacheTTL() time.Duration {
+	now := uint64(s.now().Unix())
+	if s.Expires <= now {
+		return expiredSessionTTL
+	}
+	return time.Duration(s.Expires-now) * time.Second
 }
 
 //Delete will clear the requested session from the backing store. Note: Delete
 //does not clear the loaded session. The Clear method will take care of that.
 //If the string passed in is nil, it will delete the session with an id the same
 //as that of s.ID
+//When deleting the loaded session, it is also removed from its user's session
+//index. Any other session is left in the index, since its user isn't known, as
+//are sessions evicted by their TTL. DeleteUserSessions prunes such stale
+//entries, and the index expires once every session in it has.
 func (s *Store) Delete(id *string) error {
 	sessionID := s.ID
 
@@ -128,126 +522,500 @@ func (s *Store) Delete(id *string) error {
 		sessionID = *id
 	}
 
-	err := s.cache.deleteSessionData(sessionID)
-	return err
+	var userID string
+	if sessionID == s.ID {
+		userID = s.Data.GetUserID()
+	}
+	if err := s.removeSession(sessionID, userID, false); err != nil {
+		return err
+	}
+
+	if sessionID == s.ID {
+		s.destroyed()
+	}
+	return nil
 }
 
 //Clear destroys the current loaded session and removes it from the backing
-//store. It will also regenerate the session ID.
+//store, and from its user's session index, leaving any stale entries there to
+//be pruned as described on Delete. It will also regenerate the session ID. The session's keys are deleted, its index entry removed and, with
+//replay detection enabled, its tombstone stored in a single pipelined round
+//trip where the Cache supports it.
 func (s *Store) Clear() error {
-	err := s.Delete(nil) //Delete the previously stored Session because we're going to regenerate the IDS
+	//Remove the previously stored Session because we're going to regenerate the IDS
+	err := s.removeSession(s.ID, s.Data.GetUserID(), true)
 	if err != nil {
 		return err
 	}
 
+	s.destroyed()
 	s.clearSessionData()
 	err = s.regenerateID()
+	s.cleared = err == nil
 	return err
 }
 
+//RegenerateKeepData issues the session a new ID while keeping its data, e.g.
+//after sign in to prevent session fixation without losing the rest of the
+//session. The session is stored under the new ID before being removed from the
+//cache, and from its user's session index, under the old one; the removal is
+//pipelined where the Cache supports it.
+func (s *Store) RegenerateKeepData() error {
+	oldID := s.ID
+
+	if err := s.regenerateID(); err != nil {
+		return err
+	}
+
+	if err := s.Store(); err != nil {
+		return err
+	}
+
+	if len(oldID) == 0 {
+		return nil
+	}
+
+	return s.removeSession(oldID, s.Data.GetUserID(), false)
+}
+
+//Close closes the Store's Cache, releasing its connections to Redis. As the
+//Cache is usually shared, this should only be called on shutdown, once no other
+//Store is using it.
+func (s *Store) Close() error {
+	return s.cache.Close()
+}
+
+//Cleared reports whether the session has been destroyed by Clear, so that
+//callers can avoid storing it again.
+func (s *Store) Cleared() bool {
+	return s.cleared
+}
+
+//IsCleared is an alias of Cleared.
+func (s *Store) IsCleared() bool {
+	return s.Cleared()
+}
+
+//GetValue returns the value stored under the key in the session data, and
+//whether it was present.
+func (s *Store) GetValue(key string) (interface{}, bool) {
+	value, ok := s.Data[key]
+	return value, ok
+}
+
+//SetValue sets the value stored under the key in the session data, marking the
+//session as dirty until it is next stored.
+func (s *Store) SetValue(key string, value interface{}) {
+	if s.Data == nil {
+		s.clearSessionData()
+	}
+	s.Data[key] = value
+	s.dirty = true
+}
+
+//ClearKey removes the value stored under the key from the session data and
+//stores the session straight away, e.g. to consume a post-login redirect so
+//that it can't be followed twice. The session is stored whether or not the key
+//was present.
+func (s *Store) ClearKey(key string) error {
+	if s.Data == nil {
+		s.clearSessionData()
+	}
+	delete(s.Data, key)
+	s.dirty = true
+	return s.Store()
+}
+
+//Dirty reports whether the session data has been changed through SetValue or
+//ClearKey since the session was last stored.
+func (s *Store) Dirty() bool {
+	return s.dirty
+}
+
 //regenerateID refreshes the token against the Store struct
 func (s *Store) regenerateID() error {
-	octets := make([]byte, idOctets)
-
-	if _, err := rand.Read(octets); err != nil {
+	id, err := generateID(s.getRandom())
+	if err != nil {
 		return err
 	}
 
-	s.ID = encoding.EncodeBase64(octets)
+	s.ID = id
+	s.migrateFromKey = "" // Belonged to the old ID
+	s.storedFields = nil
+	s.loadedValue = ""
 	return nil
 }
 
+//markCreated records the time the session was created, unless it already has
+//one, so that it survives being loaded and stored again.
+func (s *Store) markCreated(now uint64) {
+	if s.Data == nil {
+		return
+	}
+	if _, ok := s.Data.CreatedAt(); !ok {
+		s.Data["created_at"] = int64(now)
+	}
+}
+
+//generateID creates a new base64 encoded ID from the source of randomness
+func generateID(random io.Reader) (string, error) {
+	octets := make([]byte, idOctets())
+
+	if _, err := io.ReadFull(random, octets); err != nil {
+		return "", err
+	}
+
+	return encodeBase64(octets), nil
+}
+
+//encodeBase64 base64 encodes a session ID or signature, with the URL-safe
+//alphabet if configured. IDs and signatures must always be encoded alike, so
+//that signatures still validate.
+func encodeBase64(octets []byte) string {
+	if config.Get().SessionIDURLSafe {
+		return encoding.EncodeBase64URL(octets)
+	}
+	return encoding.EncodeBase64(octets)
+}
+
 //GenerateSignature will generate a new signature based on the Store ID and
 //the cookie secret.
 func (s *Store) GenerateSignature() string {
-	sum := encoding.GenerateSha1Sum([]byte(s.ID + config.Get().CookieSecret))
-	sig := encoding.EncodeBase64(sum[:])
-	//Substring applied here to accommodate for base64 encoded padding of '='
-	return sig[0:signatureLength]
+	return generateSignature(s.ID)
 }
 
-//setupExpiration will set the 'Expires' variable against the Store
-//This should only be called if an expiration is not already set
-func (s *Store) setupExpiration() error {
+//GenerateSignatureFor generates the signature of any session ID, such as one
+//issued by another system, in the same way as GenerateSignature.
+func (s *Store) GenerateSignatureFor(id string) string {
+	return generateSignature(id)
+}
 
-	var err error
+//MintCookieValue returns the session cookie value for any session ID, made up
+//of the ID followed by its signature, e.g. to hand a session issued by another
+//system over to this one. The ID must be the length of a generated session ID
+//for the cookie value to validate.
+func (s *Store) MintCookieValue(id string) string {
+	return id + s.GenerateSignatureFor(id)
+}
 
-	now := uint64(time.Now().Unix())
+//CookieValue returns the value to be set on the session cookie, made up of the
+//Store ID followed by its signature. It must be called after Store, so that
+//the ID has been finalised. If Store kept the session in its cookie, as the
+//Cache was unavailable, the encrypted session is returned instead.
+func (s *Store) CookieValue() string {
+	if s.fallbackValue != "" {
+		return s.fallbackValue
+	}
+	return s.ID + s.GenerateSignature()
+}
 
-	// First and foremost, we prioritise the expiration on session data
-	expirationPeriod := s.Data.GetExpiration()
+//generateSignature will generate a signature for the given ID using the
+//cookie secret.
+func generateSignature(id string) string {
+	return signWithSecret(id, config.Get().CookieSecret)
+}
 
-	if expirationPeriod == uint64(0) {
-		// If that's zero, retrieve the default expiration from environment variables
-		expirationPeriod, err = strconv.ParseUint(config.Get().DefaultExpiration, 0, 64)
+//signWithSecret will generate a signature for the given ID using the given
+//secret.
+func signWithSecret(id string, secret string) string {
+	hash := signatureHash()
+	hash.Write([]byte(id + secret))
+	return encodeSignature(hash.Sum(nil))
+}
+
+//encodeSignature base64 encodes a digest in the same alphabet as session IDs,
+//but without the '=' padding which would otherwise end any digest whose size
+//isn't a multiple of 3 bytes, so that it is exactly signatureLength long.
+func encodeSignature(digest []byte) string {
+	if config.Get().SessionIDURLSafe {
+		return base64.RawURLEncoding.EncodeToString(digest)
+	}
+	return base64.RawStdEncoding.EncodeToString(digest)
+}
+
+//validSignature reports whether sig is the signature of the given ID under the
+//cookie secret or any of its fallbacks, so that the secret can be rotated
+//without invalidating live sessions. Every candidate is compared in constant
+//time.
+func validSignature(id string, sig string) bool {
+	cfg := config.Get()
+
+	valid := subtle.ConstantTimeCompare([]byte(sig), []byte(signWithSecret(id, cfg.CookieSecret))) == 1
+	for _, secret := range cfg.CookieSecretFallbacks {
+		if secret != "" && subtle.ConstantTimeCompare([]byte(sig), []byte(signWithSecret(id, secret))) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+//setupExpiration will set the 'Expires' variable against the Store, and the
+//'expires' value in the session data, so that it is stored with the session.
+//This should only be called if the Store's expiration is not already set. The
+//expiry is then taken from the session data's 'expires' value if it has one, a
+//JWT-style 'exp' claim if it has one, as is, or else set to the expiration
+//period, from 'expires_in' or the default, from now.
+func (s *Store) setupExpiration() error {
+
+	now := uint64(s.now().Unix())
+
+	if expires, _ := session.ToInt64(s.Data["expires"]); expires > 0 {
+		s.Expires = uint64(expires)
+	} else if exp, ok := s.Data.ExpClaim(); ok {
+		s.Expires = exp
+	} else {
+		expirationPeriod, err := s.expirationPeriod()
 		if err != nil {
 			return err
 		}
-	}
 
-	s.Expires = now + expirationPeriod
+		jitter, err := s.expirationJitter(expirationPeriod)
+		if err != nil {
+			return err
+		}
+
+		s.Expires = now + expirationPeriod + jitter
+	}
 
 	if s.Data != nil {
-		s.Data["last_access"] = now
+		s.Data["expires"] = int64(s.Expires)
+		s.Data["last_access"] = int64(now)
 	}
+	s.markCreated(now)
 
 	return nil
 }
 
+//SetExpiration overrides the number of seconds the session lives for, e.g. to
+//give a "remember me" session a longer lifetime than the default, and extends
+//its expiry to match. The override is kept in the session data, so it applies
+//whenever the expiry is set up or slid after the session is loaded again.
+func (s *Store) SetExpiration(seconds uint64) {
+	if s.Data == nil {
+		s.clearSessionData()
+	}
+	s.Data["expiration_period"] = int64(seconds)
+
+	s.Expires = uint64(s.now().Unix()) + seconds
+	s.Data["expires"] = int64(s.Expires)
+}
+
+//expirationPeriod returns the number of seconds a session should live for
+//from now
+func (s *Store) expirationPeriod() (uint64, error) {
+
+	// An override set with SetExpiration takes precedence over everything
+	if override, ok := s.Data.ExpirationOverride(); ok {
+		return override, nil
+	}
+
+	// Otherwise, we prioritise the expiration on session data
+	expirationPeriod := s.Data.GetExpiration()
+
+	if expirationPeriod == uint64(0) {
+		// If that's zero, retrieve the default expiration from environment variables
+		return config.Get().DefaultExpirationSeconds()
+	}
+
+	return expirationPeriod, nil
+}
+
+//expirationJitter returns a random number of seconds, up to the configured
+//percentage of the expiration period, to add to a new session's lifetime so that
+//sessions created together don't all expire together. It only ever lengthens
+//the lifetime, and the percentage is capped at maxExpirationJitter.
+func (s *Store) expirationJitter(expirationPeriod uint64) (uint64, error) {
+
+	percent := config.Get().ExpirationJitter
+	if percent <= 0 {
+		return 0, nil
+	}
+	if percent > maxExpirationJitter {
+		percent = maxExpirationJitter
+	}
+
+	limit := expirationPeriod * uint64(percent) / 100
+	if limit == 0 {
+		return 0, nil
+	}
+
+	octets := make([]byte, 8)
+	if _, err := io.ReadFull(s.getRandom(), octets); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(octets) % (limit + 1), nil
+}
+
+//slideExpiration extends the session's expiry as extendExpiration does,
+//counting each move in the session's renewal_count.
+func (s *Store) slideExpiration() (bool, error) {
+
+	slid, err := s.extendExpiration()
+	if slid {
+		s.Data["renewal_count"] = s.Data.RenewalCount() + 1
+	}
+	return slid, err
+}
+
+//extendExpiration pushes the session's expiry forward by the expiration period,
+//returning true if it moved by more than slidingExpirationThreshold. Smaller
+//moves are ignored so that the session isn't re-stored on every request.
+func (s *Store) extendExpiration() (bool, error) {
+
+	expirationPeriod, err := s.expirationPeriod()
+	if err != nil {
+		return false, err
+	}
+
+	expires := uint64(s.now().Unix()) + expirationPeriod
+	if expires <= s.Expires+slidingExpirationThreshold {
+		return false, nil
+	}
+
+	s.Expires = expires
+	s.Data["expires"] = int64(expires)
+	return true, nil
+}
+
 // validateSessionID will validate the session ID, ensuring it hasn't been
 // manipulated
 func (s *Store) validateSessionID(sessionID string) error {
 
-	if len(sessionID) < cookieValueLength {
+	id, err := splitCookieValue(sessionID)
+	if err != nil {
 		s.clearSessionData()
-		return errors.New("Cookie signature is less than the desired cookie length")
+		return err
 	}
 
-	s.ID = sessionID[0:signatureStart]
-	sig := sessionID[signatureStart:]
+	s.ID = id
+	return nil
+}
+
+// ValidateCookieOnly checks the cookie value's length and signature, returning
+// the session ID it holds, without reading the session from the cache. This
+// lets a lightweight gate reject forged cookies cheaply. The Store itself is
+// left untouched.
+func (s *Store) ValidateCookieOnly(cookieValue string) (string, error) {
+	return splitCookieValue(cookieValue)
+}
+
+//splitCookieValue validates the signature of a cookie value, ensuring it
+//hasn't been manipulated, and returns the session ID it holds
+func splitCookieValue(cookieValue string) (string, error) {
+
+	if len(cookieValue) < cookieValueLength() {
+		return "", ErrCookieTooShort
+	}
+	if len(cookieValue) > cookieValueLength() {
+		return "", ErrCookieTooLong
+	}
+
+	id := cookieValue[0:signatureStart()]
+	sig := cookieValue[signatureStart():]
+
+	if err := checkSignature(id, sig); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+//checkSignature validates that the signature matches the session ID, ensuring
+//neither has been manipulated
+func checkSignature(id string, sig string) error {
+
+	if len(id) != signatureStart() {
+		return errors.New("Session ID is not the expected length")
+	}
 
 	//Validate signature is the same
-	if sig != s.GenerateSignature() {
-		s.clearSessionData()
-		return errors.New("Session signature does not match the expected value! " +
-			"Have " + sig + ", but wanted " + s.GenerateSignature())
+	if !validSignature(id, sig) {
+		return wrapError(ErrInvalidSignature, ErrInvalidSignature.Error()+"! Have "+sig)
 	}
 
 	return nil
 }
 
-//fetchSession will get the session from the Cache
+//fetchSession will get the session from the Cache, returning
+//ErrSessionNotFound if there is no session stored under the Store's ID, so that
+//callers can tell a new visitor apart from a failure of the cache
+//If key versions are in use, each older version is tried in turn on a miss.
 func (s *Store) fetchSession() (string, error) {
+	_, storedSession, err := s.fetchSessionWithKey()
+	return storedSession, err
+}
 
-	storedSession, err := s.cache.getSessionData(s.ID)
-	if err != nil {
-		return "", err
+//fetchSessionWithKey fetches the session in the same way as fetchSession, also
+//returning the key it was found under.
+func (s *Store) fetchSessionWithKey() (string, string, error) {
+
+	s.loadedValue = ""
+	for i, key := range s.sessionKeys(s.ID) {
+		storedSession, err := s.fetchKey(key)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+
+		if i > 0 {
+			// Found under an older version, so move it on the next store
+			s.migrateFromKey = key
+		} else {
+			s.loadedValue = storedSession
+		}
+		return key, storedSession, nil
 	}
 
-	return storedSession, nil
+	return "", "", ErrSessionNotFound
 }
 
-//decodeSession will try to base64 decode the session and then msgpack decode it.
-func (s *Store) decodeSession(session string) (map[string]interface{}, error) {
+//fetchKey will get the value stored at the key from the Cache
+func (s *Store) fetchKey(key string) (string, error) {
 
-	base64DecodedSession, err := encoding.DecodeBase64(session)
+	span := s.startSpan("get", len(key))
+	start, dials := time.Now(), s.cache.dials()
+	storedSession, err := s.cache.getSessionData(key)
+	span.End(err)
+	s.observe("get", start, dials, err)
+	s.getMetrics().ObserveRedisLatency(time.Since(start))
+
+	return storedSession, err
+}
+
+//decodeSession will try to decode the session stored under the given key, and
+//field if it is stored as a hash, using the Store's Serializer, having first
+//verified its integrity check, if enabled, and undone its Transforms.
+func (s *Store) decodeSession(session string, key string, field string) (map[string]interface{}, error) {
+	transformed, err := openSessionData(session, key, field)
 	if err != nil {
 		return nil, err
 	}
 
-	msgpackDecodedSession, err := encoding.DecodeMsgPack(base64DecodedSession)
+	encoded, err := s.reverseTransforms(transformed)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.getSerializer().Decode(encoded)
 	if err != nil {
 		return nil, err
 	}
 
-	return msgpackDecodedSession, nil
+	normalizeSession(data)
+	return data, nil
 }
 
 //validateExpiration validates that the Expires and Expiration values on the
-//Store object are valid, and sets them if required.
+//Store object are valid, and sets them if required. If an idle timeout is
+//configured, it also validates that the session was last accessed within it.
 func (s *Store) validateExpiration() error {
 
-	s.Expires = uint64(s.Data["expires"].(uint32))
+	expires, _ := session.ToInt64(s.Data["expires"])
+	s.Expires = uint64(expires)
+
+	// Read before setupExpiration stamps the session as accessed now
+	lastAccess, accessed := s.Data.LastAccess()
 
 	if s.Expires == uint64(0) {
 		err := s.setupExpiration()
@@ -256,10 +1024,22 @@ func (s *Store) validateExpiration() error {
 		}
 	}
 
-	now := uint64(time.Now().Unix())
+	now := uint64(s.now().Unix())
 
 	if s.Expires <= now {
-		return errors.New("Store has expired")
+		return ErrSessionExpired
+	}
+
+	// Independently of 'expires', reject a session left idle for too long
+	if idleTimeout := config.Get().IdleTimeout; idleTimeout > 0 {
+		if accessed && s.now().Sub(lastAccess) > time.Duration(idleTimeout)*time.Second {
+			return wrapError(ErrSessionExpired, "Store has been idle for longer than the idle timeout")
+		}
+	}
+
+	// Force re-authentication once a session has been slid too many times
+	if maxRenewals := config.Get().MaxRenewals; maxRenewals > 0 && s.Data.RenewalCount() > int64(maxRenewals) {
+		return wrapError(ErrSessionExpired, "Store has been renewed more than the maximum number of times")
 	}
 
 	return nil
@@ -268,22 +1048,81 @@ func (s *Store) validateExpiration() error {
 //storeSession will take the valid Store object and save it in Redis
 func (s *Store) storeSession(encodedData string) error {
 
-	var err error
-	_, err = s.cache.setSessionData(s.ID, encodedData).Result()
-	return err
+	if s.optimisticLocking {
+		return s.storeSessionCAS(encodedData)
+	}
+
+	if s.cache.transactions && s.setCondition == SetAlways {
+		return s.storeSessionTx(encodedData)
+	}
+
+	key := s.sessionKey()
+	span := s.startSpan("set", len(key))
+	start, dials := time.Now(), s.cache.dials()
+	applied, err := s.cache.setSessionDataIf(key, encodedData, s.setCondition, s.cacheTTL())
+	span.End(err)
+	s.observe("set", start, dials, err)
+	s.getMetrics().ObserveRedisLatency(time.Since(start))
+	if err != nil {
+		return err
+	}
+
+	if !applied {
+		return ErrNotApplied
+	}
+	return s.stored()
 }
 
-//encodeSessionData performs the messagepack and base 64 encoding on the
-//session data and returns the result, or an error if one occurs
-func (s *Store) encodeSessionData() (string, error) {
+//stored completes storing the session once it has been written to the cache,
+//removing any copy left under an older key version and indexing it by user.
+func (s *Store) stored() error {
+	s.getMetrics().IncStore()
+	s.dirty = false
+
+	// Remove the copy of the session left under an older key version
+	if s.migrateFromKey != "" {
+		if err := s.deleteKeys(s.migrateFromKey); err != nil {
+			return err
+		}
+		s.migrateFromKey = ""
+	}
 
-	msgpackEncodedData, err := encoding.EncodeMsgPack(s.Data)
+	if userID := s.Data.GetUserID(); userID != "" {
+		return s.cache.addToIndex(userSessionsKey(userID), s.ID, s.cacheTTL())
+	}
+	return nil
+}
+
+//encodeData encodes the data using the Store's Serializer, then applies its
+//Transforms and adds an integrity check, for the key and field it is to be
+//stored under, if enabled, and returns the result, or an error if one occurs
+func (s *Store) encodeData(data map[string]interface{}, key string, field string) (string, error) {
+	encoded, err := s.getSerializer().Encode(data)
 	if err != nil {
 		return "", err
 	}
 
-	b64EncodedData := encoding.EncodeBase64(msgpackEncodedData)
-	return b64EncodedData, nil
+	transformed, err := s.applyTransforms(encoded)
+	if err != nil {
+		return "", err
+	}
+	return sealSessionData(transformed, key, field), nil
+}
+
+//encodeSessionData performs the encoding on the session data using the Store's
+//Serializer and returns the result, or an error if one occurs. If the encoded
+//session is larger than the configured maximum, ErrSessionTooLarge is returned.
+func (s *Store) encodeSessionData() (string, error) {
+	encoded, err := s.encodeData(s.Data, s.sessionKey(), "")
+	if err != nil {
+		return "", err
+	}
+
+	if maxBytes := config.Get().MaxSessionBytes; maxBytes > 0 && len(encoded) > maxBytes {
+		s.getLogger().Warn(ErrSessionTooLarge.Error(), map[string]interface{}{"session_id": s.ID, "size": len(encoded), "limit": maxBytes})
+		return "", wrapError(ErrSessionTooLarge, fmt.Sprintf("Session is %d bytes once encoded, over the limit of %d", len(encoded), maxBytes))
+	}
+	return encoded, nil
 }
 
 // clearSessionData will set the session data to an empty map
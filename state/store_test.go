@@ -1,8 +1,14 @@
 package state
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
+	"hash"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -12,6 +18,7 @@ import (
 	mockState "github.com/companieshouse/go-session-handler/state/mocks"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/mock"
+	"github.com/vmihailenco/msgpack"
 
 	redis "gopkg.in/redis.v5"
 )
@@ -20,7 +27,7 @@ func getConfig() *config.Config {
 	return &config.Config{
 		DefaultExpiration: "60",
 		CookieName:        "TEST",
-		CookieSecret:      strings.Repeat("b", signatureLength),
+		CookieSecret:      strings.Repeat("b", signatureLength()),
 	}
 }
 
@@ -43,7 +50,7 @@ func TestUnitSetSessionErrorOnSave(t *testing.T) {
 	Convey("Given a Redis error is thrown when saving session data", t, func() {
 
 		connection := &mockState.Connection{}
-		connection.On("Set", "", "", time.Duration(0)).
+		connection.On("Set", "", "", mock.AnythingOfType("time.Duration")).
 			Return(redis.NewStatusResult("", errors.New("Unsuccessful save")))
 
 		Convey("When I initialise the Store and try to save it", func() {
@@ -63,6 +70,57 @@ func TestUnitSetSessionErrorOnSave(t *testing.T) {
 	})
 }
 
+// TestUnitSetSessionConditionNotMet - Verify that a conditional set which isn't
+// applied is reported distinctly from an error
+func TestUnitSetSessionConditionNotMet(t *testing.T) {
+
+	Convey("Given the session should only be stored if it's absent from Redis", t, func() {
+
+		connection := &mockState.Connection{}
+		connection.On("SetNX", "abc", "", mock.AnythingOfType("time.Duration")).
+			Return(redis.NewBoolResult(false, nil))
+
+		s := NewStore(&Cache{connection: connection}).WithSetCondition(SetIfAbsent)
+		s.ID = "abc"
+
+		Convey("When I try to save it but it's already present", func() {
+
+			err := s.storeSession("")
+
+			Convey("Then I expect ErrNotApplied to be returned", func() {
+
+				So(err, ShouldEqual, ErrNotApplied)
+				connection.AssertNotCalled(t, "Set", "abc", "", mock.AnythingOfType("time.Duration"))
+			})
+		})
+	})
+}
+
+// TestUnitSetSessionConditionMet - Verify that a conditional set which is applied
+// returns no error
+func TestUnitSetSessionConditionMet(t *testing.T) {
+
+	Convey("Given the session should only be stored if it's present in Redis", t, func() {
+
+		connection := &mockState.Connection{}
+		connection.On("SetXX", "abc", "", mock.AnythingOfType("time.Duration")).
+			Return(redis.NewBoolResult(true, nil))
+
+		s := NewStore(&Cache{connection: connection}).WithSetCondition(SetIfPresent)
+		s.ID = "abc"
+
+		Convey("When I try to save it and it's present", func() {
+
+			err := s.storeSession("")
+
+			Convey("Then I expect no errors", func() {
+
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
 // ------------------- Routes Through GetSession() -------------------
 
 // TestUnitGetSessionErrorPath - Verify error trapping if any errors are returned
@@ -96,6 +154,31 @@ func TestUnitGetSessionErrorPath(t *testing.T) {
 	})
 }
 
+// TestUnitGetSessionNotFound - Verify that a session missing from redis is
+// reported as ErrSessionNotFound rather than as a redis error
+func TestUnitGetSessionNotFound(t *testing.T) {
+
+	Convey("Given redis has no session data stored for the ID", t, func() {
+
+		connection := &mockState.Connection{}
+		connection.On("Get", mock.AnythingOfType("string")).
+			Return(redis.NewStringResult("", redis.Nil))
+
+		Convey("When I initialise the Store and try to get the session", func() {
+
+			s := &Store{cache: &Cache{connection: connection}}
+
+			session, err := s.fetchSession()
+
+			Convey("Then I expect ErrSessionNotFound to be returned", func() {
+
+				So(err, ShouldEqual, ErrSessionNotFound)
+				So(session, ShouldBeBlank)
+			})
+		})
+	})
+}
+
 // TestUnitGetSessionHappyPath - Verify no errors are returned when following the
 // GetSession 'happy path'
 func TestUnitGetSessionHappyPath(t *testing.T) {
@@ -164,7 +247,7 @@ func TestUnitStoreErrorInSetSession(t *testing.T) {
 
 			connection := &mockState.Connection{}
 			connection.On("Set", mock.AnythingOfType("string"),
-				mock.AnythingOfType("string"), time.Duration(0)).
+				mock.AnythingOfType("string"), 123*time.Second).
 				Return(redis.NewStatusResult("", errors.New("Error saving session data")))
 
 			c := &Cache{connection: connection}
@@ -207,7 +290,7 @@ func TestUnitStoreHappyPath(t *testing.T) {
 
 			connection := &mockState.Connection{}
 			connection.On("Set", mock.AnythingOfType("string"),
-				mock.AnythingOfType("string"), time.Duration(0)).
+				mock.AnythingOfType("string"), 123*time.Second).
 				Return(redis.NewStatusResult("", nil))
 
 			c := &Cache{connection: connection}
@@ -306,6 +389,150 @@ func TestUnitValidateExpirationNoExpirationSet(t *testing.T) {
 	cleanupConfig()
 }
 
+// TestUnitValidateExpirationIdleTimeout - Verify that when an idle timeout is
+// configured, only sessions accessed within it are valid
+func TestUnitValidateExpirationIdleTimeout(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given an idle timeout of 5 minutes is configured", t, func() {
+
+		cfg := config.Get()
+		cfg.IdleTimeout = 300
+		defer func() { cfg.IdleTimeout = 0 }()
+
+		now := time.Now().Unix()
+		expires := uint32(now + 3600)
+
+		Convey("When I validate a session accessed a minute ago", func() {
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{"expires": expires, "last_access": uint32(now - 60)}
+
+			err := s.validateExpiration()
+
+			Convey("Then no errors should be returned", func() {
+
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I validate a session within its expiry but accessed an hour ago", func() {
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{"expires": expires, "last_access": uint32(now - 3600)}
+
+			err := s.validateExpiration()
+
+			Convey("Then an appropriate error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Store has been idle for longer than the idle timeout")
+			})
+		})
+
+		Convey("When I validate a session with no expiry but accessed an hour ago", func() {
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{
+				"last_access": int64(now - 3600),
+				"signin_info": map[string]interface{}{
+					"access_token": map[string]interface{}{
+						"expires_in": uint16(3600),
+					},
+				},
+			}
+
+			err := s.validateExpiration()
+
+			Convey("Then an appropriate error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Store has been idle for longer than the idle timeout")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitValidateExpirationMaxRenewals - Verify that a session renewed more than
+// the configured maximum number of times is rejected, while one under it renews
+func TestUnitValidateExpirationMaxRenewals(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a maximum of 50 renewals is configured", t, func() {
+
+		cfg := config.Get()
+		cfg.MaxRenewals = 50
+		defer func() { cfg.MaxRenewals = 0 }()
+
+		expires := uint32(time.Now().Unix() + 600)
+
+		Convey("When I validate and slide a session renewed 49 times", func() {
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{
+				"expires":       expires,
+				"renewal_count": uint8(49),
+				"signin_info": map[string]interface{}{
+					"access_token": map[string]interface{}{
+						"expires_in": uint16(3600),
+					},
+				},
+			}
+
+			err := s.validateExpiration()
+			So(err, ShouldBeNil)
+			slid, err := s.slideExpiration()
+
+			Convey("Then it should be renewed and its renewal counted", func() {
+
+				So(err, ShouldBeNil)
+				So(slid, ShouldBeTrue)
+				So(s.Data.RenewalCount(), ShouldEqual, 50)
+				So(s.Data["renewal_count"], ShouldHaveSameTypeAs, int64(0))
+			})
+		})
+
+		Convey("When I validate a session renewed 51 times", func() {
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{"expires": expires, "renewal_count": uint8(51)}
+
+			err := s.validateExpiration()
+
+			Convey("Then an appropriate error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Store has been renewed more than the maximum number of times")
+			})
+		})
+	})
+
+	Convey("Given no maximum number of renewals is configured", t, func() {
+
+		s := NewStore(nil)
+		s.Data = map[string]interface{}{
+			"expires":       uint32(time.Now().Unix() + 600),
+			"renewal_count": uint16(1000),
+		}
+
+		Convey("When I validate a session renewed many times", func() {
+
+			err := s.validateExpiration()
+
+			Convey("Then no errors should be returned", func() {
+
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
 // ------------------- Routes Through Delete() -------------------
 
 // TestUnitDeleteErrorPath - Verify error trapping is enforced if there's an
@@ -438,6 +665,8 @@ func TestUnitClearHappyPath(t *testing.T) {
 					So(err, ShouldBeNil)
 					So(s.ID, ShouldNotEqual, "abc")
 					So(len(s.Data), ShouldEqual, 0)
+					So(s.Cleared(), ShouldBeTrue)
+					So(s.IsCleared(), ShouldBeTrue)
 				})
 		})
 	})
@@ -455,7 +684,7 @@ func TestUnitValidateCookieSignatureLengthInvalid(t *testing.T) {
 
 	Convey("Given the cookie signature is less than the desired length", t, func() {
 
-		sig := strings.Repeat("a", cookieValueLength-1)
+		sig := strings.Repeat("a", cookieValueLength()-1)
 
 		Convey("When I initialise the Store and try to validate it, provided there are no Redis errors", func() {
 
@@ -486,11 +715,11 @@ func TestUnitValidateSessionIDHappyPath(t *testing.T) {
 
 	Convey("Given the session ID is valid", t, func() {
 
-		id := strings.Repeat("a", signatureStart)
+		id := strings.Repeat("a", signatureStart())
 		signatureByte := encoding.GenerateSha1Sum([]byte(id + "hello"))
 		signature := encoding.EncodeBase64(signatureByte[:])
 
-		sessionID := id + signature[0:signatureLength]
+		sessionID := id + signature[0:signatureLength()]
 
 		Convey("When I initialise the Store and try to validate it", func() {
 
@@ -507,6 +736,212 @@ func TestUnitValidateSessionIDHappyPath(t *testing.T) {
 	cleanupConfig()
 }
 
+// ---------------- Routes Through ValidateCookieOnly() ----------------
+
+// TestUnitValidateCookieOnly - Verify that a cookie's length and signature are
+// checked without touching Redis or the Store's data
+func TestUnitValidateCookieOnly(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a Store with data loaded and no Redis expectations", t, func() {
+
+		connection := &mockState.Connection{}
+		s := NewStore(&Cache{connection: connection})
+		s.ID = "existing"
+		s.Data = map[string]interface{}{"test": "hello, world!"}
+
+		id := strings.Repeat("a", signatureStart())
+
+		// Redis should never be called, and the Store should be left untouched
+		assertUntouched := func() {
+			So(connection.Calls, ShouldBeEmpty)
+			So(s.ID, ShouldEqual, "existing")
+			So(s.Data["test"], ShouldEqual, "hello, world!")
+		}
+
+		Convey("When I validate a correctly signed cookie", func() {
+
+			sessionID, err := s.ValidateCookieOnly(id + generateSignature(id))
+
+			Convey("Then the session ID should be returned", func() {
+
+				So(err, ShouldBeNil)
+				So(sessionID, ShouldEqual, id)
+				So(len(generateSignature(id)), ShouldEqual, signatureLength())
+				assertUntouched()
+			})
+		})
+
+		Convey("When I validate a cookie which is too short", func() {
+
+			sessionID, err := s.ValidateCookieOnly(strings.Repeat("a", cookieValueLength()-1))
+
+			Convey("Then a length error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Cookie signature is less than the desired cookie length")
+				So(sessionID, ShouldBeBlank)
+				assertUntouched()
+			})
+		})
+
+		Convey("When I validate a correctly signed cookie which has been padded", func() {
+
+			sessionID, err := s.ValidateCookieOnly(id + generateSignature(id) + "=")
+
+			Convey("Then a length error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Cookie signature is greater than the desired cookie length")
+				So(sessionID, ShouldBeBlank)
+				assertUntouched()
+			})
+		})
+
+		Convey("When I validate a cookie with a tampered signature", func() {
+
+			sessionID, err := s.ValidateCookieOnly(id + strings.Repeat("x", signatureLength()))
+
+			Convey("Then a signature error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "Session signature does not match the expected value!")
+				So(sessionID, ShouldBeBlank)
+				assertUntouched()
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitSignatureLength - Verify that the signature length is derived from
+// the signature hash, so that signatures of any size are split from the session
+// ID consistently, without their padding
+func TestUnitSignatureLength(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a Store", t, func() {
+
+		s := NewStore(nil)
+		id := strings.Repeat("a", signatureStart())
+
+		Convey("When signatures are SHA-1 digests", func() {
+
+			sum := sha1.Sum([]byte(id + config.Get().CookieSecret))
+			signature := generateSignature(id)
+
+			Convey("Then they should be the unpadded base64 of the digest, 27 characters long", func() {
+
+				So(signatureLength(), ShouldEqual, 27)
+				So(signature, ShouldEqual, strings.TrimRight(base64.StdEncoding.EncodeToString(sum[:]), "="))
+				So(len(signature), ShouldEqual, signatureLength())
+			})
+
+			Convey("And the session ID should be extracted from a signed cookie", func() {
+
+				sessionID, err := s.ValidateCookieOnly(id + signature)
+
+				So(err, ShouldBeNil)
+				So(sessionID, ShouldEqual, id)
+			})
+		})
+
+		Convey("When signatures are SHA-256 digests", func() {
+
+			defer func(hash func() hash.Hash) { signatureHash = hash }(signatureHash)
+			signatureHash = sha256.New
+
+			sum := sha256.Sum256([]byte(id + config.Get().CookieSecret))
+			signature := generateSignature(id)
+
+			Convey("Then they should be the unpadded base64 of the digest, 43 characters long", func() {
+
+				So(signatureLength(), ShouldEqual, 43)
+				So(signature, ShouldEqual, strings.TrimRight(base64.StdEncoding.EncodeToString(sum[:]), "="))
+				So(signature, ShouldNotContainSubstring, "=")
+				So(cookieValueLength(), ShouldEqual, signatureStart()+43)
+			})
+
+			Convey("And the session ID should be extracted from a signed cookie", func() {
+
+				sessionID, err := s.ValidateCookieOnly(id + signature)
+
+				So(err, ShouldBeNil)
+				So(sessionID, ShouldEqual, id)
+			})
+
+			Convey("And a cookie signed with a SHA-1 digest should be too short", func() {
+
+				sum := sha1.Sum([]byte(id + config.Get().CookieSecret))
+				_, err := s.ValidateCookieOnly(id + base64.RawStdEncoding.EncodeToString(sum[:]))
+
+				So(unwrap(err), ShouldEqual, ErrCookieTooShort)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitValidateCookieSecretRotation - Verify that a cookie signed with a
+// fallback secret still validates, while new cookies are signed with the primary
+func TestUnitValidateCookieSecretRotation(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given the cookie secret has been rotated, keeping the old one as a fallback", t, func() {
+
+		cfg := config.Get()
+		cfg.CookieSecret = "new-secret"
+		cfg.CookieSecretFallbacks = []string{"old-secret"}
+		defer func() {
+			cfg.CookieSecret = "hello"
+			cfg.CookieSecretFallbacks = nil
+		}()
+
+		id := strings.Repeat("a", signatureStart())
+		s := NewStore(nil)
+
+		Convey("When I validate a cookie signed with the old secret", func() {
+
+			sessionID, err := s.ValidateCookieOnly(id + signWithSecret(id, "old-secret"))
+
+			Convey("Then it should still be valid", func() {
+
+				So(err, ShouldBeNil)
+				So(sessionID, ShouldEqual, id)
+			})
+		})
+
+		Convey("When I validate a cookie signed with an unknown secret", func() {
+
+			_, err := s.ValidateCookieOnly(id + signWithSecret(id, "other-secret"))
+
+			Convey("Then it should be rejected", func() {
+
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I generate the cookie value for a session", func() {
+
+			s.ID = id
+			cookieValue := s.CookieValue()
+
+			Convey("Then it should be signed with the new secret", func() {
+
+				So(cookieValue, ShouldEqual, id+signWithSecret(id, "new-secret"))
+				So(cookieValue, ShouldNotEqual, id+signWithSecret(id, "old-secret"))
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
 // ---------------- Routes Through decodeSession() ----------------
 
 // TestUnitDecodeSessionBase64Invalid - Verify that if a cookie doesn't exist by
@@ -520,7 +955,7 @@ func TestUnitDecodeSessionBase64Invalid(t *testing.T) {
 		s := NewStore(nil)
 
 		Convey("When the Store tries to decode it", func() {
-			decodedSession, err := s.decodeSession("Hello")
+			decodedSession, err := s.decodeSession("Hello", "", "")
 
 			Convey("Then I should have a blank decoded session", func() {
 				So(decodedSession, ShouldBeNil)
@@ -548,7 +983,7 @@ func TestUnitDecodeSessionMessagepackInvalid(t *testing.T) {
 
 		Convey("When the Store tries to decode it", func() {
 
-			decodedSession, err := s.decodeSession("SGVsbG8=")
+			decodedSession, err := s.decodeSession("SGVsbG8=", "", "")
 
 			Convey("Then I should have a blank decoded session", func() {
 
@@ -575,7 +1010,7 @@ func TestUnitLoadErrorInValidateSignature(t *testing.T) {
 
 	Convey("Given I have a session ID less than the desired length", t, func() {
 
-		sessionID := strings.Repeat("a", cookieValueLength-1)
+		sessionID := strings.Repeat("a", cookieValueLength()-1)
 
 		Convey("And Redis throws no further errors", func() {
 
@@ -610,12 +1045,12 @@ func TestUnitLoadErrorRetrievingSession(t *testing.T) {
 
 	Convey("Given I have a valid session ID", t, func() {
 
-		id := strings.Repeat("a", signatureStart)
+		id := strings.Repeat("a", signatureStart())
 
 		signatureByte := encoding.GenerateSha1Sum([]byte(id + "hello"))
 		signature := encoding.EncodeBase64(signatureByte[:])
 
-		sessionID := id + signature[0:signatureLength]
+		sessionID := id + signature[0:signatureLength()]
 
 		Convey("If Redis returns an error", func() {
 
@@ -643,20 +1078,91 @@ func TestUnitLoadErrorRetrievingSession(t *testing.T) {
 	cleanupConfig()
 }
 
-// TestUnitLoadErrorDecodingSession - Verify error trapping whilst decoding session
-// data on load
-func TestUnitLoadErrorDecodingSession(t *testing.T) {
+// TestUnitWithCache - Verify that after the cache is swapped, sessions are
+// fetched through the new cache's connection
+func TestUnitWithCache(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a Store using an old cache", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+
+		oldConnection := &mockState.Connection{}
+		newConnection := &mockState.Connection{}
+		newConnection.On("Get", id).Return(redis.NewStringResult("", redis.Nil))
+
+		s := NewStore(&Cache{connection: oldConnection})
+		s.ID = id
+
+		Convey("When I swap it to a new cache and fetch the session", func() {
+
+			s.WithCache(&Cache{connection: newConnection})
+			_, err := s.fetchSession()
+
+			Convey("Then only the new cache's connection should be used", func() {
+
+				So(err, ShouldEqual, ErrSessionNotFound)
+				newConnection.AssertCalled(t, "Get", id)
+				oldConnection.AssertNotCalled(t, "Get", id)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// timeoutError is the error a Redis command returns having timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "read tcp 127.0.0.1:6379: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// TestUnitLoadTimeout - Verify that a Redis command timing out fails the load,
+// rather than being treated as a missing session
+func TestUnitLoadTimeout(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given Redis times out retrieving a session", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult("", timeoutError{}))
+
+		Convey("When I attempt to load the session", func() {
+
+			s := NewStore(&Cache{connection: connection})
+			err := s.Load(sessionID)
+
+			Convey("Then the timeout should be returned", func() {
+
+				So(err, ShouldResemble, timeoutError{})
+				So(err, ShouldNotEqual, ErrSessionNotFound)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitLoadErrorDecodingSession - Verify error trapping whilst decoding session
+// data on load
+func TestUnitLoadErrorDecodingSession(t *testing.T) {
 
 	initConfig()
 
 	Convey("Given I have a valid session ID", t, func() {
 
-		id := strings.Repeat("a", signatureStart)
+		id := strings.Repeat("a", signatureStart())
 
 		signatureByte := encoding.GenerateSha1Sum([]byte(id + "hello"))
 		signature := encoding.EncodeBase64(signatureByte[:])
 
-		sessionID := id + signature[0:signatureLength]
+		sessionID := id + signature[0:signatureLength()]
 
 		Convey("If Redis returns blank data", func() {
 
@@ -682,3 +1188,1247 @@ func TestUnitLoadErrorDecodingSession(t *testing.T) {
 
 	cleanupConfig()
 }
+
+// TestUnitLoadInvalidSessionFormat - Verify that a session which doesn't decode
+// to a map is treated as corrupt, starting a fresh session
+func TestUnitLoadInvalidSessionFormat(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a valid session ID whose stored data is a msgpack array", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		packed, err := msgpack.Marshal([]interface{}{"foo", "bar"})
+		So(err, ShouldBeNil)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult(encoding.EncodeBase64(packed), nil))
+
+		Convey("When I attempt to load the session", func() {
+
+			s := NewStore(&Cache{connection: connection})
+			err := s.Load(sessionID)
+
+			Convey("Then no error should be returned and a fresh session started", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data, ShouldBeEmpty)
+				So(s.ID, ShouldBeBlank)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitLoadSessionNotFound - Verify that loading a session missing from Redis
+// starts a fresh session rather than returning an error
+func TestUnitLoadSessionNotFound(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a valid session ID for a session not in Redis", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult("", redis.Nil))
+
+		Convey("When I attempt to load the session", func() {
+
+			s := NewStore(&Cache{connection: connection})
+			err := s.Load(sessionID)
+
+			Convey("Then no error should be returned and the session should be empty", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data, ShouldBeEmpty)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ---------------- Routes Through LoadParts() ----------------
+
+// TestUnitLoadParts - Verify that a session can be loaded from a separately
+// supplied ID and signature, and that invalid parts start a fresh session
+func TestUnitLoadParts(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a session is stored", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+
+		encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+			"test":    "hello, world!",
+			"expires": uint32(time.Now().Unix() + 3600),
+		})
+		So(err, ShouldBeNil)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+
+		s := NewStore(&Cache{connection: connection})
+
+		Convey("When I load it from a valid ID and signature", func() {
+
+			err := s.LoadParts(id, generateSignature(id))
+
+			Convey("Then the session should be loaded", func() {
+
+				So(err, ShouldBeNil)
+				So(s.ID, ShouldEqual, id)
+				So(s.Data["test"], ShouldEqual, "hello, world!")
+			})
+		})
+
+		Convey("When I load it with a mismatched signature", func() {
+
+			err := s.LoadParts(id, generateSignature(strings.Repeat("b", signatureStart())))
+
+			Convey("Then a fresh session should be started without reading the cache", func() {
+
+				So(err, ShouldBeNil)
+				So(s.ID, ShouldBeBlank)
+				So(s.Data, ShouldBeEmpty)
+				connection.AssertNotCalled(t, "Get", id)
+			})
+		})
+
+		Convey("When I load it with a short ID", func() {
+
+			shortID := id[1:]
+			err := s.LoadParts(shortID, generateSignature(shortID))
+
+			Convey("Then a fresh session should be started without reading the cache", func() {
+
+				So(err, ShouldBeNil)
+				So(s.ID, ShouldBeBlank)
+				So(s.Data, ShouldBeEmpty)
+				So(connection.Calls, ShouldBeEmpty)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ---------------- Routes Through LoadMany() ----------------
+
+// TestUnitLoadMany - Verify that many sessions are loaded with a single MGET,
+// skipping invalid signatures and missing sessions
+func TestUnitLoadMany(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a mix of stored, missing and forged session IDs", t, func() {
+
+		stored := strings.Repeat("a", signatureStart())
+		missing := strings.Repeat("b", signatureStart())
+		forged := strings.Repeat("c", signatureStart())
+
+		encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{"test": "hello, world!"})
+		So(err, ShouldBeNil)
+
+		connection := &mockState.Connection{}
+		connection.On("MGet", stored, missing).
+			Return(redis.NewSliceResult([]interface{}{encoded, nil}, nil))
+
+		Convey("When I load them all at once", func() {
+
+			s := NewStore(&Cache{connection: connection})
+			sessions, err := s.LoadMany([]string{
+				stored + generateSignature(stored),
+				missing + generateSignature(missing),
+				forged + strings.Repeat("x", signatureLength()),
+			})
+
+			Convey("Then only the stored session should be returned, from a single MGET", func() {
+
+				So(err, ShouldBeNil)
+				So(sessions, ShouldHaveLength, 1)
+				So(sessions[stored+generateSignature(stored)]["test"], ShouldEqual, "hello, world!")
+				connection.AssertNumberOfCalls(t, "MGet", 1)
+			})
+		})
+	})
+
+	Convey("Given Redis returns an error", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+
+		connection := &mockState.Connection{}
+		connection.On("MGet", id).
+			Return(redis.NewSliceResult(nil, errors.New("Unsuccessful session retrieval")))
+
+		Convey("When I load many sessions", func() {
+
+			_, err := NewStore(&Cache{connection: connection}).LoadMany([]string{id + generateSignature(id)})
+
+			Convey("Then the error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ---------------- Routes Through CookieValue() ----------------
+
+// TestUnitCookieValueRoundTrip - Verify that the cookie value of a stored session
+// passes validation and resolves back to the same session ID
+func TestUnitCookieValueRoundTrip(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have stored a new session", t, func() {
+
+		connection := &mockState.Connection{}
+		connection.On("Set", mock.AnythingOfType("string"),
+			mock.AnythingOfType("string"), 123*time.Second).
+			Return(redis.NewStatusResult("", nil))
+
+		s := NewStore(&Cache{connection: connection})
+		s.Data = map[string]interface{}{
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in": uint16(123),
+				},
+			},
+		}
+
+		So(s.Store(), ShouldBeNil)
+
+		Convey("When I validate its cookie value against a new Store", func() {
+
+			cookieValue := s.CookieValue()
+
+			validated := NewStore(nil)
+			err := validated.validateSessionID(cookieValue)
+
+			Convey("Then it should be valid and resolve to the same ID", func() {
+
+				So(err, ShouldBeNil)
+				So(len(cookieValue), ShouldEqual, cookieValueLength())
+				So(validated.ID, ShouldEqual, s.ID)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ---------------- Routes Through regenerateID() ----------------
+
+// TestUnitRegenerateIDCustomLength - Verify that session IDs of a configured length
+// round-trip through generation and validation
+func TestUnitRegenerateIDCustomLength(t *testing.T) {
+
+	initConfig()
+
+	for _, octets := range []int{16, 33} {
+
+		Convey("Given the session ID length is configured as "+strconv.Itoa(octets)+" octets", t, func() {
+
+			cfg := config.Get()
+			cfg.SessionIDOctets = octets
+			defer func() { cfg.SessionIDOctets = 0 }()
+
+			Convey("When I generate a new session ID and validate its cookie value", func() {
+
+				s := NewStore(nil)
+				So(s.regenerateID(), ShouldBeNil)
+
+				validated := NewStore(nil)
+				err := validated.validateSessionID(s.CookieValue())
+
+				Convey("Then the ID should be of the configured length and validate successfully", func() {
+
+					decoded, _ := encoding.DecodeBase64(s.ID)
+					So(len(decoded), ShouldEqual, octets)
+					So(err, ShouldBeNil)
+					So(validated.ID, ShouldEqual, s.ID)
+				})
+			})
+		})
+	}
+
+	cleanupConfig()
+}
+
+// TestUnitRegenerateIDWithRandom - Verify that session IDs are generated from
+// the configured source of randomness
+func TestUnitRegenerateIDWithRandom(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a Store with a fixed source of randomness", t, func() {
+
+		s := NewStore(nil).WithRandom(bytes.NewReader(bytes.Repeat([]byte{0xff}, defaultIDOctets)))
+
+		Convey("When I generate a new session ID", func() {
+
+			err := s.regenerateID()
+
+			Convey("Then the ID should be derived from the source", func() {
+
+				So(err, ShouldBeNil)
+				So(s.ID, ShouldEqual, strings.Repeat("/", signatureStart()))
+			})
+		})
+
+		Convey("When I generate a second session ID once the source is exhausted", func() {
+
+			So(s.regenerateID(), ShouldBeNil)
+			err := s.regenerateID()
+
+			Convey("Then an error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitURLSafeSessionIDs - Verify that with URL-safe IDs configured, IDs and
+// signatures contain no '+' or '/', and the cookie value still validates
+func TestUnitURLSafeSessionIDs(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given URL-safe session IDs are configured", t, func() {
+
+		cfg := config.Get()
+		cfg.SessionIDURLSafe = true
+		defer func() { cfg.SessionIDURLSafe = false }()
+
+		s := NewStore(nil).WithRandom(bytes.NewReader(bytes.Repeat([]byte{0xfb, 0xef, 0xff}, defaultIDOctets/3)))
+
+		Convey("When I generate a new session ID and sign it", func() {
+
+			So(s.regenerateID(), ShouldBeNil)
+			cookieValue := s.CookieValue()
+
+			Convey("Then neither should contain '+' or '/', and the cookie value should validate", func() {
+
+				So(s.ID, ShouldEqual, strings.Repeat("--__", signatureStart()/4))
+				So(cookieValue, ShouldNotContainSubstring, "+")
+				So(cookieValue, ShouldNotContainSubstring, "/")
+
+				id, err := s.ValidateCookieOnly(cookieValue)
+				So(err, ShouldBeNil)
+				So(id, ShouldEqual, s.ID)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitMintCookieValue - Verify that a cookie value minted for a supplied ID
+// validates as that session's ID
+func TestUnitMintCookieValue(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have an ID issued by another system", t, func() {
+
+		id := strings.Repeat("s", signatureStart())
+		s := NewStore(nil)
+
+		Convey("When I mint a cookie value for it", func() {
+
+			cookieValue := s.MintCookieValue(id)
+
+			Convey("Then it should be the ID followed by its signature", func() {
+
+				So(cookieValue, ShouldEqual, id+s.GenerateSignatureFor(id))
+				So(s.GenerateSignatureFor(id), ShouldEqual, generateSignature(id))
+			})
+
+			Convey("Then it should pass validation as that ID, without changing the minting Store", func() {
+
+				validating := NewStore(nil)
+				So(validating.validateSessionID(cookieValue), ShouldBeNil)
+				So(validating.ID, ShouldEqual, id)
+				So(s.ID, ShouldBeBlank)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitLoadRefreshesLastAccess - Verify that a successful load refreshes the
+// session's last access time
+func TestUnitLoadRefreshesLastAccess(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a valid session ID for a session last accessed an hour ago", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		now := time.Now().Unix()
+		encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+			"expires":     uint32(now + 3600),
+			"last_access": uint32(now - 3600),
+		})
+		So(err, ShouldBeNil)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+
+		Convey("When I load the session", func() {
+
+			s := NewStore(&Cache{connection: connection})
+			err := s.Load(sessionID)
+
+			Convey("Then the last access time should be refreshed", func() {
+
+				So(err, ShouldBeNil)
+
+				lastAccess, ok := s.Data.LastAccess()
+				So(ok, ShouldBeTrue)
+				So(lastAccess.Unix(), ShouldBeGreaterThanOrEqualTo, now)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitLoadSlidingExpiration - Verify that a loaded session's expiry is only
+// extended and re-stored when sliding expiration is enabled
+func TestUnitLoadSlidingExpiration(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a valid session ID for a session expiring in 10 minutes", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		now := time.Now().Unix()
+		expires := uint32(now + 600)
+		encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+			"expires": expires,
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in": uint16(3600),
+				},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+		connection.On("Set", id, mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration")).
+			Return(redis.NewStatusResult("OK", nil))
+
+		cfg := config.Get()
+
+		Convey("When I load the session with sliding expiration enabled", func() {
+
+			cfg.SlidingExpiration = true
+			defer func() { cfg.SlidingExpiration = false }()
+
+			s := NewStore(&Cache{connection: connection})
+			err := s.Load(sessionID)
+
+			Convey("Then the expiry should advance by the expiration period and be re-stored", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Expires, ShouldBeGreaterThanOrEqualTo, uint64(now+3600))
+				So(s.Data["expires"], ShouldEqual, uint32(s.Expires))
+				connection.AssertCalled(t, "Set", id, mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration"))
+			})
+		})
+
+		Convey("When I load the session with sliding expiration disabled", func() {
+
+			s := NewStore(&Cache{connection: connection})
+			err := s.Load(sessionID)
+
+			Convey("Then the expiry should stay fixed and nothing be re-stored", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Expires, ShouldEqual, uint64(expires))
+				connection.AssertNotCalled(t, "Set", id, mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration"))
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitSlideExpirationBelowThreshold - Verify that an expiry which would only
+// move by less than the threshold is left alone
+func TestUnitSlideExpirationBelowThreshold(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a session whose expiry was extended a few seconds ago", t, func() {
+
+		expires := uint64(time.Now().Unix()) + 3600 - 5
+
+		s := NewStore(nil)
+		s.Expires = expires
+		s.Data = map[string]interface{}{
+			"expires": uint32(expires),
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in": uint16(3600),
+				},
+			},
+		}
+
+		Convey("When I slide its expiration", func() {
+
+			slid, err := s.slideExpiration()
+
+			Convey("Then the expiry should not be moved", func() {
+
+				So(err, ShouldBeNil)
+				So(slid, ShouldBeFalse)
+				So(s.Expires, ShouldEqual, expires)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ---------------- Routes Through Touch() ----------------
+
+// TestUnitTouch - Verify that touching a recently stored session only refreshes
+// its TTL, while one due to move on is re-stored
+func TestUnitTouch(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a loaded session", t, func() {
+
+		connection := &mockState.Connection{}
+		connection.On("Expire", "abc", mock.AnythingOfType("time.Duration")).
+			Return(redis.NewBoolResult(true, nil))
+		connection.On("Set", "abc", mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration")).
+			Return(redis.NewStatusResult("OK", nil))
+
+		s := NewStore(&Cache{connection: connection})
+		s.ID = "abc"
+		s.Data = map[string]interface{}{
+			"signin_info": map[string]interface{}{
+				"access_token": map[string]interface{}{
+					"expires_in": uint16(3600),
+				},
+			},
+		}
+
+		Convey("When I touch it a few seconds after its expiry was extended", func() {
+
+			s.Expires = uint64(time.Now().Unix()) + 3600 - 5
+			s.Data["expires"] = uint32(s.Expires)
+
+			err := s.Touch()
+
+			Convey("Then only the TTL should be refreshed, without re-storing the session", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data["last_access"], ShouldNotBeNil)
+				connection.AssertCalled(t, "Expire", "abc", mock.AnythingOfType("time.Duration"))
+				connection.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything)
+
+				ttl := connection.Calls[0].Arguments.Get(1).(time.Duration)
+				So(ttl, ShouldBeBetweenOrEqual, 3590*time.Second, 3595*time.Second)
+			})
+		})
+
+		Convey("When I touch it long after its expiry was extended", func() {
+
+			s.Expires = uint64(time.Now().Unix()) + 600
+			s.Data["expires"] = uint32(s.Expires)
+
+			err := s.Touch()
+
+			Convey("Then the session should be re-stored with its new expiry, without counting a renewal", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Expires, ShouldBeGreaterThan, uint64(time.Now().Unix())+3000)
+				So(s.Data.RenewalCount(), ShouldEqual, 0)
+				connection.AssertCalled(t, "Set", "abc", mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration"))
+				connection.AssertNotCalled(t, "Expire", mock.Anything, mock.Anything)
+
+				ttl := connection.Calls[0].Arguments.Get(2).(time.Duration)
+				So(ttl, ShouldBeBetweenOrEqual, 3599*time.Second, 3600*time.Second)
+			})
+		})
+	})
+
+	Convey("Given I have no loaded session", t, func() {
+
+		s := NewStore(nil)
+
+		Convey("When I touch it", func() {
+
+			err := s.Touch()
+
+			Convey("Then an error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitStoreAfterTouch - Verify that storing a touched session keeps it
+// expiring from the cache, rather than clearing the TTL the touch set
+func TestUnitStoreAfterTouch(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have touched a stored session", t, func() {
+
+		connection := newFakeConnection()
+		cache := &Cache{connection: connection}
+
+		s := NewStore(cache)
+		s.Data = signedInData("user1")
+		So(s.Store(), ShouldBeNil)
+
+		s.Expires -= 5
+		s.Data["expires"] = int64(s.Expires)
+		So(s.Touch(), ShouldBeNil)
+		So(connection.ttls[s.ID], ShouldBeGreaterThan, 0)
+
+		Convey("When I store it again", func() {
+
+			s.Data["test"] = "hello, world!"
+			err := s.Store()
+
+			Convey("Then it should still expire from the cache when the session does", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.ttls[s.ID], ShouldBeBetweenOrEqual, 117*time.Second, 118*time.Second)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitStoreExpiredSession - Verify that a session which has already expired
+// when it is stored again is still given a finite TTL
+func TestUnitStoreExpiredSession(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a session which has already expired", t, func() {
+
+		connection := newFakeConnection()
+		s := NewStore(&Cache{connection: connection})
+		s.Data = signedInData("user1")
+		So(s.Store(), ShouldBeNil)
+
+		s.Expires = uint64(time.Now().Unix()) - 10
+		s.Data["expires"] = int64(s.Expires)
+
+		Convey("When I store it again", func() {
+
+			err := s.Store()
+
+			Convey("Then it should still expire from the cache", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.ttls[s.ID], ShouldEqual, expiredSessionTTL)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through GetValue() and SetValue() -------------------
+
+// TestUnitGetSetValue - Verify that single values are read from and written to
+// the session data, marking the session as dirty
+func TestUnitGetSetValue(t *testing.T) {
+
+	Convey("Given I have a Store with no session data", t, func() {
+
+		s := NewStore(nil)
+
+		Convey("When I get a value", func() {
+
+			value, ok := s.GetValue("page")
+
+			Convey("Then it should be missing", func() {
+
+				So(ok, ShouldBeFalse)
+				So(value, ShouldBeNil)
+				So(s.Dirty(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When I set a value", func() {
+
+			s.SetValue("page", "home")
+
+			Convey("Then the session data should be initialised with it", func() {
+
+				So(map[string]interface{}(s.Data), ShouldResemble, map[string]interface{}{"page": "home"})
+				So(s.Dirty(), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given I have a Store with a value set", t, func() {
+
+		s := NewStore(nil)
+		s.Data = map[string]interface{}{"page": "home"}
+
+		Convey("When I overwrite the value", func() {
+
+			s.SetValue("page", "search")
+			value, ok := s.GetValue("page")
+
+			Convey("Then the new value should be returned", func() {
+
+				So(ok, ShouldBeTrue)
+				So(value, ShouldEqual, "search")
+				So(s.Dirty(), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+// ------------------- Routes Through ClearKey() -------------------
+
+// TestUnitClearKey - Verify that clearing a key removes it from the session
+// data and stores the session, whether or not the key was present
+func TestUnitClearKey(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have loaded a stored session with a redirect", t, func() {
+
+		cfg := config.Get()
+		defaultExpiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "600"
+		defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+		cache := NewMemoryCache()
+		stored := NewStore(cache)
+		stored.Data = map[string]interface{}{"redirect": "/account", "page": "home"}
+		So(stored.Store(), ShouldBeNil)
+
+		s := NewStore(cache)
+		So(s.Load(stored.CookieValue()), ShouldBeNil)
+
+		reload := func() *Store {
+			reloaded := NewStore(cache)
+			So(reloaded.Load(stored.CookieValue()), ShouldBeNil)
+			return reloaded
+		}
+
+		Convey("When I clear the redirect", func() {
+
+			err := s.ClearKey("redirect")
+
+			Convey("Then it should be removed from the stored session, leaving the rest", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data, ShouldNotContainKey, "redirect")
+				So(s.Dirty(), ShouldBeFalse)
+
+				reloaded := reload()
+				So(reloaded.Data, ShouldNotContainKey, "redirect")
+				So(reloaded.Data["page"], ShouldEqual, "home")
+			})
+		})
+
+		Convey("When I clear a key which isn't present", func() {
+
+			s.Data["page"] = "search"
+			err := s.ClearKey("missing")
+
+			Convey("Then the session should still be stored", func() {
+
+				So(err, ShouldBeNil)
+				So(reload().Data["page"], ShouldEqual, "search")
+			})
+		})
+	})
+
+	Convey("Given I have a Store with no session data", t, func() {
+
+		cfg := config.Get()
+		defaultExpiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "600"
+		defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+		s := NewStore(NewMemoryCache())
+
+		Convey("When I clear a key", func() {
+
+			err := s.ClearKey("redirect")
+
+			Convey("Then an empty session should be stored", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data.ApplicationKeys(), ShouldBeEmpty)
+				So(s.ID, ShouldNotBeBlank)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through setupExpiration() -------------------
+
+// TestUnitSetupExpirationDefault - Verify that a session without an expiration
+// period on its data expires after the default expiration
+func TestUnitSetupExpirationDefault(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given the session data has no expiration period", t, func() {
+
+		cfg := config.Get()
+		defaultExpiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "600"
+		defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+		s := NewStore(nil)
+		s.Data = map[string]interface{}{}
+
+		Convey("When the expiration is set up", func() {
+
+			before := uint64(time.Now().Unix())
+			err := s.setupExpiration()
+			after := uint64(time.Now().Unix())
+
+			Convey("Then the session should expire after the default expiration", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Expires, ShouldBeGreaterThanOrEqualTo, before+600)
+				So(s.Expires, ShouldBeLessThanOrEqualTo, after+600)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitSetupExpirationPrecedence - Verify that the expiry is taken from
+// 'expires', then a JWT-style 'exp', then 'expires_in', then the default
+func TestUnitSetupExpirationPrecedence(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given the default expiration is 600 seconds", t, func() {
+
+		cfg := config.Get()
+		defaultExpiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "600"
+		defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+		now := time.Now().Unix()
+		accessToken := map[string]interface{}{"expires_in": uint16(300)}
+
+		Convey("When the session data has 'expires' and 'exp'", func() {
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{"expires": now + 100, "exp": float64(now + 200)}
+			err := s.validateExpiration()
+
+			Convey("Then 'expires' should be used", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Expires, ShouldEqual, uint64(now+100))
+			})
+		})
+
+		Convey("When the session data has a float64 'exp' and 'expires_in'", func() {
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{
+				"exp":         float64(now + 200),
+				"signin_info": map[string]interface{}{"access_token": accessToken},
+			}
+			err := s.validateExpiration()
+
+			Convey("Then 'exp' should be used, as is", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Expires, ShouldEqual, uint64(now+200))
+			})
+		})
+
+		Convey("When the session data has an integer 'exp' which has passed", func() {
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{"exp": int(now - 10)}
+			err := s.validateExpiration()
+
+			Convey("Then the session should have expired", func() {
+
+				So(err, ShouldEqual, ErrSessionExpired)
+			})
+		})
+
+		Convey("When the session data has only 'expires_in'", func() {
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{
+				"signin_info": map[string]interface{}{"access_token": accessToken},
+			}
+			err := s.validateExpiration()
+
+			Convey("Then it should expire after 'expires_in'", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Expires, ShouldBeGreaterThanOrEqualTo, uint64(now+300))
+				So(s.Expires, ShouldBeLessThan, uint64(now+600))
+			})
+		})
+
+		Convey("When the session data has none of them", func() {
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{}
+			err := s.validateExpiration()
+
+			Convey("Then it should expire after the default", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Expires, ShouldBeGreaterThanOrEqualTo, uint64(now+600))
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitStoreInvalidDefaultExpiration - Verify that an unparsable default
+// expiration fails the store with a config error, rather than being defaulted
+func TestUnitStoreInvalidDefaultExpiration(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given the default expiration isn't a number of seconds", t, func() {
+
+		cfg := config.Get()
+		defaultExpiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "ten minutes"
+		defer func() { cfg.DefaultExpiration = defaultExpiration }()
+
+		connection := newFakeConnection()
+		s := NewStore(NewCacheWithConnection(connection))
+		s.Data = map[string]interface{}{"page": "search"}
+
+		Convey("When I store a new session", func() {
+
+			err := s.Store()
+
+			Convey("Then the config error should be returned and nothing stored", func() {
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, `Environment variable DEFAULT_SESSION_EXPIRATION must be a number of seconds, not "ten minutes"`)
+				So(connection.values, ShouldBeEmpty)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// TestUnitSetupExpirationJitter - Verify that a jittered expiry always falls
+// between the expiration period and the period plus the jitter percentage
+func TestUnitSetupExpirationJitter(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a 10% expiration jitter is configured", t, func() {
+
+		cfg := config.Get()
+		defaultExpiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "1000"
+		cfg.ExpirationJitter = 10
+		defer func() {
+			cfg.DefaultExpiration = defaultExpiration
+			cfg.ExpirationJitter = 0
+		}()
+
+		Convey("When the expiration is set up for many sessions", func() {
+
+			var lifetimes []uint64
+			jittered := map[uint64]bool{}
+
+			for i := 0; i < 500; i++ {
+				s := NewStore(nil)
+				s.Data = map[string]interface{}{}
+
+				before := uint64(time.Now().Unix())
+				So(s.setupExpiration(), ShouldBeNil)
+
+				lifetimes = append(lifetimes, s.Expires-before)
+				jittered[s.Expires-before] = true
+			}
+
+			Convey("Then each should expire within the jittered range, spread across it", func() {
+
+				for _, lifetime := range lifetimes {
+					So(lifetime, ShouldBeGreaterThanOrEqualTo, 1000)
+					So(lifetime, ShouldBeLessThanOrEqualTo, 1100+1)
+				}
+				So(len(jittered), ShouldBeGreaterThan, 1)
+			})
+		})
+
+		Convey("When the jitter configured is over the maximum", func() {
+
+			cfg.ExpirationJitter = 500
+
+			s := NewStore(nil)
+			s.Data = map[string]interface{}{}
+
+			before := uint64(time.Now().Unix())
+			err := s.setupExpiration()
+
+			Convey("Then the lifetime should be lengthened by no more than the maximum", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Expires-before, ShouldBeLessThanOrEqualTo, 1000+maxExpirationJitter*10+1)
+			})
+		})
+
+		Convey("When the source of randomness fails", func() {
+
+			s := NewStore(nil).WithRandom(strings.NewReader(""))
+			s.Data = map[string]interface{}{}
+
+			err := s.setupExpiration()
+
+			Convey("Then the error should be returned", func() {
+
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through RegenerateKeepData() -------------------
+
+// TestUnitRegenerateKeepData - Verify that regenerating the ID stores the same
+// data under a new ID and removes the old one
+func TestUnitRegenerateKeepData(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a stored session for a signed in user", t, func() {
+
+		connection := newFakeConnection()
+		cache := NewCacheWithConnection(connection)
+
+		s := NewStore(cache)
+		s.Data = signedInData("user-1")
+		s.Data["cart"] = "basket-1"
+		So(s.Store(), ShouldBeNil)
+		oldID := s.ID
+
+		Convey("When I regenerate its ID keeping its data", func() {
+
+			err := s.RegenerateKeepData()
+
+			Convey("Then the data should be stored under a new ID, and the old one removed", func() {
+
+				So(err, ShouldBeNil)
+				So(s.ID, ShouldNotEqual, oldID)
+				So(s.Data["cart"], ShouldEqual, "basket-1")
+
+				So(connection.values, ShouldNotContainKey, oldID)
+				So(connection.values, ShouldContainKey, s.ID)
+
+				index := connection.sets[userSessionsKey("user-1")]
+				So(index, ShouldNotContainKey, oldID)
+				So(index, ShouldContainKey, s.ID)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through markCreated() -------------------
+
+// TestUnitCreatedAtPreserved - Verify that a new session records when it was
+// created, and keeps it when reloaded while its last access advances
+func TestUnitCreatedAtPreserved(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I store a new session", t, func() {
+
+		cache := NewMemoryCache()
+
+		s := NewStore(cache)
+		s.Data = hashSessionData("user-1")
+		So(s.Store(), ShouldBeNil)
+
+		createdAt, ok := s.Data.CreatedAt()
+		So(ok, ShouldBeTrue)
+
+		// Wind the last access back, as if it had been an hour ago
+		s.Data["last_access"] = uint64(time.Now().Unix() - 3600)
+		So(s.Store(), ShouldBeNil)
+
+		Convey("When I load the session", func() {
+
+			loaded := NewStore(cache)
+			err := loaded.Load(s.CookieValue())
+
+			Convey("Then its creation time should be kept and its last access advanced", func() {
+
+				So(err, ShouldBeNil)
+
+				loadedCreatedAt, ok := loaded.Data.CreatedAt()
+				So(ok, ShouldBeTrue)
+				So(loadedCreatedAt, ShouldEqual, createdAt)
+
+				lastAccess, ok := loaded.Data.LastAccess()
+				So(ok, ShouldBeTrue)
+				So(lastAccess.Unix(), ShouldBeGreaterThanOrEqualTo, createdAt.Unix())
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through SetExpiration() -------------------
+
+// TestUnitSetExpiration - Verify that a "remember me" session keeps its longer
+// lifetime across a store and load, while others get the default
+func TestUnitSetExpiration(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given the default expiration is an hour", t, func() {
+
+		cfg := config.Get()
+		defaultExpiration := cfg.DefaultExpiration
+		cfg.DefaultExpiration = "3600"
+		cfg.SlidingExpiration = true
+		defer func() {
+			cfg.DefaultExpiration = defaultExpiration
+			cfg.SlidingExpiration = false
+		}()
+
+		cache := NewMemoryCache()
+		now := uint64(time.Now().Unix())
+		thirtyDays := uint64(30 * 24 * 60 * 60)
+
+		Convey("When a session is stored with a 30 day expiration and loaded again", func() {
+
+			s := NewStore(cache)
+			s.Data = map[string]interface{}{}
+			s.SetExpiration(thirtyDays)
+			So(s.Store(), ShouldBeNil)
+
+			loaded := NewStore(cache)
+			err := loaded.Load(s.CookieValue())
+
+			Convey("Then it should expire in 30 days", func() {
+
+				So(err, ShouldBeNil)
+				So(loaded.Expires, ShouldBeGreaterThanOrEqualTo, now+thirtyDays)
+			})
+		})
+
+		Convey("When a session is stored without one", func() {
+
+			s := NewStore(cache)
+			s.Data = map[string]interface{}{}
+			So(s.Store(), ShouldBeNil)
+
+			Convey("Then it should expire in an hour", func() {
+
+				So(s.Expires, ShouldBeGreaterThanOrEqualTo, now+3600)
+				So(s.Expires, ShouldBeLessThan, now+thirtyDays)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
+
+// ------------------- Routes Through Reload() -------------------
+
+// TestUnitReload - Verify that reloading replaces local changes with the latest
+// stored data, and clears the data once the session is no longer stored
+func TestUnitReload(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have loaded a session and changed it locally", t, func() {
+
+		cache := NewMemoryCache()
+
+		stored := NewStore(cache)
+		stored.Data = hashSessionData("user-1")
+		stored.Data["step"] = "1"
+		So(stored.Store(), ShouldBeNil)
+
+		s := NewStore(cache)
+		So(s.Load(stored.CookieValue()), ShouldBeNil)
+		s.SetValue("page", "local")
+
+		Convey("When another request stores a change and I reload", func() {
+
+			stored.Data["step"] = "2"
+			So(stored.Store(), ShouldBeNil)
+
+			err := s.Reload()
+
+			Convey("Then the latest stored data should replace the local changes", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data["step"], ShouldEqual, "2")
+				So(s.Data, ShouldNotContainKey, "page")
+				So(s.Dirty(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When another request deletes the session and I reload", func() {
+
+			So(stored.Delete(nil), ShouldBeNil)
+
+			err := s.Reload()
+
+			Convey("Then the data should be cleared", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data, ShouldBeEmpty)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,136 @@
+package state
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/companieshouse/go-session-handler/session"
+)
+
+//ErrHashSweep is returned by SweepExpired when the Cache stores sessions as
+//hashes, which can't be fetched in batches with MGET.
+var ErrHashSweep = errors.New("Sweeping expired sessions is not supported when storing sessions as hashes")
+
+//compareAndDeleteScript deletes KEYS[1] only if its current value is ARGV[1].
+//It returns the number of keys deleted.
+const compareAndDeleteScript = `
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call("DEL", KEYS[1])
+`
+
+//SweepExpired deletes every stored session whose 'expires' time has passed,
+//e.g. sessions written before keys were given a TTL, returning the number
+//deleted. Keys are found with SCAN and fetched with MGET, batchSize at a time,
+//so that Redis isn't blocked. A session is only deleted if it is unchanged
+//since it was fetched, so one refreshed by a live request in the meantime is
+//kept. Sessions which can't be decoded are logged and skipped rather than
+//deleted.
+func (s *Store) SweepExpired(batchSize int) (int, error) {
+
+	if s.cache.hashes {
+		return 0, ErrHashSweep
+	}
+	if batchSize <= 0 {
+		batchSize = scanCount
+	}
+
+	var cursor uint64
+	deleted := 0
+
+	for {
+		keys, next, err := s.cache.getConnection().Scan(cursor, "*", int64(batchSize)).Result()
+		if err != nil {
+			return deleted, err
+		}
+
+		swept, err := s.sweepKeys(keys)
+		deleted += swept
+		if err != nil {
+			return deleted, err
+		}
+
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
+}
+
+//sweepKeys deletes the expired sessions among the given keys, ignoring any key
+//which doesn't hold a session.
+func (s *Store) sweepKeys(keys []string) (int, error) {
+
+	var sessionKeys []string
+	for _, key := range keys {
+		if s.isSessionKey(key) {
+			sessionKeys = append(sessionKeys, key)
+		}
+	}
+	if len(sessionKeys) == 0 {
+		return 0, nil
+	}
+
+	stored, err := s.cache.getManySessionData(sessionKeys...)
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.now().Unix()
+	deleted := 0
+
+	for key, encoded := range stored {
+		data, err := s.decodeSession(encoded, key, "")
+		if err != nil {
+			s.getLogger().Warn("Session could not be decoded, so was not swept: "+err.Error(), map[string]interface{}{"session_id": key})
+			continue
+		}
+
+		if expires, ok := session.ToInt64(data["expires"]); !ok || expires > now {
+			continue
+		}
+
+		removed, err := s.cache.compareAndDeleteSessionData(key, encoded)
+		if err != nil {
+			return deleted, err
+		}
+		if removed {
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+//isSessionKey reports whether the key is one a session may be stored under by
+//the Store, i.e. a session ID suffixed with one of its key versions, rather
+//than e.g. a remember token or user index.
+func (s *Store) isSessionKey(key string) bool {
+	for _, version := range append([]string{s.keyVersion}, s.olderKeyVersions...) {
+		id := key
+		if version != "" {
+			if !strings.HasSuffix(key, keyVersionSeparator+version) {
+				continue
+			}
+			id = strings.TrimSuffix(key, keyVersionSeparator+version)
+		}
+
+		if len(id) == signatureStart() && !strings.ContainsAny(id, ":"+keyVersionSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+//compareAndDeleteSessionData removes the Session data at key only if the value
+//currently stored there is expected, returning false with a nil error if not.
+func (c *Cache) compareAndDeleteSessionData(key string, expected string) (bool, error) {
+	result, err := c.getConnection().Eval(compareAndDeleteScript, []string{key}, expected).Result()
+	if err != nil {
+		return false, err
+	}
+
+	removed, _ := result.(int64)
+	return removed == 1, nil
+}
@@ -0,0 +1,105 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ------------------- Routes Through SweepExpired() -------------------
+
+// TestUnitSweepExpired - Verify that only sessions which have expired are swept,
+// leaving valid and corrupt sessions and other keys alone
+func TestUnitSweepExpired(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given the cache holds a mix of expired, valid and corrupt sessions", t, func() {
+
+		now := time.Now().Unix()
+		connection := newFakeConnection()
+
+		encode := func(expires int64) string {
+			encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{"expires": expires})
+			So(err, ShouldBeNil)
+			return encoded
+		}
+
+		expired := []string{strings.Repeat("a", signatureStart()), strings.Repeat("b", signatureStart())}
+		valid := strings.Repeat("c", signatureStart())
+		corrupt := strings.Repeat("d", signatureStart())
+
+		connection.Set(expired[0], encode(now-60), 0)
+		connection.Set(valid, encode(now+60), 0)
+		connection.Set(corrupt, "not a session", 0)
+		connection.Set(rememberKeyPrefix+expired[0], encode(now-60), 0)
+		connection.SAdd(userSessionsKey("user-1"), valid)
+		connection.Set(expired[1], encode(now-1), 0)
+
+		s := NewStore(NewCacheWithConnection(connection))
+
+		Convey("When I sweep expired sessions in batches", func() {
+
+			deleted, err := s.SweepExpired(2)
+
+			Convey("Then only the expired sessions should be deleted", func() {
+
+				So(err, ShouldBeNil)
+				So(deleted, ShouldEqual, 2)
+				So(connection.values, ShouldNotContainKey, expired[0])
+				So(connection.values, ShouldNotContainKey, expired[1])
+				So(connection.values, ShouldContainKey, valid)
+				So(connection.values, ShouldContainKey, corrupt)
+				So(connection.values, ShouldContainKey, rememberKeyPrefix+expired[0])
+				So(connection.sets, ShouldContainKey, userSessionsKey("user-1"))
+			})
+		})
+
+		Convey("When an expired session is refreshed after being fetched", func() {
+
+			removed, err := s.cache.compareAndDeleteSessionData(expired[0], encode(now-120))
+
+			Convey("Then it should not be deleted", func() {
+
+				So(err, ShouldBeNil)
+				So(removed, ShouldBeFalse)
+				So(connection.values, ShouldContainKey, expired[0])
+			})
+		})
+
+		Convey("When sessions are stored under key versions", func() {
+
+			s.WithKeyVersions("v2", "")
+			connection.Set(versionedKey(strings.Repeat("e", signatureStart()), "v2"), encode(now-60), 0)
+			connection.Set(versionedKey(strings.Repeat("f", signatureStart()), "v1"), encode(now-60), 0)
+
+			deleted, err := s.SweepExpired(0)
+
+			Convey("Then expired sessions under the Store's versions should be deleted", func() {
+
+				So(err, ShouldBeNil)
+				So(deleted, ShouldEqual, 3)
+				So(connection.values, ShouldContainKey, versionedKey(strings.Repeat("f", signatureStart()), "v1"))
+			})
+		})
+	})
+
+	Convey("Given the cache stores sessions as hashes", t, func() {
+
+		s := NewStore(NewHashCacheWithConnection(newFakeConnection()))
+
+		Convey("When I sweep expired sessions", func() {
+
+			_, err := s.SweepExpired(10)
+
+			Convey("Then ErrHashSweep should be returned", func() {
+
+				So(err, ShouldEqual, ErrHashSweep)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,79 @@
+package state
+
+import "context"
+
+//Tracer starts spans around the Redis operations performed by a Store, so that
+//session overhead shows up in distributed traces. An adapter can wrap an
+//OpenTelemetry trace.Tracer, or any other tracing library.
+type Tracer interface {
+	// StartSpan starts a span with the given name and attributes as a child of
+	// any span in ctx. Keys are never passed as attributes, only their length.
+	StartSpan(ctx context.Context, name string, attributes map[string]interface{}) Span
+}
+
+//Span is a single traced operation, ended once the operation has completed.
+type Span interface {
+	End(err error)
+}
+
+//noopTracer is the default Tracer, which doesn't trace anything.
+type noopTracer struct{}
+
+//noopSpan is the Span started by the noopTracer.
+type noopSpan struct{}
+
+func (noopTracer) StartSpan(ctx context.Context, name string, attributes map[string]interface{}) Span {
+	return noopSpan{}
+}
+
+func (noopSpan) End(err error) {}
+
+//WithTracer sets the Tracer used to trace the Store's Redis operations.
+func (s *Store) WithTracer(tracer Tracer) *Store {
+	s.tracer = tracer
+	return s
+}
+
+//WithContext sets the context that spans for the Store's Redis operations are
+//started in, e.g. the context of the request the session belongs to.
+func (s *Store) WithContext(ctx context.Context) *Store {
+	s.ctx = ctx
+	return s
+}
+
+//startSpan starts a span for a Redis operation on keys of the given total
+//length, using the Store's Tracer and context.
+func (s *Store) startSpan(operation string, keyLength int) Span {
+	tracer := s.tracer
+	if tracer == nil {
+		tracer = noopTracer{}
+	}
+
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return tracer.StartSpan(ctx, "session."+operation, map[string]interface{}{
+		"db.system":    "redis",
+		"db.operation": operation,
+		"key_length":   keyLength,
+	})
+}
+
+//deleteKeys removes the given keys from the Cache, tracing the operation.
+func (s *Store) deleteKeys(keys ...string) error {
+	span := s.startSpan("del", keysLength(keys))
+	err := s.cache.deleteSessionData(keys...)
+	span.End(err)
+	return err
+}
+
+//keysLength returns the combined length of the keys, to be recorded on a span.
+func keysLength(keys []string) int {
+	length := 0
+	for _, key := range keys {
+		length += len(key)
+	}
+	return length
+}
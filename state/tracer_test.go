@@ -0,0 +1,103 @@
+package state
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	redis "gopkg.in/redis.v5"
+)
+
+type contextKey string
+
+// recordedSpan is a span captured by the recordingTracer.
+type recordedSpan struct {
+	name       string
+	attributes map[string]interface{}
+	parent     interface{}
+	ended      bool
+	err        error
+}
+
+func (s *recordedSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+// recordingTracer is an in-memory Tracer which records every span started.
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) StartSpan(ctx context.Context, name string, attributes map[string]interface{}) Span {
+	span := &recordedSpan{name: name, attributes: attributes, parent: ctx.Value(contextKey("parent"))}
+	t.spans = append(t.spans, span)
+	return span
+}
+
+// ------------------- Routes Through WithTracer() -------------------
+
+// TestUnitTracerSpans - Verify that a span is recorded for each Redis operation,
+// in the Store's context, with the key's length but not the key itself
+func TestUnitTracerSpans(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given I have a Store with a Tracer and a context", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id).Return(redis.NewStringResult("", redis.Nil))
+		connection.On("Set", id, mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration")).
+			Return(redis.NewStatusResult("OK", nil))
+		connection.On("Del", id).Return(redis.NewIntResult(1, nil))
+
+		tracer := &recordingTracer{}
+		ctx := context.WithValue(context.Background(), contextKey("parent"), "request")
+
+		s := NewStore(&Cache{connection: connection}).WithTracer(tracer).WithContext(ctx)
+
+		Convey("When I load, store and delete the session", func() {
+
+			So(s.Load(sessionID), ShouldBeNil)
+			s.ID = id
+			s.Data = map[string]interface{}{
+				"signin_info": map[string]interface{}{
+					"access_token": map[string]interface{}{
+						"expires_in": uint16(123),
+					},
+				},
+			}
+			So(s.Store(), ShouldBeNil)
+			So(s.Delete(nil), ShouldBeNil)
+
+			Convey("Then a span should be recorded for each operation", func() {
+
+				So(tracer.spans, ShouldHaveLength, 3)
+
+				for i, op := range []string{"get", "set", "del"} {
+					span := tracer.spans[i]
+					So(span.name, ShouldEqual, "session."+op)
+					So(span.attributes["db.operation"], ShouldEqual, op)
+					So(span.attributes["key_length"], ShouldEqual, len(id))
+					So(span.parent, ShouldEqual, "request")
+					So(span.ended, ShouldBeTrue)
+
+					for _, value := range span.attributes {
+						So(value, ShouldNotEqual, id)
+					}
+				}
+
+				So(tracer.spans[0].err, ShouldEqual, redis.Nil)
+			})
+		})
+	})
+
+	cleanupConfig()
+}
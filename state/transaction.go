@@ -0,0 +1,112 @@
+package state
+
+import (
+	"errors"
+	"time"
+
+	redis "gopkg.in/redis.v5"
+)
+
+//ErrTransactionsUnsupported is returned when storing a session with
+//transactions enabled on a Cache whose Connection can't execute them.
+var ErrTransactionsUnsupported = errors.New("Cache connection does not support transactions")
+
+//Transaction queues commands to be executed atomically, in a single MULTI/EXEC
+//transaction, once Exec is called. The results of the queued commands are only
+//available after Exec.
+type Transaction interface {
+	Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	SAdd(key string, members ...interface{}) *redis.IntCmd
+	Del(keys ...string) *redis.IntCmd
+	HMSet(key string, fields map[string]string) *redis.StatusCmd
+	HDel(key string, fields ...string) *redis.IntCmd
+	Expire(key string, expiration time.Duration) *redis.BoolCmd
+	Eval(script string, keys []string, args ...interface{}) *redis.Cmd
+	Exec() ([]redis.Cmder, error)
+}
+
+//TxConnection is a Connection which can execute transactions.
+type TxConnection interface {
+	Connection
+	TxPipeline() Transaction
+}
+
+//redisConnection adapts a Redis client to TxConnection, since the client's own
+//TxPipeline returns its concrete pipeline type.
+type redisConnection struct {
+	*redis.Client
+}
+
+func (c redisConnection) TxPipeline() Transaction {
+	return c.Client.TxPipeline()
+}
+
+//WithTransactions makes storing a session write the session, delete any copy
+//of it under an older key version and add it to its user's index in a single
+//MULTI/EXEC transaction, so that a failure part way through can't leave them
+//inconsistent: either every key changes or none does. It only applies to
+//unconditional string stores; with index batching enabled, the index is still
+//written behind, outside the transaction.
+func (c *Cache) WithTransactions() *Cache {
+	c.transactions = true
+	return c
+}
+
+//storeSessionTx stores the Session data at key, deletes the keys in del and, if
+//indexKey is given, adds the member to the index at indexKey, all in a single
+//transaction.
+func (c *Cache) storeSessionTx(key string, value interface{}, expiration time.Duration, del []string, indexKey string, member string) error {
+	connection, ok := c.getConnection().(TxConnection)
+	if !ok {
+		return ErrTransactionsUnsupported
+	}
+
+	tx := connection.TxPipeline()
+	tx.Set(key, value, expiration)
+	if len(del) > 0 {
+		tx.Del(del...)
+	}
+	if indexKey != "" {
+		tx.Eval(indexAddScript, []string{indexKey}, indexAddArgs(expiration, []interface{}{member})...)
+	}
+
+	_, err := tx.Exec()
+	return err
+}
+
+//storeSessionTx will save the encoded session in Redis together with its
+//related keys, in a single transaction.
+func (s *Store) storeSessionTx(encodedData string) error {
+
+	var del []string
+	if s.migrateFromKey != "" {
+		del = append(del, s.migrateFromKey)
+	}
+
+	// Batched index additions are written behind, so can't join the transaction
+	var indexKey string
+	userID := s.Data.GetUserID()
+	if userID != "" && s.cache.batcher == nil {
+		indexKey = userSessionsKey(userID)
+	}
+
+	key := s.sessionKey()
+	span := s.startSpan("multi", len(key))
+	start, dials := time.Now(), s.cache.dials()
+	err := s.cache.storeSessionTx(key, encodedData, s.cacheTTL(), del, indexKey, s.ID)
+	span.End(err)
+	s.observe("multi", start, dials, err)
+	s.getMetrics().ObserveRedisLatency(time.Since(start))
+	if err != nil {
+		return err
+	}
+
+	s.getMetrics().IncStore()
+	s.dirty = false
+	s.migrateFromKey = ""
+
+	if userID != "" && indexKey == "" {
+		return s.cache.addToIndex(userSessionsKey(userID), s.ID, s.cacheTTL())
+	}
+	return nil
+}
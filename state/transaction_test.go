@@ -0,0 +1,142 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// txConnection is a fakeConnection which can execute transactions, failing them
+// with err if it is set
+type txConnection struct {
+	*fakeConnection
+	err error
+}
+
+func (c *txConnection) TxPipeline() Transaction {
+	return &fakeTransaction{connection: c}
+}
+
+// fakeTransaction queues commands, applying them to the fakeConnection only if
+// the transaction succeeds
+type fakeTransaction struct {
+	connection *txConnection
+	queued     []func()
+}
+
+func (t *fakeTransaction) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	t.queued = append(t.queued, func() { t.connection.Set(key, value, expiration) })
+	return redis.NewStatusResult("QUEUED", nil)
+}
+
+func (t *fakeTransaction) SAdd(key string, members ...interface{}) *redis.IntCmd {
+	t.queued = append(t.queued, func() { t.connection.SAdd(key, members...) })
+	return redis.NewIntResult(0, nil)
+}
+
+func (t *fakeTransaction) Del(keys ...string) *redis.IntCmd {
+	t.queued = append(t.queued, func() { t.connection.Del(keys...) })
+	return redis.NewIntResult(0, nil)
+}
+
+func (t *fakeTransaction) HMSet(key string, fields map[string]string) *redis.StatusCmd {
+	t.queued = append(t.queued, func() { t.connection.HMSet(key, fields) })
+	return redis.NewStatusResult("QUEUED", nil)
+}
+
+func (t *fakeTransaction) HDel(key string, fields ...string) *redis.IntCmd {
+	t.queued = append(t.queued, func() { t.connection.HDel(key, fields...) })
+	return redis.NewIntResult(0, nil)
+}
+
+func (t *fakeTransaction) Expire(key string, expiration time.Duration) *redis.BoolCmd {
+	t.queued = append(t.queued, func() { t.connection.Expire(key, expiration) })
+	return redis.NewBoolResult(false, nil)
+}
+
+func (t *fakeTransaction) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	t.queued = append(t.queued, func() { t.connection.Eval(script, keys, args...) })
+	return redis.NewCmdResult(nil, nil)
+}
+
+func (t *fakeTransaction) Exec() ([]redis.Cmder, error) {
+	if t.connection.err != nil {
+		return nil, t.connection.err
+	}
+	for _, command := range t.queued {
+		command()
+	}
+	return nil, nil
+}
+
+// ------------------- Routes Through WithTransactions() -------------------
+
+// TestUnitStoreWithTransactions - Verify that the session, the copy under its
+// older key version and its user's index are all updated by a successful
+// transaction, and none of them by a failed one
+func TestUnitStoreWithTransactions(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a session stored under an older key version, and a Cache with transactions", t, func() {
+
+		connection := &txConnection{fakeConnection: newFakeConnection()}
+		cache := NewCacheWithConnection(connection).WithTransactions()
+
+		old := NewStore(cache)
+		old.Data = hashSessionData("user-1")
+		So(old.Store(), ShouldBeNil)
+		stored := connection.values[old.ID]
+
+		// Stored without a version, so not yet indexed for this test
+		delete(connection.sets, userSessionsKey("user-1"))
+
+		s := NewStore(cache).WithKeyVersions("v2", "")
+		So(s.Load(old.CookieValue()), ShouldBeNil)
+		s.Data["page"] = "home"
+
+		Convey("When the transaction fails", func() {
+
+			connection.err = errors.New("EXECABORT")
+			err := s.Store()
+
+			Convey("Then none of the keys should have changed", func() {
+
+				So(err, ShouldEqual, connection.err)
+				So(connection.values[old.ID], ShouldEqual, stored)
+				So(connection.values, ShouldNotContainKey, versionedKey(old.ID, "v2"))
+				So(connection.sets, ShouldNotContainKey, userSessionsKey("user-1"))
+			})
+		})
+
+		Convey("When the transaction succeeds", func() {
+
+			err := s.Store()
+
+			Convey("Then every key should have been updated", func() {
+
+				So(err, ShouldBeNil)
+				So(connection.values, ShouldNotContainKey, old.ID)
+				So(connection.values, ShouldContainKey, versionedKey(old.ID, "v2"))
+				So(connection.sets[userSessionsKey("user-1")], ShouldContainKey, old.ID)
+			})
+		})
+	})
+
+	Convey("Given a Cache with transactions whose connection can't execute them", t, func() {
+
+		s := NewStore(NewCacheWithConnection(newFakeConnection()).WithTransactions())
+		s.Data = hashSessionData("user-1")
+
+		Convey("Then storing should fail", func() {
+
+			So(s.Store(), ShouldEqual, ErrTransactionsUnsupported)
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,111 @@
+package state
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+)
+
+//transformSeparator separates a Transform's marker from the data it applied to.
+//It never appears in base64 encoded data.
+const transformSeparator = ":"
+
+//Transform is a reversible step, such as compression, applied to the session
+//data between it being serialized and stored. Each Transform's output is
+//prefixed with its marker, so that data stored before it was enabled is still
+//loaded, and a future Transform can be slotted in without migrating sessions.
+type Transform interface {
+	//Marker identifies data this Transform has been applied to. It must be
+	//unique among the Store's Transforms and mustn't contain ":".
+	Marker() string
+	//Apply transforms the data before it is stored.
+	Apply(data string) (string, error)
+	//Reverse undoes Apply when the data is loaded.
+	Reverse(data string) (string, error)
+}
+
+//WithTransforms sets the Transforms applied, in order, to the serialized session
+//data when it is stored, and undone in reverse order when it is loaded. The
+//integrity check, if enabled, is always computed over the transformed data.
+func (s *Store) WithTransforms(transforms ...Transform) *Store {
+	s.transforms = transforms
+	return s
+}
+
+//applyTransforms runs each of the Store's Transforms over the serialized data
+//in turn, marking the output of each.
+func (s *Store) applyTransforms(data string) (string, error) {
+	for _, transform := range s.transforms {
+		transformed, err := transform.Apply(data)
+		if err != nil {
+			return "", err
+		}
+		data = transform.Marker() + transformSeparator + transformed
+	}
+	return data, nil
+}
+
+//reverseTransforms undoes each of the Store's Transforms in reverse order. A
+//Transform whose marker the data doesn't start with is passed over, since the
+//data was stored before it was enabled.
+func (s *Store) reverseTransforms(data string) (string, error) {
+	for i := len(s.transforms) - 1; i >= 0; i-- {
+		prefix := s.transforms[i].Marker() + transformSeparator
+		if !strings.HasPrefix(data, prefix) {
+			continue
+		}
+
+		reversed, err := s.transforms[i].Reverse(data[len(prefix):])
+		if err != nil {
+			return "", err
+		}
+		data = reversed
+	}
+	return data, nil
+}
+
+//GzipTransform compresses the session data with gzip, which suits large
+//sessions at the cost of some CPU on every load and store.
+type GzipTransform struct{}
+
+//Marker returns "gz".
+func (GzipTransform) Marker() string {
+	return "gz"
+}
+
+//Apply gzips the data and base64 encodes the result.
+func (GzipTransform) Apply(data string) (string, error) {
+	var compressed bytes.Buffer
+
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(compressed.Bytes()), nil
+}
+
+//Reverse base64 decodes the data and gunzips the result.
+func (GzipTransform) Reverse(data string) (string, error) {
+	compressed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(decompressed), nil
+}
@@ -0,0 +1,116 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/companieshouse/go-session-handler/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// reverseTransform reverses the data, standing in for a future Transform
+type reverseTransform struct{}
+
+func (reverseTransform) Marker() string {
+	return "rev"
+}
+
+func (reverseTransform) Apply(data string) (string, error) {
+	runes := []rune(data)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes), nil
+}
+
+func (t reverseTransform) Reverse(data string) (string, error) {
+	return t.Apply(data)
+}
+
+// ------------------- Routes Through WithTransforms() -------------------
+
+// TestUnitTransformsRoundTrip - Verify that a session round trips through every
+// combination of Transforms, with and without the integrity check
+func TestUnitTransformsRoundTrip(t *testing.T) {
+
+	initConfig()
+
+	combinations := map[string][]Transform{
+		"no transforms":     nil,
+		"gzip":              {GzipTransform{}},
+		"reverse":           {reverseTransform{}},
+		"gzip then reverse": {GzipTransform{}, reverseTransform{}},
+		"reverse then gzip": {reverseTransform{}, GzipTransform{}},
+	}
+
+	for _, integrity := range []bool{false, true} {
+		for name, transforms := range combinations {
+
+			checked := "without"
+			if integrity {
+				checked = "with"
+			}
+
+			Convey("Given a Store with "+name+", "+checked+" data integrity checks", t, func() {
+
+				cfg := config.Get()
+				cfg.DataIntegrity = integrity
+				defer func() { cfg.DataIntegrity = false }()
+
+				cache := NewMemoryCache()
+				stored := NewStore(cache).WithTransforms(transforms...)
+				stored.Data = hashSessionData("user-1")
+				stored.Data["page"] = strings.Repeat("home ", 20)
+				So(stored.Store(), ShouldBeNil)
+
+				Convey("When the session is loaded with the same Transforms", func() {
+
+					s := NewStore(cache).WithTransforms(transforms...)
+					err := s.Load(stored.CookieValue())
+
+					Convey("Then its data should be intact", func() {
+
+						So(err, ShouldBeNil)
+						So(s.Data.GetUserID(), ShouldEqual, "user-1")
+						So(s.Data["page"], ShouldEqual, stored.Data["page"])
+					})
+				})
+			})
+		}
+	}
+
+	cleanupConfig()
+}
+
+// TestUnitTransformsBackwardCompatible - Verify that sessions stored before a
+// Transform was enabled are still loaded, and marked when stored again
+func TestUnitTransformsBackwardCompatible(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a session stored without any Transforms", t, func() {
+
+		connection := newFakeConnection()
+		cache := NewCacheWithConnection(connection)
+
+		stored := NewStore(cache)
+		stored.Data = hashSessionData("user-1")
+		So(stored.Store(), ShouldBeNil)
+
+		Convey("When it is loaded and stored again with gzip enabled", func() {
+
+			s := NewStore(cache).WithTransforms(GzipTransform{})
+			err := s.Load(stored.CookieValue())
+			So(s.Store(), ShouldBeNil)
+
+			Convey("Then it should load, and be stored compressed", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data.GetUserID(), ShouldEqual, "user-1")
+				So(connection.values[s.ID], ShouldStartWith, "gz:")
+			})
+		})
+	})
+
+	cleanupConfig()
+}
@@ -0,0 +1,40 @@
+package state
+
+//keyVersionSeparator separates a session ID from the version suffix of its key.
+const keyVersionSeparator = "@"
+
+//WithKeyVersions stores sessions under their ID suffixed with the current
+//version, e.g. "id@v2", so that the storage schema can be changed without
+//migrating every session at once. If a session isn't found under the current
+//version, Load falls back to each older version in turn, where "" is the
+//unsuffixed ID, and a session found under an older version is moved to the
+//current one when it is next stored.
+func (s *Store) WithKeyVersions(current string, older ...string) *Store {
+	s.keyVersion = current
+	s.olderKeyVersions = older
+	return s
+}
+
+//versionedKey returns the key a session with the given ID is stored under for
+//the given version.
+func versionedKey(id string, version string) string {
+	if version == "" {
+		return id
+	}
+	return id + keyVersionSeparator + version
+}
+
+//sessionKey returns the key the Store's session is stored under.
+func (s *Store) sessionKey() string {
+	return versionedKey(s.ID, s.keyVersion)
+}
+
+//sessionKeys returns the keys a session with the given ID may be stored under,
+//starting with the current version.
+func (s *Store) sessionKeys(id string) []string {
+	keys := []string{versionedKey(id, s.keyVersion)}
+	for _, version := range s.olderKeyVersions {
+		keys = append(keys, versionedKey(id, version))
+	}
+	return keys
+}
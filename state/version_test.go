@@ -0,0 +1,93 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mockState "github.com/companieshouse/go-session-handler/state/mocks"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	redis "gopkg.in/redis.v5"
+)
+
+// ------------------- Routes Through WithKeyVersions() -------------------
+
+// TestUnitKeyVersionsMigrateOnStore - Verify that a session stored under an older
+// key version is found, and is re-stored under the current one
+func TestUnitKeyVersionsMigrateOnStore(t *testing.T) {
+
+	initConfig()
+
+	Convey("Given a session is only stored under its unsuffixed legacy key", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+		sessionID := id + generateSignature(id)
+
+		encoded, err := MsgPackSerializer{}.Encode(map[string]interface{}{
+			"test":    "hello, world!",
+			"expires": uint32(time.Now().Unix() + 600),
+		})
+		So(err, ShouldBeNil)
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id+"@v3").Return(redis.NewStringResult("", redis.Nil))
+		connection.On("Get", id+"@v2").Return(redis.NewStringResult("", redis.Nil))
+		connection.On("Get", id).Return(redis.NewStringResult(encoded, nil))
+		connection.On("Set", id+"@v3", mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration")).
+			Return(redis.NewStatusResult("OK", nil))
+		connection.On("Del", id).Return(redis.NewIntResult(1, nil))
+
+		s := NewStore(&Cache{connection: connection}).WithKeyVersions("v3", "v2", "")
+
+		Convey("When I load the session", func() {
+
+			err := s.Load(sessionID)
+
+			Convey("Then it should be found under the legacy key", func() {
+
+				So(err, ShouldBeNil)
+				So(s.Data["test"], ShouldEqual, "hello, world!")
+				connection.AssertCalled(t, "Get", id+"@v3")
+				connection.AssertCalled(t, "Get", id+"@v2")
+				connection.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything)
+			})
+
+			Convey("And when I store it", func() {
+
+				So(s.Store(), ShouldBeNil)
+
+				Convey("Then it should be moved under the current version", func() {
+
+					connection.AssertCalled(t, "Set", id+"@v3", mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration"))
+					connection.AssertCalled(t, "Del", id)
+				})
+			})
+		})
+	})
+
+	Convey("Given no session is stored under any key version", t, func() {
+
+		id := strings.Repeat("a", signatureStart())
+
+		connection := &mockState.Connection{}
+		connection.On("Get", id+"@v2").Return(redis.NewStringResult("", redis.Nil))
+		connection.On("Get", id).Return(redis.NewStringResult("", redis.Nil))
+
+		s := NewStore(&Cache{connection: connection}).WithKeyVersions("v2", "")
+		s.ID = id
+
+		Convey("When I fetch the session", func() {
+
+			_, err := s.fetchSession()
+
+			Convey("Then ErrSessionNotFound should be returned", func() {
+
+				So(err, ShouldEqual, ErrSessionNotFound)
+			})
+		})
+	})
+
+	cleanupConfig()
+}